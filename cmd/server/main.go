@@ -4,73 +4,252 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/sven-borkert/b2b-ingress-manager/internal/api"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/auth"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/cluster"
 	"github.com/sven-borkert/b2b-ingress-manager/internal/database"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/declarative"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/filestore"
 	"github.com/sven-borkert/b2b-ingress-manager/internal/health"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/maintenance"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/metrics"
 	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
 	"github.com/sven-borkert/b2b-ingress-manager/internal/nftables"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/updater"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 )
 
+// createToken is the name of an API token to create instead of starting
+// the manager
+var createToken = flag.String("create-token", "", "Create an admin API token with the given name, print it and exit")
+
+// Declarative configuration to export or import instead of starting the
+// manager
+var (
+	exportFile  = flag.String("export", "", "Export the routing configuration to a YAML or JSON file (- for stdout) and exit")
+	importFile  = flag.String("import", "", "Import the routing configuration from a YAML or JSON file and exit")
+	importPrune = flag.Bool("import-prune", false, "Delete entities missing from the imported configuration")
+	importDry   = flag.Bool("dry-run", false, "Print the changes of an import without applying them")
+)
+
+// storageFile keeps the routing configuration in a file and everything
+// else in SQLite
+const storageFile = "file"
+
+// configStore is the storage of the API, health checks and the updater
+type configStore interface {
+	api.Store
+	health.Store
+	updater.Store
+}
+
 // Config holds the application configuration
 type Config struct {
-	LogLevel            string        `yaml:"log_level"`
-	DBHost              string        `yaml:"db_host"`
-	DBPort              int           `yaml:"db_port"`
-	DBUser              string        `yaml:"db_user"`
-	DBPassword          string        `yaml:"db_password"`
-	DBName              string        `yaml:"db_name"`
-	DBSSLMode           string        `yaml:"db_sslmode"`
-	APIListenAddr       string        `yaml:"api_listen"`
-	UpdateInterval      time.Duration `yaml:"update_interval"`
-	HealthCheckTimeout  time.Duration `yaml:"health_timeout"`
-	HealthCheckInterval time.Duration `yaml:"health_interval"`
-	NFTablesTable       string        `yaml:"nft_table"`
-	NFTablesChain       string        `yaml:"nft_chain"`
+	LogLevel              string        `yaml:"log_level"`
+	Storage               string        `yaml:"storage"`
+	SQLitePath            string        `yaml:"sqlite_path"`
+	RoutingFile           string        `yaml:"routing_file"`
+	RoutingFileInterval   time.Duration `yaml:"routing_file_interval"`
+	NodeName              string        `yaml:"node_name"`
+	NodeGroup             string        `yaml:"node_group"`
+	NodeHealth            bool          `yaml:"node_health"`
+	LeaderLockID          int64         `yaml:"leader_lock_id"`
+	LeaderInterval        time.Duration `yaml:"leader_interval"`
+	DBHost                string        `yaml:"db_host"`
+	DBPort                int           `yaml:"db_port"`
+	DBUser                string        `yaml:"db_user"`
+	DBPassword            string        `yaml:"db_password"`
+	DBName                string        `yaml:"db_name"`
+	DBSSLMode             string        `yaml:"db_sslmode"`
+	APIListenAddr         string        `yaml:"api_listen"`
+	APITLSCert            string        `yaml:"api_tls_cert"`
+	APITLSKey             string        `yaml:"api_tls_key"`
+	APITLSMinVersion      string        `yaml:"api_tls_min_version"`
+	APITLSCiphers         []string      `yaml:"api_tls_ciphers"`
+	APITLSClientCA        string        `yaml:"api_tls_client_ca"`
+	APITLSClientAuth      string        `yaml:"api_tls_client_auth"`
+	APIUnixMode           string        `yaml:"api_unix_mode"`
+	APIUnixGroup          string        `yaml:"api_unix_group"`
+	UpdateInterval        time.Duration `yaml:"update_interval"`
+	UpdateDebounce        time.Duration `yaml:"update_debounce"`
+	ReadyStaleThreshold   time.Duration `yaml:"ready_stale_threshold"`
+	AuthTokens            bool          `yaml:"auth_tokens"`
+	AuthJWKSFile          string        `yaml:"auth_jwks_file"`
+	AuthJWTIssuer         string        `yaml:"auth_jwt_issuer"`
+	AuthJWTAudience       string        `yaml:"auth_jwt_audience"`
+	AuthJWTClaim          string        `yaml:"auth_jwt_claim"`
+	AuthAdmins            []string      `yaml:"auth_admins"`
+	DriftInterval         time.Duration `yaml:"drift_interval"`
+	DriftReapply          bool          `yaml:"drift_reapply"`
+	HealthCheckTimeout    time.Duration `yaml:"health_timeout"`
+	HealthCheckInterval   time.Duration `yaml:"health_interval"`
+	HealthCheckJitter     float64       `yaml:"health_jitter"`
+	HealthCheckWorkers    int           `yaml:"health_workers"`
+	HealthSampleInterval  time.Duration `yaml:"health_sample_interval"`
+	HealthSourceAddress   string        `yaml:"health_source_address"`
+	HealthSourceInterface string        `yaml:"health_source_interface"`
+	HealthExecDir         string        `yaml:"health_exec_dir"`
+	HealthExecConcurrency int           `yaml:"health_exec_concurrency"`
+	HealthExecOutputLimit int           `yaml:"health_exec_output_limit"`
+	HealthPassive         bool          `yaml:"health_passive"`
+	HealthPassiveWindow   time.Duration `yaml:"health_passive_window"`
+	HealthPassiveMinFlows int           `yaml:"health_passive_min_flows"`
+	HealthPassiveRatio    float64       `yaml:"health_passive_failure_ratio"`
+	MaintenanceInterval   time.Duration `yaml:"maintenance_interval"`
+	NFTablesTable         string        `yaml:"nft_table"`
+	NFTablesChain         string        `yaml:"nft_chain"`
 }
 
 // defaultConfig returns the default configuration
 func defaultConfig() Config {
+	hostname, _ := os.Hostname()
+
 	return Config{
-		LogLevel:            "info",
-		DBHost:              "localhost",
-		DBPort:              5432,
-		DBUser:              "postgres",
-		DBPassword:          "",
-		DBName:              "nftables",
-		DBSSLMode:           "disable",
-		APIListenAddr:       ":8080",
-		UpdateInterval:      30 * time.Second,
-		HealthCheckTimeout:  5 * time.Second,
-		HealthCheckInterval: 60 * time.Second,
-		NFTablesTable:       "nat",
-		NFTablesChain:       "prerouting",
+		LogLevel:              "info",
+		Storage:               database.DriverPostgres,
+		SQLitePath:            "b2b-ingress-manager.db",
+		RoutingFileInterval:   10 * time.Second,
+		NodeName:              hostname,
+		LeaderLockID:          cluster.DefaultLockID,
+		LeaderInterval:        5 * time.Second,
+		DBHost:                "localhost",
+		DBPort:                5432,
+		DBUser:                "postgres",
+		DBPassword:            "",
+		DBName:                "nftables",
+		DBSSLMode:             "disable",
+		APIListenAddr:         ":8080",
+		APITLSMinVersion:      "1.2",
+		APITLSClientAuth:      "require",
+		APIUnixMode:           "0660",
+		UpdateInterval:        30 * time.Second,
+		UpdateDebounce:        2 * time.Second,
+		ReadyStaleThreshold:   2 * time.Minute,
+		AuthJWTClaim:          "sub",
+		DriftInterval:         time.Minute,
+		HealthCheckTimeout:    5 * time.Second,
+		HealthCheckInterval:   60 * time.Second,
+		HealthCheckJitter:     0.1,
+		HealthCheckWorkers:    16,
+		HealthSampleInterval:  5 * time.Minute,
+		HealthExecConcurrency: 4,
+		HealthExecOutputLimit: 4096,
+		HealthPassiveWindow:   30 * time.Second,
+		HealthPassiveMinFlows: 10,
+		HealthPassiveRatio:    0.5,
+		MaintenanceInterval:   30 * time.Second,
+		NFTablesTable:         "nat",
+		NFTablesChain:         "prerouting",
 	}
 }
 
 // validateConfig checks if all required fields are present
 func validateConfig(config Config) error {
 	// Check required fields
-	if config.DBHost == "" {
-		return fmt.Errorf("missing required parameter: db_host")
+	switch config.Storage {
+	case database.DriverPostgres:
+		if config.DBHost == "" {
+			return fmt.Errorf("missing required parameter: db_host")
+		}
+		if config.DBPort == 0 {
+			return fmt.Errorf("missing required parameter: db_port")
+		}
+		if config.DBUser == "" {
+			return fmt.Errorf("missing required parameter: db_user")
+		}
+		if config.DBName == "" {
+			return fmt.Errorf("missing required parameter: db_name")
+		}
+	case database.DriverSQLite, storageFile:
+		if config.SQLitePath == "" {
+			return fmt.Errorf("missing required parameter: sqlite_path")
+		}
+	default:
+		return fmt.Errorf("invalid parameter: storage must be postgres, sqlite or file")
+	}
+	if config.Storage == storageFile {
+		if config.RoutingFile == "" {
+			return fmt.Errorf("missing required parameter: routing_file")
+		}
+		if config.RoutingFileInterval <= 0 {
+			return fmt.Errorf("invalid parameter: routing_file_interval must be positive")
+		}
+	}
+	if config.NodeName == "" {
+		return fmt.Errorf("missing required parameter: node_name")
+	}
+	if config.LeaderInterval <= 0 {
+		return fmt.Errorf("invalid parameter: leader_interval must be positive")
+	}
+	if config.HealthCheckInterval <= 0 {
+		return fmt.Errorf("invalid parameter: health_interval must be positive")
+	}
+	if config.HealthSourceAddress != "" && net.ParseIP(config.HealthSourceAddress) == nil {
+		return fmt.Errorf("invalid parameter: health_source_address must be an IP address")
+	}
+	if config.HealthExecDir != "" && !filepath.IsAbs(config.HealthExecDir) {
+		return fmt.Errorf("invalid parameter: health_exec_dir must be an absolute path")
+	}
+	if config.HealthExecConcurrency <= 0 {
+		return fmt.Errorf("invalid parameter: health_exec_concurrency must be positive")
+	}
+	if config.HealthExecOutputLimit <= 0 {
+		return fmt.Errorf("invalid parameter: health_exec_output_limit must be positive")
 	}
-	if config.DBPort == 0 {
-		return fmt.Errorf("missing required parameter: db_port")
+	if config.HealthPassive {
+		if config.HealthPassiveWindow <= 0 {
+			return fmt.Errorf("invalid parameter: health_passive_window must be positive")
+		}
+		if config.HealthPassiveMinFlows <= 0 {
+			return fmt.Errorf("invalid parameter: health_passive_min_flows must be positive")
+		}
+		if config.HealthPassiveRatio <= 0 || config.HealthPassiveRatio >= 1 {
+			return fmt.Errorf("invalid parameter: health_passive_failure_ratio must be between 0 and 1")
+		}
+	}
+	if config.MaintenanceInterval <= 0 {
+		return fmt.Errorf("invalid parameter: maintenance_interval must be positive")
+	}
+	if config.HealthCheckJitter < 0 || config.HealthCheckJitter >= 1 {
+		return fmt.Errorf("invalid parameter: health_jitter must be between 0 and 1")
+	}
+	if config.ReadyStaleThreshold <= 0 {
+		return fmt.Errorf("invalid parameter: ready_stale_threshold must be positive")
+	}
+	if config.DriftInterval < 0 {
+		return fmt.Errorf("invalid parameter: drift_interval must not be negative")
+	}
+	if (config.APITLSCert == "") != (config.APITLSKey == "") {
+		return fmt.Errorf("invalid parameter: api_tls_cert and api_tls_key must be set together")
+	}
+	if config.APITLSMinVersion != "1.2" && config.APITLSMinVersion != "1.3" {
+		return fmt.Errorf("invalid parameter: api_tls_min_version must be 1.2 or 1.3")
+	}
+	if config.APITLSClientCA != "" && config.APITLSCert == "" {
+		return fmt.Errorf("invalid parameter: api_tls_client_ca requires api_tls_cert")
+	}
+	if config.APITLSClientAuth != "require" && config.APITLSClientAuth != "optional" {
+		return fmt.Errorf("invalid parameter: api_tls_client_auth must be require or optional")
 	}
-	if config.DBUser == "" {
-		return fmt.Errorf("missing required parameter: db_user")
+	if _, err := strconv.ParseUint(config.APIUnixMode, 8, 32); err != nil {
+		return fmt.Errorf("invalid parameter: api_unix_mode must be an octal file mode")
 	}
-	if config.DBName == "" {
-		return fmt.Errorf("missing required parameter: db_name")
+	if config.AuthJWKSFile != "" && config.AuthJWTClaim == "" {
+		return fmt.Errorf("missing required parameter: auth_jwt_claim")
 	}
 	if config.APIListenAddr == "" {
 		return fmt.Errorf("missing required parameter: api_listen")
@@ -98,6 +277,43 @@ func main() {
 		logger.Fatalf("Failed to connect to database: %v", err)
 	}
 
+	// Create the first API token when authentication is set up
+	if *createToken != "" {
+		if err := createAPIToken(db, *createToken); err != nil {
+			logger.Fatalf("Failed to create API token: %v", err)
+		}
+		return
+	}
+
+	// Keep the routing configuration in sync with the routing file
+	var store configStore = db
+	var fileStore *filestore.Store
+	if config.Storage == storageFile {
+		if fileStore, err = setupFileStore(config, db, logger); err != nil {
+			logger.Fatalf("Failed to apply routing file: %v", err)
+		}
+		store = fileStore
+	}
+
+	// Export or import the declarative configuration
+	if *exportFile != "" {
+		if err := exportConfiguration(store, *exportFile); err != nil {
+			logger.Fatalf("Failed to export configuration: %v", err)
+		}
+		return
+	}
+	if *importFile != "" {
+		if err := importConfiguration(store, *importFile, *importPrune, *importDry); err != nil {
+			logger.Fatalf("Failed to import configuration: %v", err)
+		}
+		return
+	}
+
+	if fileStore != nil {
+		fileStore.Start()
+		defer fileStore.Stop()
+	}
+
 	// Initialize nftables manager
 	nft, err := setupNFTablesManager(config, logger)
 	if err != nil {
@@ -105,15 +321,57 @@ func main() {
 	}
 	defer nft.Cleanup()
 
+	// Export metrics read from the database and nftables
+	if err := setupMetrics(db, nft, logger); err != nil {
+		logger.Fatalf("Failed to register metrics: %v", err)
+	}
+
+	// Elect the node that runs health checks and maintenance windows
+	elector, err := setupElector(config, db, logger)
+	if err != nil {
+		logger.Fatalf("Failed to set up leader election: %v", err)
+	}
+	elector.Start()
+	defer elector.Stop()
+
+	// Register the node, its ID identifies its view of the availability
+	node, err := db.RegisterIngressNode(config.NodeName, config.NodeGroup)
+	if err != nil {
+		logger.Fatalf("Failed to register node: %v", err)
+	}
+	var nodeID uint
+	if config.NodeHealth {
+		nodeID = node.ID
+	}
+
 	// Initialize the health checker
-	healthChecker := setupHealthChecker(config, db, logger)
+	healthChecker := setupHealthChecker(config, store, elector, nodeID, logger)
 
 	// Start health checker
 	healthChecker.Start()
 	defer healthChecker.Stop()
 
+	// Start the maintenance window scheduler
+	maintenanceScheduler := setupMaintenanceScheduler(config, db, elector, logger)
+	maintenanceScheduler.Start()
+	defer maintenanceScheduler.Stop()
+
+	// Listen for configuration changes made through any node
+	listener, err := db.Listen()
+	if err != nil {
+		logger.Fatalf("Failed to listen for configuration changes: %v", err)
+	}
+	defer listener.Close()
+
+	// Initialize the config updater
+	configUpdater := setupUpdater(config, store, nft, healthChecker, listener.Changes(), nodeID, logger)
+
 	// Initialize API server
-	apiServer := setupAPIServer(config, db, logger)
+	authenticators, err := setupAuthenticators(config, db, logger)
+	if err != nil {
+		logger.Fatalf("Failed to set up API authentication: %v", err)
+	}
+	apiServer := setupAPIServer(config, store, nft, configUpdater, healthChecker, elector, authenticators, logger)
 
 	// Create a wait group to manage goroutines
 	var wg sync.WaitGroup
@@ -130,7 +388,7 @@ func main() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := apiServer.Start(api.Config{ListenAddr: config.APIListenAddr}); err != nil && err != http.ErrServerClosed {
+		if err := apiServer.Start(api.Config{ListenAddr: config.APIListenAddr, TLS: apiTLSConfig(config), UnixSocket: apiUnixSocketConfig(config)}); err != nil && err != http.ErrServerClosed {
 			logger.Fatalf("API server failed: %v", err)
 		}
 	}()
@@ -139,7 +397,7 @@ func main() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		runConfigUpdater(ctx, config, db, nft, logger)
+		configUpdater.Run(ctx)
 	}()
 
 	<-sigCh
@@ -172,16 +430,56 @@ func parseConfig() Config {
 
 	// Define flags to override config file options
 	logLevel := flag.String("log-level", "", "Log level (debug, info, warn, error)")
+	storage := flag.String("storage", "", "Storage of the configuration (postgres, sqlite, file)")
+	sqlitePath := flag.String("sqlite-path", "", "SQLite database file of the sqlite and file storage")
+	routingFile := flag.String("routing-file", "", "YAML or JSON file with the routing configuration of the file storage")
+	routingFileInterval := flag.Duration("routing-file-interval", 0, "Interval between checks of the routing file for changes")
+	nodeName := flag.String("node-name", "", "Name of this node, defaults to the hostname")
+	nodeGroup := flag.String("node-group", "", "Group of this node rules can be scoped to, e.g. its site")
+	nodeHealth := flag.Bool("node-health", false, "Check backends from this node and route by its own view of their availability")
+	leaderLockID := flag.Int64("leader-lock-id", 0, "PostgreSQL advisory lock key shared by the nodes of a cluster")
+	leaderInterval := flag.Duration("leader-interval", 0, "Interval between leader election attempts")
 	dbHost := flag.String("db-host", "", "PostgreSQL host")
 	dbPort := flag.Int("db-port", 0, "PostgreSQL port")
 	dbUser := flag.String("db-user", "", "PostgreSQL user")
 	dbPassword := flag.String("db-password", "", "PostgreSQL password")
 	dbName := flag.String("db-name", "", "PostgreSQL database name")
 	dbSSLMode := flag.String("db-sslmode", "", "PostgreSQL SSL mode")
-	apiListenAddr := flag.String("api-listen", "", "API server listen address")
+	apiListenAddr := flag.String("api-listen", "", "Comma-separated API server listen addresses, unix socket paths prefixed with unix:")
+	apiTLSCert := flag.String("api-tls-cert", "", "TLS certificate file of the API server")
+	apiTLSKey := flag.String("api-tls-key", "", "TLS key file of the API server")
+	apiTLSMinVersion := flag.String("api-tls-min-version", "", "Minimum TLS version of the API server (1.2 or 1.3)")
+	apiTLSCiphers := flag.String("api-tls-ciphers", "", "Comma-separated TLS 1.2 cipher suites of the API server")
+	apiTLSClientCA := flag.String("api-tls-client-ca", "", "CA bundle client certificates are verified against")
+	apiTLSClientAuth := flag.String("api-tls-client-auth", "", "Whether client certificates are required or optional (require, optional)")
+	apiUnixMode := flag.String("api-unix-mode", "", "File mode of API unix sockets")
+	apiUnixGroup := flag.String("api-unix-group", "", "Group owning API unix sockets")
 	updateInterval := flag.Duration("update-interval", 0, "NFTables update interval")
+	updateDebounce := flag.Duration("update-debounce", 0, "Delay for collecting health changes before a targeted NFTables update")
+	readyStaleThreshold := flag.Duration("ready-stale-threshold", 0, "Time without a successful NFTables update after which the manager is not ready")
+	authTokens := flag.Bool("auth-tokens", false, "Authenticate API requests with API tokens")
+	authJWKSFile := flag.String("auth-jwks-file", "", "JWKS file with the keys of JWTs accepted for API requests")
+	authJWTIssuer := flag.String("auth-jwt-issuer", "", "Required issuer of JWTs")
+	authJWTAudience := flag.String("auth-jwt-audience", "", "Required audience of JWTs")
+	authJWTClaim := flag.String("auth-jwt-claim", "", "JWT claim recorded as the principal of changes")
+	authAdmins := flag.String("auth-admins", "", "Comma-separated principals with the admin role")
+	driftInterval := flag.Duration("drift-interval", -1, "Interval between comparisons of the kernel ruleset with the applied one (0 disables)")
+	driftReapply := flag.Bool("drift-reapply", false, "Re-apply the NFTables ruleset as soon as drift is detected")
 	healthCheckTimeout := flag.Duration("health-timeout", 0, "Health check timeout")
 	healthCheckInterval := flag.Duration("health-interval", 0, "Health check interval")
+	healthCheckJitter := flag.Float64("health-jitter", -1, "Fraction of the health check interval used as random jitter")
+	healthCheckWorkers := flag.Int("health-workers", 0, "Maximum number of concurrent health checks")
+	healthSourceAddress := flag.String("health-source-address", "", "Local address health checks connect from")
+	healthSourceIface := flag.String("health-source-interface", "", "Network interface health checks connect from")
+	healthExecDir := flag.String("health-exec-dir", "", "Directory containing commands allowed for exec health checks")
+	healthExecConcurrency := flag.Int("health-exec-concurrency", 0, "Maximum number of concurrent exec health checks")
+	healthExecOutputLimit := flag.Int("health-exec-output-limit", 0, "Number of bytes of exec health check output kept")
+	healthPassive := flag.Bool("health-passive", false, "Detect failed backends from conntrack events of DNATed connections")
+	healthPassiveWindow := flag.Duration("health-passive-window", 0, "Window over which failed connections are counted for passive detection")
+	healthPassiveMinFlows := flag.Int("health-passive-min-flows", 0, "Minimum number of connections in a window for passive detection")
+	healthPassiveRatio := flag.Float64("health-passive-failure-ratio", 0, "Fraction of failed connections above which an address is marked unavailable")
+	healthSampleInterval := flag.Duration("health-sample-interval", 0, "Period over which health check latencies are aggregated")
+	maintenanceInterval := flag.Duration("maintenance-interval", 0, "Interval between checks for due maintenance windows")
 	nftTable := flag.String("nft-table", "", "NFTables table name")
 	nftChain := flag.String("nft-chain", "", "NFTables chain name")
 
@@ -208,6 +506,33 @@ func parseConfig() Config {
 	if *logLevel != "" {
 		config.LogLevel = *logLevel
 	}
+	if *storage != "" {
+		config.Storage = *storage
+	}
+	if *sqlitePath != "" {
+		config.SQLitePath = *sqlitePath
+	}
+	if *routingFile != "" {
+		config.RoutingFile = *routingFile
+	}
+	if *routingFileInterval != 0 {
+		config.RoutingFileInterval = *routingFileInterval
+	}
+	if *nodeName != "" {
+		config.NodeName = *nodeName
+	}
+	if *nodeGroup != "" {
+		config.NodeGroup = *nodeGroup
+	}
+	if *nodeHealth {
+		config.NodeHealth = true
+	}
+	if *leaderLockID != 0 {
+		config.LeaderLockID = *leaderLockID
+	}
+	if *leaderInterval != 0 {
+		config.LeaderInterval = *leaderInterval
+	}
 	if *dbHost != "" {
 		config.DBHost = *dbHost
 	}
@@ -229,15 +554,108 @@ func parseConfig() Config {
 	if *apiListenAddr != "" {
 		config.APIListenAddr = *apiListenAddr
 	}
+	if *apiTLSCert != "" {
+		config.APITLSCert = *apiTLSCert
+	}
+	if *apiTLSKey != "" {
+		config.APITLSKey = *apiTLSKey
+	}
+	if *apiTLSMinVersion != "" {
+		config.APITLSMinVersion = *apiTLSMinVersion
+	}
+	if *apiTLSCiphers != "" {
+		config.APITLSCiphers = strings.Split(*apiTLSCiphers, ",")
+	}
+	if *apiTLSClientCA != "" {
+		config.APITLSClientCA = *apiTLSClientCA
+	}
+	if *apiTLSClientAuth != "" {
+		config.APITLSClientAuth = *apiTLSClientAuth
+	}
+	if *apiUnixMode != "" {
+		config.APIUnixMode = *apiUnixMode
+	}
+	if *apiUnixGroup != "" {
+		config.APIUnixGroup = *apiUnixGroup
+	}
 	if *updateInterval != 0 {
 		config.UpdateInterval = *updateInterval
 	}
+	if *updateDebounce != 0 {
+		config.UpdateDebounce = *updateDebounce
+	}
+	if *readyStaleThreshold != 0 {
+		config.ReadyStaleThreshold = *readyStaleThreshold
+	}
+	if *authTokens {
+		config.AuthTokens = true
+	}
+	if *authJWKSFile != "" {
+		config.AuthJWKSFile = *authJWKSFile
+	}
+	if *authJWTIssuer != "" {
+		config.AuthJWTIssuer = *authJWTIssuer
+	}
+	if *authJWTAudience != "" {
+		config.AuthJWTAudience = *authJWTAudience
+	}
+	if *authJWTClaim != "" {
+		config.AuthJWTClaim = *authJWTClaim
+	}
+	if *authAdmins != "" {
+		config.AuthAdmins = strings.Split(*authAdmins, ",")
+	}
+	if *driftInterval >= 0 {
+		config.DriftInterval = *driftInterval
+	}
+	if *driftReapply {
+		config.DriftReapply = true
+	}
 	if *healthCheckTimeout != 0 {
 		config.HealthCheckTimeout = *healthCheckTimeout
 	}
 	if *healthCheckInterval != 0 {
 		config.HealthCheckInterval = *healthCheckInterval
 	}
+	if *healthCheckJitter >= 0 {
+		config.HealthCheckJitter = *healthCheckJitter
+	}
+	if *healthCheckWorkers != 0 {
+		config.HealthCheckWorkers = *healthCheckWorkers
+	}
+	if *healthSourceAddress != "" {
+		config.HealthSourceAddress = *healthSourceAddress
+	}
+	if *healthSourceIface != "" {
+		config.HealthSourceInterface = *healthSourceIface
+	}
+	if *healthExecDir != "" {
+		config.HealthExecDir = *healthExecDir
+	}
+	if *healthExecConcurrency != 0 {
+		config.HealthExecConcurrency = *healthExecConcurrency
+	}
+	if *healthExecOutputLimit != 0 {
+		config.HealthExecOutputLimit = *healthExecOutputLimit
+	}
+	if *healthPassive {
+		config.HealthPassive = true
+	}
+	if *healthPassiveWindow != 0 {
+		config.HealthPassiveWindow = *healthPassiveWindow
+	}
+	if *healthPassiveMinFlows != 0 {
+		config.HealthPassiveMinFlows = *healthPassiveMinFlows
+	}
+	if *healthPassiveRatio != 0 {
+		config.HealthPassiveRatio = *healthPassiveRatio
+	}
+	if *healthSampleInterval != 0 {
+		config.HealthSampleInterval = *healthSampleInterval
+	}
+	if *maintenanceInterval != 0 {
+		config.MaintenanceInterval = *maintenanceInterval
+	}
 	if *nftTable != "" {
 		config.NFTablesTable = *nftTable
 	}
@@ -280,6 +698,7 @@ func setupLogger(level string) *logrus.Logger {
 // setupDatabase initializes the database connection
 func setupDatabase(config Config, logger *logrus.Logger) (*database.Service, error) {
 	dbConfig := database.Config{
+		Driver:   database.DriverPostgres,
 		Host:     config.DBHost,
 		Port:     config.DBPort,
 		User:     config.DBUser,
@@ -287,10 +706,44 @@ func setupDatabase(config Config, logger *logrus.Logger) (*database.Service, err
 		DBName:   config.DBName,
 		SSLMode:  config.DBSSLMode,
 	}
+	if config.Storage != database.DriverPostgres {
+		dbConfig.Driver = database.DriverSQLite
+		dbConfig.Path = config.SQLitePath
+	}
 
 	return database.NewService(dbConfig, logger)
 }
 
+// setupFileStore applies the routing file to the database
+func setupFileStore(config Config, db *database.Service, logger *logrus.Logger) (*filestore.Store, error) {
+	fileConfig := filestore.Config{
+		Path:     config.RoutingFile,
+		Interval: config.RoutingFileInterval,
+	}
+
+	return filestore.New(db, fileConfig, logger)
+}
+
+// setupElector initializes the leader election. Nodes with SQLite or file
+// storage do not share their database and are always the leader.
+func setupElector(config Config, db *database.Service, logger *logrus.Logger) (*cluster.Elector, error) {
+	if config.Storage != database.DriverPostgres {
+		return cluster.Standalone(config.NodeName), nil
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	clusterConfig := cluster.Config{
+		NodeName: config.NodeName,
+		LockID:   config.LeaderLockID,
+		Interval: config.LeaderInterval,
+	}
+
+	return cluster.NewElector(sqlDB, clusterConfig, logger), nil
+}
+
 // setupNFTablesManager initializes the nftables manager
 func setupNFTablesManager(config Config, logger *logrus.Logger) (*nftables.Manager, error) {
 	nftConfig := nftables.Config{
@@ -311,69 +764,221 @@ func setupNFTablesManager(config Config, logger *logrus.Logger) (*nftables.Manag
 }
 
 // setupHealthChecker initializes the health checker
-func setupHealthChecker(config Config, db *database.Service, logger *logrus.Logger) *health.Checker {
+func setupHealthChecker(config Config, db health.Store, elector *cluster.Elector, nodeID uint, logger *logrus.Logger) *health.Checker {
 	healthConfig := health.Config{
-		CheckTimeout: config.HealthCheckTimeout,
-		Interval:     config.HealthCheckInterval,
+		CheckTimeout:        config.HealthCheckTimeout,
+		Interval:            config.HealthCheckInterval,
+		Jitter:              config.HealthCheckJitter,
+		Workers:             config.HealthCheckWorkers,
+		SampleInterval:      config.HealthSampleInterval,
+		SourceAddress:       config.HealthSourceAddress,
+		SourceInterface:     config.HealthSourceInterface,
+		ExecDir:             config.HealthExecDir,
+		ExecConcurrency:     config.HealthExecConcurrency,
+		ExecOutputLimit:     config.HealthExecOutputLimit,
+		Passive:             config.HealthPassive,
+		PassiveWindow:       config.HealthPassiveWindow,
+		PassiveMinFlows:     config.HealthPassiveMinFlows,
+		PassiveFailureRatio: config.HealthPassiveRatio,
+		IsLeader:            elector.IsLeader,
+		NodeID:              nodeID,
 	}
 
 	return health.NewChecker(db, healthConfig, logger)
 }
 
 // setupAPIServer initializes the API server
-func setupAPIServer(config Config, db *database.Service, logger *logrus.Logger) *api.Server {
+func setupAPIServer(config Config, db api.Store, nft *nftables.Manager, configUpdater *updater.Updater, healthChecker *health.Checker, elector *cluster.Elector, authenticators []auth.Authenticator, logger *logrus.Logger) *api.Server {
 	apiConfig := api.Config{
-		ListenAddr: config.APIListenAddr,
+		ListenAddr:     config.APIListenAddr,
+		StaleThreshold: config.ReadyStaleThreshold,
+		Authenticators: authenticators,
+		Admins:         config.AuthAdmins,
+		Elector:        elector,
 	}
 
-	return api.NewServer(db, apiConfig, logger)
+	return api.NewServer(db, nft, configUpdater, healthChecker, apiConfig, logger)
 }
 
-// runConfigUpdater periodically updates nftables configuration
-func runConfigUpdater(ctx context.Context, config Config, db *database.Service, nft *nftables.Manager, logger *logrus.Logger) {
-	ticker := time.NewTicker(config.UpdateInterval)
-	defer ticker.Stop()
-
-	// Run the updater immediately on startup
-	if err := updateNFTables(db, nft, logger); err != nil {
-		logger.Errorf("Failed to update nftables: %v", err)
-	}
-
-	for {
-		select {
-		case <-ticker.C:
-			if err := updateNFTables(db, nft, logger); err != nil {
-				logger.Errorf("Failed to update nftables: %v", err)
-			}
-		case <-ctx.Done():
-			logger.Info("Stopping config updater...")
-			return
-		}
+// apiTLSConfig returns the TLS configuration of the API server, or nil if
+// TLS is disabled
+func apiTLSConfig(config Config) *api.TLSConfig {
+	if config.APITLSCert == "" {
+		return nil
+	}
+
+	return &api.TLSConfig{
+		CertFile:          config.APITLSCert,
+		KeyFile:           config.APITLSKey,
+		MinVersion:        config.APITLSMinVersion,
+		CipherSuites:      config.APITLSCiphers,
+		ClientCAFile:      config.APITLSClientCA,
+		RequireClientCert: config.APITLSClientAuth == "require",
 	}
 }
 
-// updateNFTables applies the current configuration to nftables
-func updateNFTables(db *database.Service, nft *nftables.Manager, logger *logrus.Logger) error {
-	// Get active rules from the database
-	rules, err := db.GetActiveRules()
-	if err != nil {
-		return fmt.Errorf("failed to get active rules: %v", err)
+// apiUnixSocketConfig returns the permissions of API unix sockets
+func apiUnixSocketConfig(config Config) api.UnixSocketConfig {
+	// The mode has been validated
+	mode, _ := strconv.ParseUint(config.APIUnixMode, 8, 32)
+
+	return api.UnixSocketConfig{
+		Mode:  os.FileMode(mode),
+		Group: config.APIUnixGroup,
 	}
+}
 
-	logger.Debugf("Got %d active rules from database", len(rules))
+// setupAuthenticators creates the configured API authenticators
+func setupAuthenticators(config Config, db *database.Service, logger *logrus.Logger) ([]auth.Authenticator, error) {
+	var authenticators []auth.Authenticator
 
-	// Get available backend addresses for each backend set
-	backendAddresses := make(map[uint][]models.Address)
-	for _, rule := range rules {
-		addresses, err := db.GetAvailableBackendAddresses(rule.BackendSetID)
+	if config.APITLSClientCA != "" {
+		authenticators = append(authenticators, auth.NewClientCertAuthenticator())
+	}
+	if config.AuthTokens {
+		authenticators = append(authenticators, auth.NewTokenAuthenticator(db))
+	}
+	if config.AuthJWKSFile != "" {
+		jwtAuthenticator, err := auth.NewJWTAuthenticator(auth.JWTConfig{
+			JWKSFile:       config.AuthJWKSFile,
+			Issuer:         config.AuthJWTIssuer,
+			Audience:       config.AuthJWTAudience,
+			PrincipalClaim: config.AuthJWTClaim,
+		})
 		if err != nil {
-			logger.Errorf("Failed to get addresses for backend set %d: %v", rule.BackendSetID, err)
-			continue
+			return nil, err
 		}
+		authenticators = append(authenticators, jwtAuthenticator)
+	}
+
+	// Local clients on a unix socket are authenticated by their user ID.
+	// This is only done if authentication is enabled, an open API records
+	// the user ID as the principal of changes anyway.
+	if len(authenticators) > 0 && hasUnixListener(config) {
+		authenticators = append([]auth.Authenticator{auth.NewPeerAuthenticator()}, authenticators...)
+	}
+
+	if len(authenticators) == 0 {
+		logger.Warn("API authentication is disabled, enable auth_tokens, auth_jwks_file or api_tls_client_ca")
+	}
+
+	return authenticators, nil
+}
+
+// hasUnixListener reports whether the API listens on a unix socket
+func hasUnixListener(config Config) bool {
+	for _, addr := range api.ListenAddrs(config.APIListenAddr) {
+		if api.IsUnixAddr(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// createAPIToken creates an API token with the admin role and prints it
+func createAPIToken(db *database.Service, name string) error {
+	token, hash, err := auth.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	if err := db.CreateAPIToken(&models.APIToken{Name: name, TokenHash: hash}, "cli"); err != nil {
+		return err
+	}
+	assignment := models.RoleAssignment{Principal: auth.TokenPrincipal(name), Role: auth.RoleAdmin}
+	if err := db.CreateRoleAssignment(&assignment, "cli"); err != nil {
+		return err
+	}
+
+	fmt.Println(token)
+	return nil
+}
+
+// exportConfiguration writes the routing configuration to a file, as JSON
+// if its name ends with .json and YAML otherwise
+func exportConfiguration(db api.Store, path string) error {
+	doc, err := db.ExportConfiguration()
+	if err != nil {
+		return err
+	}
+
+	format := "yaml"
+	if strings.HasSuffix(path, ".json") {
+		format = "json"
+	}
+	data, err := declarative.Marshal(doc, format)
+	if err != nil {
+		return err
+	}
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// importConfiguration applies a YAML or JSON file as the routing
+// configuration and prints the changes
+func importConfiguration(db api.Store, path string, prune, dryRun bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	doc, err := declarative.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.ImportConfiguration(doc, prune, dryRun, "cli")
+	if err != nil {
+		return err
+	}
+
+	for _, change := range result.Changes {
+		fmt.Printf("%s %s %s\n", change.Action, change.EntityType, change.Name)
+	}
+	for _, ruleConflict := range result.Conflicts {
+		fmt.Printf("warning: %s\n", ruleConflict.Description)
+	}
+	switch {
+	case dryRun:
+		fmt.Printf("Dry run, %d changes not applied\n", len(result.Changes))
+	case result.Revision != 0:
+		fmt.Printf("Applied %d changes as revision %d\n", len(result.Changes), result.Revision)
+	default:
+		fmt.Println("The configuration is up to date")
+	}
+	return nil
+}
+
+// setupMetrics registers the collector of database and nftables metrics
+func setupMetrics(db *database.Service, nft *nftables.Manager, logger *logrus.Logger) error {
+	return prometheus.Register(metrics.NewCollector(db, nft, logger))
+}
+
+// setupMaintenanceScheduler initializes the maintenance window scheduler
+func setupMaintenanceScheduler(config Config, db *database.Service, elector *cluster.Elector, logger *logrus.Logger) *maintenance.Scheduler {
+	maintenanceConfig := maintenance.Config{
+		Interval: config.MaintenanceInterval,
+		IsLeader: elector.IsLeader,
+	}
+
+	return maintenance.NewScheduler(db, maintenanceConfig, logger)
+}
 
-		backendAddresses[rule.BackendSetID] = addresses
+// setupUpdater initializes the nftables config updater
+func setupUpdater(config Config, db updater.Store, nft *nftables.Manager, healthChecker *health.Checker, changes <-chan string, nodeID uint, logger *logrus.Logger) *updater.Updater {
+	updaterConfig := updater.Config{
+		Interval:      config.UpdateInterval,
+		Debounce:      config.UpdateDebounce,
+		DriftInterval: config.DriftInterval,
+		DriftReapply:  config.DriftReapply,
+		NodeName:      config.NodeName,
+		NodeGroup:     config.NodeGroup,
+		NodeID:        nodeID,
+		Changes:       changes,
 	}
 
-	// Apply the rules to nftables
-	return nft.ApplyRules(rules, backendAddresses)
+	return updater.NewUpdater(db, nft, healthChecker.Events(), updaterConfig, logger)
 }
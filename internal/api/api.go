@@ -2,32 +2,68 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/sven-borkert/b2b-ingress-manager/internal/auth"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/cluster"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/conflict"
 	"github.com/sven-borkert/b2b-ingress-manager/internal/database"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/health"
 	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/nftables"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/updater"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
 // Server represents the API server
 type Server struct {
-	router *gin.Engine
-	db     *database.Service
-	logger *logrus.Logger
-	srv    *http.Server
+	router  *gin.Engine
+	db      Store
+	nft     *nftables.Manager
+	updater *updater.Updater
+	checker *health.Checker
+	elector *cluster.Elector
+	logger  *logrus.Logger
+	srv     *http.Server
+
+	started        time.Time
+	staleThreshold time.Duration
+	authenticators []auth.Authenticator
+	admins         []string
 }
 
 // Config for the API server
 type Config struct {
+	// ListenAddr is a comma-separated list of TCP addresses and unix socket
+	// paths prefixed with "unix:"
 	ListenAddr string
+	// StaleThreshold is how long the ruleset may go without a successful
+	// update before the manager is reported as not ready
+	StaleThreshold time.Duration
+	// Authenticators are tried in order for requests to /api. The API is
+	// open if there are none.
+	Authenticators []auth.Authenticator
+	// Admins are principals with the admin role without a role assignment
+	Admins []string
+	// TLS enables HTTPS on TCP listeners if set
+	TLS *TLSConfig
+	// UnixSocket holds the permissions of unix socket listeners
+	UnixSocket UnixSocketConfig
+	// Elector reports the identity and role of the node
+	Elector *cluster.Elector
 }
 
 // NewServer creates a new API server
-func NewServer(db *database.Service, config Config, logger *logrus.Logger) *Server {
+func NewServer(db Store, nft *nftables.Manager, configUpdater *updater.Updater, healthChecker *health.Checker, config Config, logger *logrus.Logger) *Server {
 	router := gin.New()
 	router.Use(gin.Recovery())
 
@@ -41,10 +77,23 @@ func NewServer(db *database.Service, config Config, logger *logrus.Logger) *Serv
 		c.Next()
 	})
 
+	elector := config.Elector
+	if elector == nil {
+		elector = cluster.Standalone("")
+	}
+
 	server := &Server{
-		router: router,
-		db:     db,
-		logger: logger,
+		router:         router,
+		db:             db,
+		nft:            nft,
+		updater:        configUpdater,
+		checker:        healthChecker,
+		elector:        elector,
+		logger:         logger,
+		started:        time.Now(),
+		staleThreshold: config.StaleThreshold,
+		authenticators: config.Authenticators,
+		admins:         config.Admins,
 	}
 
 	// Register routes
@@ -55,12 +104,62 @@ func NewServer(db *database.Service, config Config, logger *logrus.Logger) *Serv
 
 // Start begins serving API requests
 func (s *Server) Start(config Config) error {
-	s.logger.Infof("Starting API server on %s", config.ListenAddr)
 	s.srv = &http.Server{
-		Addr:    config.ListenAddr,
-		Handler: s.router,
+		Handler:     s.router,
+		ConnContext: auth.ConnContext,
+	}
+
+	if config.TLS != nil {
+		tlsConfig, err := newTLSConfig(*config.TLS, s.logger)
+		if err != nil {
+			return err
+		}
+		s.srv.TLSConfig = tlsConfig
+	}
+
+	addrs := ListenAddrs(config.ListenAddr)
+	if len(addrs) == 0 {
+		return errors.New("no API listen address configured")
+	}
+
+	listeners := make([]net.Listener, 0, len(addrs))
+	closeAll := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
 	}
-	return s.srv.ListenAndServe()
+	for _, addr := range addrs {
+		var listener net.Listener
+		var err error
+		if IsUnixAddr(addr) {
+			listener, err = listenUnix(strings.TrimPrefix(addr, UnixPrefix), config.UnixSocket)
+		} else {
+			listener, err = net.Listen("tcp", addr)
+		}
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("failed to listen on %s: %v", addr, err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	errs := make(chan error, len(listeners))
+	for i, listener := range listeners {
+		addr := addrs[i]
+		go func(listener net.Listener) {
+			// Unix sockets are protected by file permissions and never use TLS
+			if config.TLS != nil && !IsUnixAddr(addr) {
+				s.logger.Infof("Starting API server on %s with TLS", addr)
+				errs <- s.srv.ServeTLS(listener, "", "")
+				return
+			}
+			s.logger.Infof("Starting API server on %s", addr)
+			errs <- s.srv.Serve(listener)
+		}(listener)
+	}
+
+	// All listeners stop on shutdown, the first error is reported
+	return <-errs
 }
 
 // Shutdown gracefully shuts down the API server
@@ -74,44 +173,105 @@ func (s *Server) Shutdown(ctx context.Context) error {
 
 // registerRoutes sets up all API routes
 func (s *Server) registerRoutes() {
-	api := s.router.Group("/api")
+	// Prometheus metrics
+	s.router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// Liveness and readiness probes
+	s.router.GET("/healthz", s.getLiveness)
+	s.router.GET("/readyz", s.getReadiness)
+
+	api := s.router.Group("/api", s.authenticate)
 	{
 		// Backend routes
-		api.GET("/backends", s.getBackends)
-		api.GET("/backends/:id", s.getBackend)
-		api.POST("/backends", s.createBackend)
-		api.PUT("/backends/:id", s.updateBackend)
-		api.DELETE("/backends/:id", s.deleteBackend)
+		api.GET("/backends", s.canRead(auth.EntityBackend), s.getBackends)
+		api.GET("/backends/:id", s.canRead(auth.EntityBackend), s.getBackend)
+		api.POST("/backends", s.canWrite(auth.EntityBackend), s.createBackend)
+		api.PUT("/backends/:id", s.canWrite(auth.EntityBackend), s.updateBackend)
+		api.DELETE("/backends/:id", s.canWrite(auth.EntityBackend), s.deleteBackend)
+		api.PUT("/backends/:id/state", s.canWrite(auth.EntityBackend), s.setBackendState)
 
 		// Backend address routes
-		api.POST("/backends/:id/addresses", s.addBackendAddress)
-		api.PUT("/addresses/:id", s.updateAddress)
-		api.DELETE("/addresses/:id", s.deleteAddress)
+		api.POST("/backends/:id/addresses", s.canWrite(auth.EntityAddress), s.addBackendAddress)
+		api.PUT("/addresses/:id", s.canWrite(auth.EntityAddress), s.updateAddress)
+		api.DELETE("/addresses/:id", s.canWrite(auth.EntityAddress), s.deleteAddress)
+		api.PUT("/addresses/:id/state", s.canWrite(auth.EntityAddress), s.setAddressState)
+
+		// Maintenance window routes
+		api.GET("/maintenance-windows", s.canRead(auth.EntityMaintenanceWindow), s.getMaintenanceWindows)
+		api.GET("/maintenance-windows/:id", s.canRead(auth.EntityMaintenanceWindow), s.getMaintenanceWindow)
+		api.POST("/maintenance-windows", s.canWrite(auth.EntityMaintenanceWindow), s.createMaintenanceWindow)
+		api.PUT("/maintenance-windows/:id", s.canWrite(auth.EntityMaintenanceWindow), s.updateMaintenanceWindow)
+		api.DELETE("/maintenance-windows/:id", s.canWrite(auth.EntityMaintenanceWindow), s.deleteMaintenanceWindow)
 
 		// Backend set routes
-		api.GET("/backend-sets", s.getBackendSets)
-		api.GET("/backend-sets/:id", s.getBackendSet)
-		api.POST("/backend-sets", s.createBackendSet)
-		api.PUT("/backend-sets/:id", s.updateBackendSet)
-		api.DELETE("/backend-sets/:id", s.deleteBackendSet)
+		api.GET("/backend-sets", s.canRead(auth.EntityBackendSet), s.getBackendSets)
+		api.GET("/backend-sets/:id", s.canRead(auth.EntityBackendSet), s.getBackendSet)
+		api.POST("/backend-sets", s.canWrite(auth.EntityBackendSet), s.createBackendSet)
+		api.PUT("/backend-sets/:id", s.canWrite(auth.EntityBackendSet), s.updateBackendSet)
+		api.DELETE("/backend-sets/:id", s.canWrite(auth.EntityBackendSet), s.deleteBackendSet)
 
 		// Source definition routes
-		api.GET("/source-definitions", s.getSourceDefinitions)
-		api.GET("/source-definitions/:id", s.getSourceDefinition)
-		api.POST("/source-definitions", s.createSourceDefinition)
-		api.PUT("/source-definitions/:id", s.updateSourceDefinition)
-		api.DELETE("/source-definitions/:id", s.deleteSourceDefinition)
+		api.GET("/source-definitions", s.canRead(auth.EntitySourceDefinition), s.getSourceDefinitions)
+		api.GET("/source-definitions/:id", s.canRead(auth.EntitySourceDefinition), s.getSourceDefinition)
+		api.POST("/source-definitions", s.canWrite(auth.EntitySourceDefinition), s.createSourceDefinition)
+		api.PUT("/source-definitions/:id", s.canWrite(auth.EntitySourceDefinition), s.updateSourceDefinition)
+		api.DELETE("/source-definitions/:id", s.canWrite(auth.EntitySourceDefinition), s.deleteSourceDefinition)
 
 		// Rule routes
-		api.GET("/rules", s.getRules)
-		api.GET("/rules/:id", s.getRule)
-		api.POST("/rules", s.createRule)
-		api.PUT("/rules/:id", s.updateRule)
-		api.DELETE("/rules/:id", s.deleteRule)
+		api.GET("/rules", s.canRead(auth.EntityRule), s.getRules)
+		api.GET("/rules/conflicts", s.canRead(auth.EntityRule), s.getRuleConflicts)
+		api.GET("/rules/:id", s.canRead(auth.EntityRule), s.getRule)
+		api.POST("/rules", s.canWrite(auth.EntityRule), s.createRule)
+		api.PUT("/rules/:id", s.canWrite(auth.EntityRule), s.updateRule)
+		api.DELETE("/rules/:id", s.canWrite(auth.EntityRule), s.deleteRule)
+
+		// Revision routes
+		api.GET("/revisions", s.canRead(auth.EntityRevision), s.getRevisions)
+		api.GET("/revisions/diff", s.canRead(auth.EntityRevision), s.diffRevisions)
+		api.GET("/revisions/:id", s.canRead(auth.EntityRevision), s.getRevision)
+		api.GET("/revisions/:id/configuration", s.canRead(auth.EntityRevision), s.getRevisionConfiguration)
+		api.POST("/revisions/:id/restore", s.canWrite(auth.EntityRevision), s.restoreRevision)
+
+		// Declarative configuration routes
+		api.GET("/configuration/export", s.canRead(auth.EntityConfiguration), s.exportConfiguration)
+		api.POST("/configuration/import", s.canWrite(auth.EntityConfiguration), s.importConfiguration)
 
 		// Logs routes
-		api.GET("/logs/config", s.getConfigLogs)
-		api.GET("/logs/availability", s.getAvailabilityLogs)
+		api.GET("/logs/config", s.canRead(auth.EntityStatus), s.getConfigLogs)
+		api.GET("/logs/config/:entity_type/:id", s.canRead(auth.EntityStatus), s.getEntityHistory)
+		api.GET("/logs/availability", s.canRead(auth.EntityStatus), s.getAvailabilityLogs)
+
+		// Availability report routes
+		api.GET("/reports/availability/addresses/:id", s.canRead(auth.EntityStatus), s.getAddressReport)
+		api.GET("/reports/availability/backends/:id", s.canRead(auth.EntityStatus), s.getBackendReport)
+		api.GET("/reports/availability/backend-sets/:id", s.canRead(auth.EntityStatus), s.getBackendSetReport)
+
+		// Identity and role of the node
+		api.GET("/node", s.canRead(auth.EntityStatus), s.getNode)
+
+		// Ingress node registry
+		api.GET("/nodes", s.canRead(auth.EntityStatus), s.getIngressNodes)
+		api.GET("/nodes/:id/availability", s.canRead(auth.EntityStatus), s.getNodeAvailability)
+
+		// Principal of the request
+		api.GET("/whoami", s.getPrincipal)
+
+		// Role assignment routes
+		api.GET("/roles", s.canRead(auth.EntityRoleAssignment), s.getRoleAssignments)
+		api.POST("/roles", s.canWrite(auth.EntityRoleAssignment), s.createRoleAssignment)
+		api.PUT("/roles/:id", s.canWrite(auth.EntityRoleAssignment), s.updateRoleAssignment)
+		api.DELETE("/roles/:id", s.canWrite(auth.EntityRoleAssignment), s.deleteRoleAssignment)
+
+		// API token routes
+		api.GET("/tokens", s.canRead(auth.EntityAPIToken), s.getAPITokens)
+		api.POST("/tokens", s.canWrite(auth.EntityAPIToken), s.createAPIToken)
+		api.DELETE("/tokens/:id", s.canWrite(auth.EntityAPIToken), s.deleteAPIToken)
+
+		// Applied nftables state
+		api.GET("/nftables/state", s.canRead(auth.EntityStatus), s.getAppliedState)
+		api.GET("/nftables/drift", s.canRead(auth.EntityStatus), s.getDrift)
+		api.POST("/nftables/plan", s.canRead(auth.EntityStatus), s.createPlan)
+		api.GET("/nftables/lookup", s.canRead(auth.EntityStatus), s.lookupFlow)
 	}
 }
 
@@ -148,8 +308,34 @@ func (s *Server) createBackend(c *gin.Context) {
 		return
 	}
 
-	if err := s.db.CreateBackend(&backend, c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if backend.AdminState != "" && !models.ValidAdminState(backend.AdminState) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid admin state"})
+		return
+	}
+
+	// Validate the health check source address
+	if backend.HealthCheckSourceAddress != "" && net.ParseIP(backend.HealthCheckSourceAddress) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid health check source address"})
+		return
+	}
+
+	// Validate the health check type
+	switch backend.HealthCheckType {
+	case "":
+		backend.HealthCheckType = models.HealthCheckTCP
+	case models.HealthCheckTCP:
+	case models.HealthCheckExec:
+		if backend.HealthCheckCommand == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Health check command is required for exec checks"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Health check type must be tcp or exec"})
+		return
+	}
+
+	if err := s.db.CreateBackend(&backend, changedBy(c)); err != nil {
+		storeError(c, err)
 		return
 	}
 
@@ -170,8 +356,43 @@ func (s *Server) updateBackend(c *gin.Context) {
 	}
 
 	backend.ID = uint(id)
-	if err := s.db.UpdateBackend(&backend, c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+
+	// Validate the health check source address
+	if backend.HealthCheckSourceAddress != "" && net.ParseIP(backend.HealthCheckSourceAddress) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid health check source address"})
+		return
+	}
+
+	// Validate the health check type
+	switch backend.HealthCheckType {
+	case "":
+		backend.HealthCheckType = models.HealthCheckTCP
+	case models.HealthCheckTCP:
+	case models.HealthCheckExec:
+		if backend.HealthCheckCommand == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Health check command is required for exec checks"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Health check type must be tcp or exec"})
+		return
+	}
+
+	// Keep the admin state unless it is changed explicitly
+	if backend.AdminState == "" {
+		existingBackend, err := s.db.GetBackend(uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Backend not found"})
+			return
+		}
+		backend.AdminState = existingBackend.AdminState
+	} else if !models.ValidAdminState(backend.AdminState) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid admin state"})
+		return
+	}
+
+	if err := s.db.UpdateBackend(&backend, changedBy(c)); err != nil {
+		storeError(c, err)
 		return
 	}
 
@@ -185,8 +406,8 @@ func (s *Server) deleteBackend(c *gin.Context) {
 		return
 	}
 
-	if err := s.db.DeleteBackend(uint(id), c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := s.db.DeleteBackend(uint(id), changedBy(c)); err != nil {
+		storeError(c, err)
 		return
 	}
 
@@ -222,8 +443,13 @@ func (s *Server) addBackendAddress(c *gin.Context) {
 		return
 	}
 
-	if err := s.db.CreateAddress(uint(backendID), &address, c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if address.AdminState != "" && !models.ValidAdminState(address.AdminState) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid admin state"})
+		return
+	}
+
+	if err := s.db.CreateAddress(uint(backendID), &address, changedBy(c)); err != nil {
+		storeError(c, err)
 		return
 	}
 
@@ -254,14 +480,22 @@ func (s *Server) updateAddress(c *gin.Context) {
 	address.ID = uint(id)
 	address.BackendID = existingAddress.BackendID
 
+	// Keep the admin state unless it is changed explicitly
+	if address.AdminState == "" {
+		address.AdminState = existingAddress.AdminState
+	} else if !models.ValidAdminState(address.AdminState) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid admin state"})
+		return
+	}
+
 	// Validate the IP address
 	if net.ParseIP(address.IP) == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
 		return
 	}
 
-	if err := s.db.UpdateAddress(&address, c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := s.db.UpdateAddress(&address, changedBy(c)); err != nil {
+		storeError(c, err)
 		return
 	}
 
@@ -275,8 +509,8 @@ func (s *Server) deleteAddress(c *gin.Context) {
 		return
 	}
 
-	if err := s.db.DeleteAddress(uint(id), c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := s.db.DeleteAddress(uint(id), changedBy(c)); err != nil {
+		storeError(c, err)
 		return
 	}
 
@@ -315,8 +549,8 @@ func (s *Server) createBackendSet(c *gin.Context) {
 		return
 	}
 
-	if err := s.db.CreateBackendSet(&backendSet, c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := s.db.CreateBackendSet(&backendSet, changedBy(c)); err != nil {
+		storeError(c, err)
 		return
 	}
 
@@ -337,8 +571,8 @@ func (s *Server) updateBackendSet(c *gin.Context) {
 	}
 
 	backendSet.ID = uint(id)
-	if err := s.db.UpdateBackendSet(&backendSet, c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := s.db.UpdateBackendSet(&backendSet, changedBy(c)); err != nil {
+		storeError(c, err)
 		return
 	}
 
@@ -352,8 +586,8 @@ func (s *Server) deleteBackendSet(c *gin.Context) {
 		return
 	}
 
-	if err := s.db.DeleteBackendSet(uint(id), c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := s.db.DeleteBackendSet(uint(id), changedBy(c)); err != nil {
+		storeError(c, err)
 		return
 	}
 
@@ -397,9 +631,17 @@ func (s *Server) createSourceDefinition(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source definition parameters"})
 		return
 	}
+	// Source definitions of partner operators belong to their partner
+	if principal := requestPrincipal(c); principal != nil && sourceDefinition.Partner == "" {
+		sourceDefinition.Partner = principal.Partner
+	}
+	if !partnerAllowed(c, sourceDefinition.Partner) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Source definition belongs to another partner"})
+		return
+	}
 
-	if err := s.db.CreateSourceDefinition(&sourceDefinition, c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := s.db.CreateSourceDefinition(&sourceDefinition, changedBy(c)); err != nil {
+		storeError(c, err)
 		return
 	}
 
@@ -426,9 +668,26 @@ func (s *Server) updateSourceDefinition(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source definition parameters"})
 		return
 	}
+	if !s.sourceDefinitionAllowed(c, sourceDefinition.ID) {
+		return
+	}
+	// Source definitions of partner operators belong to their partner
+	if principal := requestPrincipal(c); principal != nil && sourceDefinition.Partner == "" {
+		sourceDefinition.Partner = principal.Partner
+	}
+	if !partnerAllowed(c, sourceDefinition.Partner) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Source definition belongs to another partner"})
+		return
+	}
 
-	if err := s.db.UpdateSourceDefinition(&sourceDefinition, c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	err = s.db.UpdateSourceDefinition(&sourceDefinition, changedBy(c))
+	var conflictErr *conflict.Error
+	if errors.As(err, &conflictErr) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "conflicts": conflictErr.Conflicts})
+		return
+	}
+	if err != nil {
+		storeError(c, err)
 		return
 	}
 
@@ -442,8 +701,12 @@ func (s *Server) deleteSourceDefinition(c *gin.Context) {
 		return
 	}
 
-	if err := s.db.DeleteSourceDefinition(uint(id), c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !s.sourceDefinitionAllowed(c, uint(id)) {
+		return
+	}
+
+	if err := s.db.DeleteSourceDefinition(uint(id), changedBy(c)); err != nil {
+		storeError(c, err)
 		return
 	}
 
@@ -491,12 +754,26 @@ func (s *Server) createRule(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Backend set ID is required"})
 		return
 	}
+	if message := validateNodeScope(rule); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+	if !s.sourceDefinitionAllowed(c, rule.SourceDefinitionID) {
+		return
+	}
 
-	if err := s.db.CreateRule(&rule, c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	conflicts, err := s.db.CreateRule(&rule, changedBy(c))
+	var conflictErr *conflict.Error
+	if errors.As(err, &conflictErr) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "conflicts": conflictErr.Conflicts})
+		return
+	}
+	if err != nil {
+		storeError(c, err)
 		return
 	}
 
+	setConflictWarnings(c, conflicts)
 	c.JSON(http.StatusCreated, rule)
 }
 
@@ -524,15 +801,51 @@ func (s *Server) updateRule(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Backend set ID is required"})
 		return
 	}
+	if message := validateNodeScope(rule); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+	if !s.ruleAllowed(c, rule.ID) || !s.sourceDefinitionAllowed(c, rule.SourceDefinitionID) {
+		return
+	}
 
-	if err := s.db.UpdateRule(&rule, c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	conflicts, err := s.db.UpdateRule(&rule, changedBy(c))
+	var conflictErr *conflict.Error
+	if errors.As(err, &conflictErr) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "conflicts": conflictErr.Conflicts})
+		return
+	}
+	if err != nil {
+		storeError(c, err)
 		return
 	}
 
+	setConflictWarnings(c, conflicts)
 	c.JSON(http.StatusOK, rule)
 }
 
+// setConflictWarnings reports conflicts of a saved rule in Warning headers
+func setConflictWarnings(c *gin.Context, conflicts []conflict.Conflict) {
+	for _, ruleConflict := range conflicts {
+		c.Writer.Header().Add("Warning", fmt.Sprintf("299 - %q", ruleConflict.Description))
+	}
+}
+
+func (s *Server) getRuleConflicts(c *gin.Context) {
+	rules, err := s.db.GetActiveRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	nodes, err := s.db.GetIngressNodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, conflict.Find(rules, nodes))
+}
+
 func (s *Server) deleteRule(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
@@ -540,15 +853,32 @@ func (s *Server) deleteRule(c *gin.Context) {
 		return
 	}
 
-	if err := s.db.DeleteRule(uint(id), c.ClientIP()); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !s.ruleAllowed(c, uint(id)) {
+		return
+	}
+
+	if err := s.db.DeleteRule(uint(id), changedBy(c)); err != nil {
+		storeError(c, err)
 		return
 	}
 
 	c.Status(http.StatusNoContent)
 }
 
-func (s *Server) getConfigLogs(c *gin.Context) {
+// configEntityTypes are the entity types of configuration changes
+var configEntityTypes = map[string]bool{
+	"backend":            true,
+	"address":            true,
+	"backend_set":        true,
+	"source_definition":  true,
+	"rule":               true,
+	"maintenance_window": true,
+	"api_token":          true,
+	"role_assignment":    true,
+}
+
+// parsePagination reads the limit and offset query parameters
+func parsePagination(c *gin.Context) (int, int) {
 	limit := 100
 	offset := 0
 
@@ -568,7 +898,57 @@ func (s *Server) getConfigLogs(c *gin.Context) {
 		}
 	}
 
-	logs, err := s.db.GetConfigChangeLogs(limit, offset)
+	return limit, offset
+}
+
+// parseConfigChangeFilter reads the filter query parameters of the
+// configuration change log
+func parseConfigChangeFilter(c *gin.Context) (database.ConfigChangeFilter, error) {
+	filter := database.ConfigChangeFilter{
+		EntityType: c.Query("entity_type"),
+		ChangedBy:  c.Query("changed_by"),
+	}
+
+	if filter.EntityType != "" && !configEntityTypes[filter.EntityType] {
+		return filter, fmt.Errorf("unknown entity type %s", filter.EntityType)
+	}
+
+	if idParam := c.Query("entity_id"); idParam != "" {
+		id, err := strconv.ParseUint(idParam, 10, 32)
+		if err != nil {
+			return filter, fmt.Errorf("invalid entity_id parameter")
+		}
+		filter.EntityID = uint(id)
+	}
+
+	if fromParam := c.Query("from"); fromParam != "" {
+		from, err := time.Parse(time.RFC3339, fromParam)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from parameter: %v", err)
+		}
+		filter.From = from
+	}
+	if toParam := c.Query("to"); toParam != "" {
+		to, err := time.Parse(time.RFC3339, toParam)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to parameter: %v", err)
+		}
+		filter.To = to
+	}
+
+	return filter, nil
+}
+
+func (s *Server) getConfigLogs(c *gin.Context) {
+	limit, offset := parsePagination(c)
+
+	filter, err := parseConfigChangeFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	logs, err := s.db.GetConfigChangeLogs(filter, limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -577,26 +957,36 @@ func (s *Server) getConfigLogs(c *gin.Context) {
 	c.JSON(http.StatusOK, logs)
 }
 
-func (s *Server) getAvailabilityLogs(c *gin.Context) {
-	limit := 100
-	offset := 0
-
-	// Parse pagination parameters if provided
-	limitParam := c.Query("limit")
-	offsetParam := c.Query("offset")
+func (s *Server) getEntityHistory(c *gin.Context) {
+	entityType := c.Param("entity_type")
+	if !configEntityTypes[entityType] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown entity type " + entityType})
+		return
+	}
 
-	if limitParam != "" {
-		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
-			limit = parsedLimit
-		}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
 	}
 
-	if offsetParam != "" {
-		if parsedOffset, err := strconv.Atoi(offsetParam); err == nil && parsedOffset >= 0 {
-			offset = parsedOffset
-		}
+	limit, offset := parsePagination(c)
+
+	logs, err := s.db.GetConfigChangeLogs(database.ConfigChangeFilter{
+		EntityType: entityType,
+		EntityID:   uint(id),
+	}, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
+	c.JSON(http.StatusOK, logs)
+}
+
+func (s *Server) getAvailabilityLogs(c *gin.Context) {
+	limit, offset := parsePagination(c)
+
 	logs, err := s.db.GetAvailabilityLogs(limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
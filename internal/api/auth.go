@@ -0,0 +1,243 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/auth"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalKey is the context key of the authenticated principal
+const principalKey = "principal"
+
+// authenticate is a middleware requiring requests to be authenticated by
+// one of the configured authenticators
+func (s *Server) authenticate(c *gin.Context) {
+	if len(s.authenticators) == 0 {
+		c.Next()
+		return
+	}
+
+	for _, authenticator := range s.authenticators {
+		principal, err := authenticator.Authenticate(c.Request)
+		if errors.Is(err, auth.ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			s.logger.Warnf("Authentication of API request from %s failed: %v", c.ClientIP(), err)
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return
+		}
+
+		if err := s.resolveRole(principal); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+		return
+	}
+
+	c.Header("WWW-Authenticate", "Bearer")
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+}
+
+// resolveRole sets the role of a principal. Configured admins are admins
+// without a role assignment.
+func (s *Server) resolveRole(principal *auth.Principal) error {
+	for _, admin := range s.admins {
+		if admin == principal.Name {
+			principal.Role = auth.RoleAdmin
+			return nil
+		}
+	}
+
+	assignment, err := s.db.GetRoleAssignmentByPrincipal(principal.Name)
+	if err != nil {
+		return err
+	}
+	if assignment != nil {
+		principal.Role = assignment.Role
+		principal.Partner = assignment.Partner
+	}
+	return nil
+}
+
+// authorize returns a middleware requiring the role of the principal to
+// allow an action on an entity type. Everything is allowed if the API is
+// open.
+func (s *Server) authorize(action, entity string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := requestPrincipal(c)
+		if principal == nil {
+			c.Next()
+			return
+		}
+
+		if principal.Role == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "No role is assigned to " + principal.Name})
+			return
+		}
+		if !auth.Allowed(principal.Role, action, entity) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Role " + principal.Role + " may not " + action + " " + entity})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// canRead returns a middleware requiring read access to an entity type
+func (s *Server) canRead(entity string) gin.HandlerFunc {
+	return s.authorize(auth.ActionRead, entity)
+}
+
+// canWrite returns a middleware requiring write access to an entity type
+func (s *Server) canWrite(entity string) gin.HandlerFunc {
+	return s.authorize(auth.ActionWrite, entity)
+}
+
+// requestPrincipal returns the authenticated principal of a request, or nil
+// if the API is open
+func requestPrincipal(c *gin.Context) *auth.Principal {
+	if value, ok := c.Get(principalKey); ok {
+		return value.(*auth.Principal)
+	}
+	return nil
+}
+
+// partnerAllowed reports whether the principal of a request may change the
+// configuration of a partner. Only partner-scoped operators are restricted.
+func partnerAllowed(c *gin.Context, partner string) bool {
+	principal := requestPrincipal(c)
+	if principal == nil || principal.Role != auth.RolePartnerOperator {
+		return true
+	}
+	return partner == principal.Partner
+}
+
+// sourceDefinitionAllowed checks that the principal of a request may change
+// the configuration using a source definition. It responds with an error
+// and returns false otherwise.
+func (s *Server) sourceDefinitionAllowed(c *gin.Context, id uint) bool {
+	principal := requestPrincipal(c)
+	if principal == nil || principal.Role != auth.RolePartnerOperator {
+		return true
+	}
+
+	sourceDefinition, err := s.db.GetSourceDefinition(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Source definition not found"})
+		return false
+	}
+	if sourceDefinition.Partner != principal.Partner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Source definition belongs to another partner"})
+		return false
+	}
+	return true
+}
+
+// ruleAllowed checks that the principal of a request may change an
+// existing rule. It responds with an error and returns false otherwise.
+func (s *Server) ruleAllowed(c *gin.Context, id uint) bool {
+	principal := requestPrincipal(c)
+	if principal == nil || principal.Role != auth.RolePartnerOperator {
+		return true
+	}
+
+	rule, err := s.db.GetRule(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return false
+	}
+	return s.sourceDefinitionAllowed(c, rule.SourceDefinitionID)
+}
+
+// changedBy returns the name recorded for configuration changes made by a
+// request: the authenticated principal, or if the API is open the user ID of
+// a unix socket peer or the client IP
+func changedBy(c *gin.Context) string {
+	if principal := requestPrincipal(c); principal != nil {
+		return principal.Name
+	}
+	if uid, ok := auth.PeerUID(c.Request); ok {
+		return auth.PeerPrincipal(uid)
+	}
+	return c.ClientIP()
+}
+
+func (s *Server) getPrincipal(c *gin.Context) {
+	principal := requestPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Authentication is disabled"})
+		return
+	}
+
+	c.JSON(http.StatusOK, principal)
+}
+
+func (s *Server) getAPITokens(c *gin.Context) {
+	tokens, err := s.db.GetAllAPITokens()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (s *Server) createAPIToken(c *gin.Context) {
+	var request struct {
+		Name      string     `json:"name"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+
+	token, hash, err := auth.GenerateToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	apiToken := models.APIToken{
+		Name:      request.Name,
+		TokenHash: hash,
+		ExpiresAt: request.ExpiresAt,
+	}
+	if err := s.db.CreateAPIToken(&apiToken, changedBy(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The token itself is only returned once
+	c.JSON(http.StatusCreated, gin.H{"token": token, "api_token": apiToken})
+}
+
+func (s *Server) deleteAPIToken(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if err := s.db.DeleteAPIToken(uint(id), changedBy(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
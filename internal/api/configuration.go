@@ -0,0 +1,95 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/conflict"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/declarative"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) exportConfiguration(c *gin.Context) {
+	format := c.DefaultQuery("format", "yaml")
+	if format != "yaml" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be yaml or json"})
+		return
+	}
+
+	doc, err := s.db.ExportConfiguration()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := declarative.Marshal(doc, format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	contentType := "application/yaml"
+	if format == "json" {
+		contentType = "application/json"
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func (s *Server) importConfiguration(c *gin.Context) {
+	prune, err := parseBoolQuery(c, "prune")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	dryRun, err := parseBoolQuery(c, "dry_run")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// YAML is a superset of JSON, so both are accepted
+	data, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	doc, err := declarative.Parse(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := s.db.ImportConfiguration(doc, prune, dryRun, changedBy(c))
+	var conflictErr *conflict.Error
+	if errors.As(err, &conflictErr) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "conflicts": conflictErr.Conflicts})
+		return
+	}
+	if errors.Is(err, declarative.ErrInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+
+	setConflictWarnings(c, result.Conflicts)
+	c.JSON(http.StatusOK, result)
+}
+
+// parseBoolQuery parses an optional boolean query parameter
+func parseBoolQuery(c *gin.Context, name string) (bool, error) {
+	param := c.Query(name)
+	if param == "" {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(param)
+	if err != nil {
+		return false, fmt.Errorf("Invalid %s parameter", name)
+	}
+	return value, nil
+}
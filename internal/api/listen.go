@@ -0,0 +1,95 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// UnixPrefix marks listen addresses that are unix socket paths
+const UnixPrefix = "unix:"
+
+// UnixSocketConfig holds the permissions of unix socket listeners
+type UnixSocketConfig struct {
+	// Mode is the file mode of the socket, 0660 if zero
+	Mode os.FileMode
+	// Group owns the socket if set, by name or numeric ID
+	Group string
+}
+
+// ListenAddrs splits a comma-separated list of listen addresses
+func ListenAddrs(listenAddr string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(listenAddr, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// IsUnixAddr reports whether a listen address is a unix socket path
+func IsUnixAddr(addr string) bool {
+	return strings.HasPrefix(addr, UnixPrefix)
+}
+
+// listenUnix creates a unix socket listener, replacing a stale socket left
+// behind by a previous run
+func listenUnix(path string, config UnixSocketConfig) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %v", path, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := config.Mode
+	if mode == 0 {
+		mode = 0660
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set mode of socket %s: %v", path, err)
+	}
+
+	if config.Group != "" {
+		gid, err := lookupGroup(config.Group)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		if err := os.Chown(path, -1, gid); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to set group of socket %s: %v", path, err)
+		}
+	}
+
+	return listener, nil
+}
+
+// lookupGroup returns the ID of a group given by name or numeric ID
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, fmt.Errorf("unknown group %s: %v", group, err)
+	}
+	return strconv.Atoi(g.Gid)
+}
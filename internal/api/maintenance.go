@@ -0,0 +1,212 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminStateRequest is the body of the admin state routes
+type adminStateRequest struct {
+	AdminState string `json:"admin_state" binding:"required"`
+}
+
+func (s *Server) setBackendState(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var request adminStateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.ValidAdminState(request.AdminState) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid admin state"})
+		return
+	}
+
+	if _, err := s.db.GetBackend(uint(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backend not found"})
+		return
+	}
+
+	if err := s.db.SetBackendAdminState(uint(id), request.AdminState, changedBy(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	backend, err := s.db.GetBackend(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, backend)
+}
+
+func (s *Server) setAddressState(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var request adminStateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.ValidAdminState(request.AdminState) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid admin state"})
+		return
+	}
+
+	if _, err := s.db.GetAddress(uint(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+		return
+	}
+
+	if err := s.db.SetAddressAdminState(uint(id), request.AdminState, changedBy(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	address, err := s.db.GetAddress(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, address)
+}
+
+func (s *Server) getMaintenanceWindows(c *gin.Context) {
+	windows, err := s.db.GetMaintenanceWindows()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, windows)
+}
+
+func (s *Server) getMaintenanceWindow(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	window, err := s.db.GetMaintenanceWindow(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Maintenance window not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, window)
+}
+
+func (s *Server) createMaintenanceWindow(c *gin.Context) {
+	var window models.MaintenanceWindow
+	if err := c.ShouldBindJSON(&window); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the maintenance window
+	if window.AdminState != models.AdminStateDraining && window.AdminState != models.AdminStateMaintenance {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Admin state must be draining or maintenance"})
+		return
+	}
+	if !window.StartsAt.Before(window.EndsAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start time must be before end time"})
+		return
+	}
+
+	switch window.EntityType {
+	case "backend":
+		if _, err := s.db.GetBackend(window.EntityID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Backend not found"})
+			return
+		}
+	case "address":
+		if _, err := s.db.GetAddress(window.EntityID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Address not found"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Entity type must be backend or address"})
+		return
+	}
+
+	if err := s.db.CreateMaintenanceWindow(&window, changedBy(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, window)
+}
+
+func (s *Server) updateMaintenanceWindow(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	window, err := s.db.GetMaintenanceWindow(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Maintenance window not found"})
+		return
+	}
+	if window.Status == models.MaintenanceCompleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Maintenance window is already completed"})
+		return
+	}
+
+	// Only the schedule and description can be changed
+	var update models.MaintenanceWindow
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if window.Status == models.MaintenanceActive && !update.StartsAt.Equal(window.StartsAt) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Start time of an active maintenance window cannot be changed"})
+		return
+	}
+	if !update.StartsAt.Before(update.EndsAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start time must be before end time"})
+		return
+	}
+
+	window.StartsAt = update.StartsAt
+	window.EndsAt = update.EndsAt
+	window.Description = update.Description
+
+	if err := s.db.UpdateMaintenanceWindow(window, changedBy(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, window)
+}
+
+func (s *Server) deleteMaintenanceWindow(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if err := s.db.DeleteMaintenanceWindow(uint(id), changedBy(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
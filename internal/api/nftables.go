@@ -0,0 +1,100 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/nftables"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/updater"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) getAppliedState(c *gin.Context) {
+	state := s.nft.AppliedState()
+	if state == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No rules have been applied yet"})
+		return
+	}
+
+	c.JSON(http.StatusOK, state)
+}
+
+func (s *Server) getDrift(c *gin.Context) {
+	drift := s.nft.CheckDrift()
+	if drift == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No rules have been applied yet"})
+		return
+	}
+
+	c.JSON(http.StatusOK, drift)
+}
+
+func (s *Server) createPlan(c *gin.Context) {
+	var changes updater.Changes
+	if err := c.ShouldBindJSON(&changes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := s.updater.Plan(changes)
+	if errors.Is(err, updater.ErrInvalidChanges) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+func (s *Server) lookupFlow(c *gin.Context) {
+	port, err := strconv.Atoi(c.Query("port"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid port"})
+		return
+	}
+
+	flow := nftables.Flow{
+		SourceIP:        c.Query("source_ip"),
+		DestinationIP:   c.Query("destination_ip"),
+		DestinationPort: port,
+		Protocol:        c.DefaultQuery("protocol", "tcp"),
+	}
+
+	// Look up the flow in the rules and availability of this node
+	rules, err := s.updater.ActiveRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	addresses := make(map[uint][]models.Address)
+	for _, rule := range rules {
+		if _, ok := addresses[rule.BackendSetID]; ok {
+			continue
+		}
+		available, err := s.updater.AvailableAddresses(rule.BackendSetID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		addresses[rule.BackendSetID] = available
+	}
+
+	lookup, err := s.nft.LookupFlow(flow, rules, addresses)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if drift := s.nft.CheckDrift(); drift != nil && !drift.InSync {
+		lookup.Warnings = append(lookup.Warnings, "the rules in the kernel differ from the applied ones")
+	}
+
+	c.JSON(http.StatusOK, lookup)
+}
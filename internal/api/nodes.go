@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validateNodeScope checks the node names and groups a rule is scoped to
+func validateNodeScope(rule models.Rule) string {
+	for _, name := range rule.Nodes {
+		if name == "" {
+			return "Node names must not be empty"
+		}
+	}
+	for _, group := range rule.NodeGroups {
+		if group == "" {
+			return "Node groups must not be empty"
+		}
+	}
+	return ""
+}
+
+func (s *Server) getIngressNodes(c *gin.Context) {
+	nodes, err := s.db.GetIngressNodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, nodes)
+}
+
+func (s *Server) getNodeAvailability(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if _, err := s.db.GetIngressNode(uint(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+		return
+	}
+
+	availabilities, err := s.db.GetNodeAvailabilities(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, availabilities)
+}
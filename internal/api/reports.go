@@ -0,0 +1,155 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/report"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultReportRange is the time range covered by reports without a from parameter
+const defaultReportRange = 30 * 24 * time.Hour
+
+// parseTimeRange reads the RFC 3339 from and to query parameters. The range
+// defaults to the last 30 days.
+func parseTimeRange(c *gin.Context) (time.Time, time.Time, error) {
+	to := time.Now()
+	if toParam := c.Query("to"); toParam != "" {
+		parsed, err := time.Parse(time.RFC3339, toParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to parameter: %v", err)
+		}
+		to = parsed
+	}
+
+	from := to.Add(-defaultReportRange)
+	if fromParam := c.Query("from"); fromParam != "" {
+		parsed, err := time.Parse(time.RFC3339, fromParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from parameter: %v", err)
+		}
+		from = parsed
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+
+	return from, to, nil
+}
+
+// loadReportData fetches the availability logs and health check samples of
+// the given addresses
+func (s *Server) loadReportData(addresses []models.Address, from, to time.Time) ([]models.AvailabilityLog, []models.HealthCheckSample, error) {
+	ids := make([]uint, 0, len(addresses))
+	for _, address := range addresses {
+		ids = append(ids, address.ID)
+	}
+
+	logs, err := s.db.GetAvailabilityHistory(ids, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	samples, err := s.db.GetHealthCheckSamples(ids, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return logs, samples, nil
+}
+
+func (s *Server) getAddressReport(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	address, err := s.db.GetAddress(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+		return
+	}
+
+	logs, samples, err := s.loadReportData([]models.Address{*address}, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report.ForAddress(*address, logs, samples, from, to))
+}
+
+func (s *Server) getBackendReport(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	backend, err := s.db.GetBackend(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backend not found"})
+		return
+	}
+
+	logs, samples, err := s.loadReportData(backend.Addresses, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report.ForGroup("backend", backend.ID, backend.Name, backend.Addresses, logs, samples, from, to))
+}
+
+func (s *Server) getBackendSetReport(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	from, to, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	backendSet, err := s.db.GetBackendSet(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backend set not found"})
+		return
+	}
+
+	addresses, err := s.db.GetBackendSetAddresses(backendSet.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	logs, samples, err := s.loadReportData(addresses, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report.ForGroup("backend_set", backendSet.ID, backendSet.Name, addresses, logs, samples, from, to))
+}
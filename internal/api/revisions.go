@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) getRevisions(c *gin.Context) {
+	limit, offset := parsePagination(c)
+
+	revisions, err := s.db.GetRevisions(limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, revisions)
+}
+
+func (s *Server) getRevision(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	revision, err := s.db.GetRevision(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, revision)
+}
+
+func (s *Server) getRevisionConfiguration(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if _, err := s.db.GetRevision(uint(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+
+	config, err := s.db.GetConfigurationAt(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, config)
+}
+
+func (s *Server) diffRevisions(c *gin.Context) {
+	from, err := strconv.ParseUint(c.Query("from"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or missing from parameter"})
+		return
+	}
+
+	// Compare with the current revision by default
+	var to uint64
+	if toParam := c.Query("to"); toParam != "" {
+		if to, err = strconv.ParseUint(toParam, 10, 32); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to parameter"})
+			return
+		}
+	} else {
+		latest, err := s.db.GetLatestRevision()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		to = uint64(latest.ID)
+	}
+
+	for _, id := range []uint64{from, to} {
+		if _, err := s.db.GetRevision(uint(id)); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Revision %d not found", id)})
+			return
+		}
+	}
+
+	diffs, err := s.db.DiffRevisions(uint(from), uint(to))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "changes": diffs})
+}
+
+func (s *Server) restoreRevision(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if _, err := s.db.GetRevision(uint(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+
+	revision, err := s.db.RestoreRevision(uint(id), changedBy(c))
+	if err != nil {
+		storeError(c, err)
+		return
+	}
+	if revision == nil {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("The configuration already matches revision %d", id)})
+		return
+	}
+
+	c.JSON(http.StatusCreated, revision)
+}
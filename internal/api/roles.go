@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/auth"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validateRoleAssignment checks the role and partner of a role assignment
+func validateRoleAssignment(assignment models.RoleAssignment) string {
+	if assignment.Principal == "" {
+		return "Principal is required"
+	}
+	if !auth.ValidRole(assignment.Role) {
+		return "Role must be one of viewer, operator, partner_operator or admin"
+	}
+	if assignment.Role == auth.RolePartnerOperator && assignment.Partner == "" {
+		return "Partner is required for partner operators"
+	}
+	if assignment.Role != auth.RolePartnerOperator && assignment.Partner != "" {
+		return "Partner is only allowed for partner operators"
+	}
+	return ""
+}
+
+func (s *Server) getRoleAssignments(c *gin.Context) {
+	assignments, err := s.db.GetAllRoleAssignments()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, assignments)
+}
+
+func (s *Server) createRoleAssignment(c *gin.Context) {
+	var assignment models.RoleAssignment
+	if err := c.ShouldBindJSON(&assignment); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if message := validateRoleAssignment(assignment); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	if err := s.db.CreateRoleAssignment(&assignment, changedBy(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, assignment)
+}
+
+func (s *Server) updateRoleAssignment(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	existing, err := s.db.GetRoleAssignment(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role assignment not found"})
+		return
+	}
+
+	var assignment models.RoleAssignment
+	if err := c.ShouldBindJSON(&assignment); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	assignment.Model = existing.Model
+
+	if message := validateRoleAssignment(assignment); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	if err := s.db.UpdateRoleAssignment(&assignment, changedBy(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, assignment)
+}
+
+func (s *Server) deleteRoleAssignment(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if err := s.db.DeleteRoleAssignment(uint(id), changedBy(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/cluster"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// pingTimeout limits the database check of the status routes
+	pingTimeout = 2 * time.Second
+
+	// checkerStaleAfter is how long the health checker may go without
+	// scheduling checks before it is considered stuck
+	checkerStaleAfter = 30 * time.Second
+)
+
+// componentStatus is the state of a component in a status response
+type componentStatus struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// nftablesStatus is the state of the nftables updater
+type nftablesStatus struct {
+	componentStatus
+	LastAttempt         time.Time `json:"last_attempt"`
+	LastSuccess         time.Time `json:"last_success"`
+	SecondsSinceSuccess float64   `json:"seconds_since_success"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastErrorAt         time.Time `json:"last_error_at"`
+}
+
+// checkerStatus is the state of the health checker
+type checkerStatus struct {
+	componentStatus
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+}
+
+// statusResponse is the body of the status routes
+type statusResponse struct {
+	Status        string          `json:"status"`
+	Database      componentStatus `json:"database"`
+	NFTables      nftablesStatus  `json:"nftables"`
+	HealthChecker checkerStatus   `json:"health_checker"`
+	Node          cluster.Node    `json:"node"`
+}
+
+// collectStatus gathers the state of the database, the nftables updater and
+// the health checker
+func (s *Server) collectStatus(ctx context.Context) statusResponse {
+	now := time.Now()
+	response := statusResponse{Node: s.elector.Node()}
+
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	if err := s.db.Ping(pingCtx); err != nil {
+		response.Database.Error = err.Error()
+	} else {
+		response.Database.OK = true
+	}
+
+	// The ruleset is stale if no update succeeded within the threshold,
+	// counting from the start of the server until the first update
+	updates := s.updater.Status()
+	lastSuccess := updates.LastSuccess
+	if lastSuccess.IsZero() {
+		lastSuccess = s.started
+	}
+	response.NFTables = nftablesStatus{
+		LastAttempt:         updates.LastAttempt,
+		LastSuccess:         updates.LastSuccess,
+		SecondsSinceSuccess: now.Sub(lastSuccess).Seconds(),
+		LastError:           updates.LastError,
+		LastErrorAt:         updates.LastErrorAt,
+	}
+	if now.Sub(lastSuccess) > s.staleThreshold {
+		response.NFTables.Error = "ruleset is stale"
+	} else {
+		response.NFTables.OK = true
+	}
+
+	heartbeat := s.checker.Heartbeat()
+	response.HealthChecker.LastHeartbeat = heartbeat
+	if heartbeat.IsZero() {
+		heartbeat = s.started
+	}
+	if now.Sub(heartbeat) > checkerStaleAfter {
+		response.HealthChecker.Error = "health checker is not running"
+	} else {
+		response.HealthChecker.OK = true
+	}
+
+	return response
+}
+
+// getNode returns the identity and role of the node
+func (s *Server) getNode(c *gin.Context) {
+	c.JSON(http.StatusOK, s.elector.Node())
+}
+
+// getLiveness reports whether the manager is running. It fails if the
+// health checker is stuck; database and ruleset problems are reported but
+// only affect readiness, as a restart would not fix them.
+func (s *Server) getLiveness(c *gin.Context) {
+	response := s.collectStatus(c.Request.Context())
+
+	status := http.StatusOK
+	response.Status = "ok"
+	if !response.HealthChecker.OK {
+		status = http.StatusServiceUnavailable
+		response.Status = "unavailable"
+	}
+
+	c.JSON(status, response)
+}
+
+// getReadiness reports whether the manager is connected to the database,
+// keeps the ruleset up to date and checks the backends
+func (s *Server) getReadiness(c *gin.Context) {
+	response := s.collectStatus(c.Request.Context())
+
+	status := http.StatusOK
+	response.Status = "ok"
+	if !response.Database.OK || !response.NFTables.OK || !response.HealthChecker.OK {
+		status = http.StatusServiceUnavailable
+		response.Status = "unavailable"
+	}
+
+	c.JSON(status, response)
+}
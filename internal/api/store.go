@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/conflict"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/database"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/declarative"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Store is the storage of the configuration and its history. Stores may
+// refuse changes with database.ErrReadOnly.
+type Store interface {
+	Ping(ctx context.Context) error
+
+	// Backends and addresses
+	GetAllBackends() ([]models.Backend, error)
+	GetBackend(id uint) (*models.Backend, error)
+	CreateBackend(backend *models.Backend, changedBy string) error
+	UpdateBackend(backend *models.Backend, changedBy string) error
+	DeleteBackend(id uint, changedBy string) error
+	SetBackendAdminState(id uint, state, changedBy string) error
+	GetAddress(id uint) (*models.Address, error)
+	CreateAddress(backendID uint, address *models.Address, changedBy string) error
+	UpdateAddress(address *models.Address, changedBy string) error
+	DeleteAddress(id uint, changedBy string) error
+	SetAddressAdminState(id uint, state, changedBy string) error
+
+	// Backend sets
+	GetAllBackendSets() ([]models.BackendSet, error)
+	GetBackendSet(id uint) (*models.BackendSet, error)
+	GetBackendSetAddresses(backendSetID uint) ([]models.Address, error)
+	CreateBackendSet(backendSet *models.BackendSet, changedBy string) error
+	UpdateBackendSet(backendSet *models.BackendSet, changedBy string) error
+	DeleteBackendSet(id uint, changedBy string) error
+
+	// Source definitions and rules
+	GetAllSourceDefinitions() ([]models.SourceDefinition, error)
+	GetSourceDefinition(id uint) (*models.SourceDefinition, error)
+	CreateSourceDefinition(sourceDefinition *models.SourceDefinition, changedBy string) error
+	UpdateSourceDefinition(sourceDefinition *models.SourceDefinition, changedBy string) error
+	DeleteSourceDefinition(id uint, changedBy string) error
+	GetAllRules() ([]models.Rule, error)
+	GetActiveRules() ([]models.Rule, error)
+	GetRule(id uint) (*models.Rule, error)
+	CreateRule(rule *models.Rule, changedBy string) ([]conflict.Conflict, error)
+	UpdateRule(rule *models.Rule, changedBy string) ([]conflict.Conflict, error)
+	DeleteRule(id uint, changedBy string) error
+
+	// Maintenance windows
+	GetMaintenanceWindows() ([]models.MaintenanceWindow, error)
+	GetMaintenanceWindow(id uint) (*models.MaintenanceWindow, error)
+	CreateMaintenanceWindow(window *models.MaintenanceWindow, changedBy string) error
+	UpdateMaintenanceWindow(window *models.MaintenanceWindow, changedBy string) error
+	DeleteMaintenanceWindow(id uint, changedBy string) error
+
+	// API tokens and roles
+	GetAllAPITokens() ([]models.APIToken, error)
+	CreateAPIToken(token *models.APIToken, changedBy string) error
+	DeleteAPIToken(id uint, changedBy string) error
+	GetAllRoleAssignments() ([]models.RoleAssignment, error)
+	GetRoleAssignment(id uint) (*models.RoleAssignment, error)
+	GetRoleAssignmentByPrincipal(principal string) (*models.RoleAssignment, error)
+	CreateRoleAssignment(assignment *models.RoleAssignment, changedBy string) error
+	UpdateRoleAssignment(assignment *models.RoleAssignment, changedBy string) error
+	DeleteRoleAssignment(id uint, changedBy string) error
+
+	// Revisions and the declarative configuration
+	GetRevisions(limit, offset int) ([]models.Revision, error)
+	GetRevision(id uint) (*models.Revision, error)
+	GetLatestRevision() (*models.Revision, error)
+	GetConfigurationAt(revision uint) (database.Configuration, error)
+	DiffRevisions(from, to uint) ([]database.EntityDiff, error)
+	RestoreRevision(id uint, changedBy string) (*models.Revision, error)
+	ExportConfiguration() (*declarative.Document, error)
+	ImportConfiguration(doc *declarative.Document, prune, dryRun bool, changedBy string) (*database.ImportResult, error)
+
+	// Ingress nodes
+	GetIngressNodes() ([]models.IngressNode, error)
+	GetIngressNode(id uint) (*models.IngressNode, error)
+	GetNodeAvailabilities(nodeID uint) ([]models.NodeAvailability, error)
+
+	// Logs and health history
+	GetConfigChangeLogs(filter database.ConfigChangeFilter, limit, offset int) ([]models.ConfigChange, error)
+	GetAvailabilityLogs(limit, offset int) ([]models.AvailabilityLog, error)
+	GetAvailabilityHistory(addressIDs []uint, from, to time.Time) ([]models.AvailabilityLog, error)
+	GetHealthCheckSamples(addressIDs []uint, from, to time.Time) ([]models.HealthCheckSample, error)
+}
+
+// storeError responds with the error of a failed change. Changes the store
+// does not allow are forbidden.
+func storeError(c *gin.Context, err error) {
+	if errors.Is(err, database.ErrReadOnly) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TLSConfig holds the TLS configuration of the API server
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// MinVersion is "1.2" or "1.3"
+	MinVersion string
+	// CipherSuites are the names of the allowed TLS 1.2 cipher suites, the
+	// Go defaults are used if empty. TLS 1.3 suites are not configurable.
+	CipherSuites []string
+	// ClientCAFile is a CA bundle client certificates are verified against.
+	// Client certificates are not requested if it is empty.
+	ClientCAFile string
+	// RequireClientCert rejects connections without a client certificate
+	RequireClientCert bool
+}
+
+// certReloader serves the TLS configuration and reads the certificate, key
+// and client CA bundle again when one of the files changes
+type certReloader struct {
+	config TLSConfig
+	base   *tls.Config
+	logger *logrus.Logger
+
+	mu       sync.Mutex
+	current  *tls.Config
+	modTimes []time.Time
+}
+
+// newTLSConfig creates the TLS configuration of the API server
+func newTLSConfig(config TLSConfig, logger *logrus.Logger) (*tls.Config, error) {
+	// The configuration of a connection replaces the outer one, so it has
+	// to offer HTTP/2 itself
+	base := &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+
+	switch config.MinVersion {
+	case "", "1.2":
+		base.MinVersion = tls.VersionTLS12
+	case "1.3":
+		base.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported TLS version %s", config.MinVersion)
+	}
+
+	if len(config.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range config.CipherSuites {
+			id, ok := suites[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("unsupported or insecure cipher suite %s", name)
+			}
+			base.CipherSuites = append(base.CipherSuites, id)
+		}
+	}
+
+	if config.ClientCAFile != "" {
+		if config.RequireClientCert {
+			base.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			base.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	reloader := &certReloader{
+		config: config,
+		base:   base,
+		logger: logger,
+	}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+
+	// GetCertificate is not used for connections, but tells net/http that
+	// no certificate files are needed
+	return &tls.Config{
+		MinVersion:         base.MinVersion,
+		NextProtos:         base.NextProtos,
+		GetCertificate:     reloader.getCertificate,
+		GetConfigForClient: reloader.getConfigForClient,
+	}, nil
+}
+
+// getCertificate returns the current certificate
+func (r *certReloader) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	config, err := r.getConfigForClient(hello)
+	if err != nil {
+		return nil, err
+	}
+	return &config.Certificates[0], nil
+}
+
+// getConfigForClient returns the configuration for a new connection,
+// reloading the files first if they changed
+func (r *certReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	if err := r.reload(); err != nil {
+		// Keep serving the last valid certificate
+		r.logger.Errorf("Failed to reload TLS certificate: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current, nil
+}
+
+// reload reads the certificate, key and client CA bundle if any of them
+// changed since they were last read
+func (r *certReloader) reload() error {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+
+	modTimes := make([]time.Time, 0, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.current != nil && equalTimes(modTimes, r.modTimes) {
+		return nil
+	}
+	// Files that failed to load are only read again after the next change
+	r.modTimes = modTimes
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	config := r.base.Clone()
+	config.Certificates = []tls.Certificate{cert}
+
+	if r.config.ClientCAFile != "" {
+		data, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("client CA bundle %s contains no certificates", r.config.ClientCAFile)
+		}
+		config.ClientCAs = pool
+	}
+
+	if r.current != nil {
+		r.logger.Info("Reloaded TLS certificate")
+	}
+	r.current = config
+	return nil
+}
+
+// equalTimes reports whether two lists of modification times are equal
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrNoCredentials is returned by an Authenticator if a request carries no
+// credentials it handles
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is an authenticated API client
+type Principal struct {
+	// Name recorded for the changes made by the client
+	Name string `json:"name"`
+	// Authentication method, e.g. "token" or "jwt"
+	Method string `json:"method"`
+	// Role assigned to the principal and the partner it is restricted to
+	Role    string `json:"role,omitempty"`
+	Partner string `json:"partner,omitempty"`
+}
+
+// Authenticator authenticates API requests
+type Authenticator interface {
+	// Authenticate returns the principal of a request, or ErrNoCredentials
+	// if the request carries no credentials the authenticator handles
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// bearerToken returns the token of a bearer Authorization header
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,28 @@
+package auth
+
+import "net/http"
+
+// ClientCertAuthenticator authenticates requests with the verified TLS
+// client certificate of the connection
+type ClientCertAuthenticator struct{}
+
+// NewClientCertAuthenticator creates an authenticator for TLS client
+// certificates
+func NewClientCertAuthenticator() *ClientCertAuthenticator {
+	return &ClientCertAuthenticator{}
+}
+
+// Authenticate implements Authenticator
+func (a *ClientCertAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	subject := r.TLS.VerifiedChains[0][0].Subject.String()
+	return &Principal{Name: CertPrincipal(subject), Method: "cert"}, nil
+}
+
+// CertPrincipal returns the principal name of a client certificate subject
+func CertPrincipal(subject string) string {
+	return "cert:" + subject
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTConfig holds the configuration of the JWT authenticator
+type JWTConfig struct {
+	// JWKSFile is the path of a JSON Web Key Set with the signing keys
+	JWKSFile string
+	// Issuer and Audience are required claim values if set
+	Issuer   string
+	Audience string
+	// PrincipalClaim is the claim used as the principal name, e.g. "sub"
+	PrincipalClaim string
+}
+
+// JWTAuthenticator authenticates requests with bearer JWTs signed by a key
+// of a JWKS file. The file is read again when it changes, so keys can be
+// rotated without a restart.
+type JWTAuthenticator struct {
+	config JWTConfig
+	parser *jwt.Parser
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	modTime time.Time
+}
+
+// jsonWebKey is a key of a JWKS file
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewJWTAuthenticator creates a JWT authenticator and loads its keys
+func NewJWTAuthenticator(config JWTConfig) (*JWTAuthenticator, error) {
+	if config.PrincipalClaim == "" {
+		config.PrincipalClaim = "sub"
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+
+	a := &JWTAuthenticator{
+		config: config,
+		parser: jwt.NewParser(options...),
+	}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Authenticate implements Authenticator
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	tokenString := bearerToken(r)
+	if tokenString == "" || strings.HasPrefix(tokenString, TokenPrefix) {
+		return nil, ErrNoCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(tokenString, claims, a.key); err != nil {
+		return nil, err
+	}
+
+	name, ok := claims[a.config.PrincipalClaim].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("token has no %s claim", a.config.PrincipalClaim)
+	}
+
+	return &Principal{Name: JWTPrincipal(name), Method: "jwt"}, nil
+}
+
+// JWTPrincipal returns the principal name of a JWT claim value
+func JWTPrincipal(claim string) string {
+	return "jwt:" + claim
+}
+
+// key returns the verification key of a token
+func (a *JWTAuthenticator) key(token *jwt.Token) (interface{}, error) {
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, nil
+		}
+	}
+
+	key, ok := a.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	return key, nil
+}
+
+// reload reads the JWKS file if it changed since it was last read
+func (a *JWTAuthenticator) reload() error {
+	info, err := os.Stat(a.config.JWKSFile)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS file: %v", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.keys != nil && info.ModTime().Equal(a.modTime) {
+		return nil
+	}
+
+	keys, err := loadJWKS(a.config.JWKSFile)
+	if err != nil {
+		return err
+	}
+	a.keys = keys
+	a.modTime = info.ModTime()
+	return nil
+}
+
+// loadJWKS reads the signing keys of a JWKS file by key ID
+func loadJWKS(path string) (map[string]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %v", err)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in JWKS file: %v", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS file contains no signing keys")
+	}
+
+	return keys, nil
+}
+
+// publicKey decodes a JSON Web Key
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+// decodeInt decodes a base64url encoded big-endian integer
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
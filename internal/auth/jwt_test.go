@@ -0,0 +1,369 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ec      *ecdsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+}
+
+func generateKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{rsa: rsaKey, ec: ecKey, ed25519: edKey}
+}
+
+func encodeInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// writeJWKS writes a JWKS file with the given keys and returns its path
+func writeJWKS(t *testing.T, keys ...jsonWebKey) string {
+	t.Helper()
+	data, err := json.Marshal(map[string][]jsonWebKey{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func (k testKeys) jwks() []jsonWebKey {
+	return []jsonWebKey{
+		{
+			Kty: "RSA", Kid: "rsa-1", Use: "sig",
+			N: encodeInt(k.rsa.N), E: encodeInt(big.NewInt(int64(k.rsa.E))),
+		},
+		{
+			Kty: "EC", Kid: "ec-1", Crv: "P-256",
+			X: encodeInt(k.ec.X), Y: encodeInt(k.ec.Y),
+		},
+		{
+			Kty: "OKP", Kid: "ed-1", Crv: "Ed25519",
+			X: base64.RawURLEncoding.EncodeToString(k.ed25519.Public().(ed25519.PublicKey)),
+		},
+	}
+}
+
+// sign returns a token with the given claims signed by key
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": "alice",
+		"iss": "https://idp.example.com",
+		"aud": "b2b-ingress-manager",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func withClaim(claims jwt.MapClaims, name string, value interface{}) jwt.MapClaims {
+	claims[name] = value
+	return claims
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	keys := generateKeys(t)
+	authenticator, err := NewJWTAuthenticator(JWTConfig{
+		JWKSFile: writeJWKS(t, keys.jwks()...),
+		Issuer:   "https://idp.example.com",
+		Audience: "b2b-ingress-manager",
+	})
+	if err != nil {
+		t.Fatalf("NewJWTAuthenticator() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{
+			name:  "RS256",
+			token: sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, validClaims()),
+			want:  "jwt:alice",
+		},
+		{
+			name:  "ES256",
+			token: sign(t, jwt.SigningMethodES256, "ec-1", keys.ec, validClaims()),
+			want:  "jwt:alice",
+		},
+		{
+			name:  "EdDSA",
+			token: sign(t, jwt.SigningMethodEdDSA, "ed-1", keys.ed25519, validClaims()),
+			want:  "jwt:alice",
+		},
+		{
+			name:  "none algorithm",
+			token: sign(t, jwt.SigningMethodNone, "rsa-1", jwt.UnsafeAllowNoneSignatureType, validClaims()),
+		},
+		{
+			name:  "HMAC with the public key as secret",
+			token: sign(t, jwt.SigningMethodHS256, "rsa-1", keys.rsa.N.Bytes(), validClaims()),
+		},
+		{
+			name:  "key of another kid",
+			token: sign(t, jwt.SigningMethodES256, "rsa-1", keys.ec, validClaims()),
+		},
+		{
+			name:  "unknown kid",
+			token: sign(t, jwt.SigningMethodRS256, "rsa-2", keys.rsa, validClaims()),
+		},
+		{
+			name:  "missing kid with several keys",
+			token: sign(t, jwt.SigningMethodRS256, "", keys.rsa, validClaims()),
+		},
+		{
+			name:  "expired",
+			token: sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, withClaim(validClaims(), "exp", time.Now().Add(-time.Hour).Unix())),
+		},
+		{
+			name:  "expired within the leeway",
+			token: sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, withClaim(validClaims(), "exp", time.Now().Add(-10*time.Second).Unix())),
+			want:  "jwt:alice",
+		},
+		{
+			name: "without expiration",
+			token: sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, func() jwt.MapClaims {
+				claims := validClaims()
+				delete(claims, "exp")
+				return claims
+			}()),
+		},
+		{
+			name:  "issuer mismatch",
+			token: sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, withClaim(validClaims(), "iss", "https://other.example.com")),
+		},
+		{
+			name:  "audience mismatch",
+			token: sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, withClaim(validClaims(), "aud", "other")),
+		},
+		{
+			name:  "one of several audiences",
+			token: sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, withClaim(validClaims(), "aud", []string{"other", "b2b-ingress-manager"})),
+			want:  "jwt:alice",
+		},
+		{
+			name:  "missing principal claim",
+			token: sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, withClaim(validClaims(), "sub", "")),
+		},
+		{
+			name:  "tampered payload",
+			token: tamper(sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, validClaims())),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/api/rules", nil)
+			request.Header.Set("Authorization", "Bearer "+test.token)
+
+			principal, err := authenticator.Authenticate(request)
+			if test.want == "" {
+				if err == nil {
+					t.Fatalf("Authenticate() = %+v, want an error", principal)
+				}
+				if err == ErrNoCredentials {
+					t.Fatalf("Authenticate() error = %v, want a rejection", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if principal.Name != test.want || principal.Method != "jwt" {
+				t.Errorf("Authenticate() = %+v, want %s", principal, test.want)
+			}
+		})
+	}
+}
+
+// tamper replaces the payload of a token with another subject
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	payload, _ := json.Marshal(withClaim(validClaims(), "sub", "mallory"))
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	return strings.Join(parts, ".")
+}
+
+func TestJWTAuthenticatorNoCredentials(t *testing.T) {
+	keys := generateKeys(t)
+	authenticator, err := NewJWTAuthenticator(JWTConfig{JWKSFile: writeJWKS(t, keys.jwks()...)})
+	if err != nil {
+		t.Fatalf("NewJWTAuthenticator() error = %v", err)
+	}
+
+	for _, header := range []string{"", "Basic YWxpY2U6c2VjcmV0", "Bearer " + TokenPrefix + "0123"} {
+		request := httptest.NewRequest("GET", "/api/rules", nil)
+		if header != "" {
+			request.Header.Set("Authorization", header)
+		}
+		if _, err := authenticator.Authenticate(request); err != ErrNoCredentials {
+			t.Errorf("Authenticate() with %q error = %v, want ErrNoCredentials", header, err)
+		}
+	}
+}
+
+func TestJWTAuthenticatorSingleKeyWithoutKid(t *testing.T) {
+	keys := generateKeys(t)
+	authenticator, err := NewJWTAuthenticator(JWTConfig{
+		JWKSFile:       writeJWKS(t, keys.jwks()[0]),
+		PrincipalClaim: "email",
+	})
+	if err != nil {
+		t.Fatalf("NewJWTAuthenticator() error = %v", err)
+	}
+
+	request := httptest.NewRequest("GET", "/api/rules", nil)
+	token := sign(t, jwt.SigningMethodRS256, "", keys.rsa, withClaim(validClaims(), "email", "alice@example.com"))
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	principal, err := authenticator.Authenticate(request)
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if principal.Name != "jwt:alice@example.com" {
+		t.Errorf("Authenticate() principal = %s, want jwt:alice@example.com", principal.Name)
+	}
+}
+
+func TestLoadJWKS(t *testing.T) {
+	keys := generateKeys(t)
+	rsaKey := keys.jwks()[0]
+
+	tests := []struct {
+		name    string
+		keys    []jsonWebKey
+		wantErr string
+	}{
+		{
+			name: "signing keys",
+			keys: keys.jwks(),
+		},
+		{
+			name:    "no keys",
+			keys:    []jsonWebKey{},
+			wantErr: "no signing keys",
+		},
+		{
+			name: "encryption keys are skipped",
+			keys: []jsonWebKey{func() jsonWebKey {
+				k := rsaKey
+				k.Use = "enc"
+				return k
+			}()},
+			wantErr: "no signing keys",
+		},
+		{
+			name:    "unsupported key type",
+			keys:    []jsonWebKey{{Kty: "oct", Kid: "hmac"}},
+			wantErr: "unsupported key type oct",
+		},
+		{
+			name:    "unsupported curve",
+			keys:    []jsonWebKey{{Kty: "EC", Kid: "ec", Crv: "secp256k1"}},
+			wantErr: "unsupported curve secp256k1",
+		},
+		{
+			name: "point not on the curve",
+			keys: []jsonWebKey{func() jsonWebKey {
+				k := keys.jwks()[1]
+				k.Y = encodeInt(new(big.Int).Add(keys.ec.Y, big.NewInt(1)))
+				return k
+			}()},
+			wantErr: "not on the curve",
+		},
+		{
+			name:    "invalid modulus",
+			keys:    []jsonWebKey{{Kty: "RSA", Kid: "rsa", N: "!", E: "AQAB"}},
+			wantErr: "invalid integer",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadJWKS(writeJWKS(t, test.keys...))
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("loadJWKS() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("loadJWKS() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticatorReloadsKeys(t *testing.T) {
+	keys := generateKeys(t)
+	path := writeJWKS(t, keys.jwks()[0])
+	authenticator, err := NewJWTAuthenticator(JWTConfig{JWKSFile: path})
+	if err != nil {
+		t.Fatalf("NewJWTAuthenticator() error = %v", err)
+	}
+
+	request := httptest.NewRequest("GET", "/api/rules", nil)
+	request.Header.Set("Authorization", "Bearer "+sign(t, jwt.SigningMethodES256, "ec-1", keys.ec, validClaims()))
+	if _, err := authenticator.Authenticate(request); err == nil {
+		t.Fatal("Authenticate() succeeded before the key was added")
+	}
+
+	// Rotate to the EC key with a later modification time
+	data, _ := json.Marshal(map[string][]jsonWebKey{"keys": keys.jwks()[1:2]})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := authenticator.Authenticate(request); err != nil {
+		t.Errorf("Authenticate() after rotation error = %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerKey is the context key of the peer credentials of a connection
+type peerKey struct{}
+
+// ConnContext stores the peer credentials of unix socket connections in
+// the connection context. It is meant for http.Server.ConnContext.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return ctx
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil || credErr != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, peerKey{}, *cred)
+}
+
+// PeerUID returns the user ID of the process connected to the unix socket
+// a request was received on
+func PeerUID(r *http.Request) (uint32, bool) {
+	cred, ok := r.Context().Value(peerKey{}).(unix.Ucred)
+	return cred.Uid, ok
+}
+
+// PeerPrincipal returns the principal name of a unix socket peer
+func PeerPrincipal(uid uint32) string {
+	return "uid:" + strconv.FormatUint(uint64(uid), 10)
+}
+
+// PeerAuthenticator authenticates requests received on a unix socket with
+// the user ID of the connected process
+type PeerAuthenticator struct{}
+
+// NewPeerAuthenticator creates an authenticator for unix socket peers
+func NewPeerAuthenticator() *PeerAuthenticator {
+	return &PeerAuthenticator{}
+}
+
+// Authenticate implements Authenticator
+func (a *PeerAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	uid, ok := PeerUID(r)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	return &Principal{Name: PeerPrincipal(uid), Method: "peer"}, nil
+}
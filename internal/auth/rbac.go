@@ -0,0 +1,77 @@
+package auth
+
+// Roles of API principals
+const (
+	// Reads the configuration, e.g. auditors
+	RoleViewer = "viewer"
+	// Manages the whole routing configuration
+	RoleOperator = "operator"
+	// Manages source definitions and rules of a single partner
+	RolePartnerOperator = "partner_operator"
+	// Also manages API tokens and role assignments
+	RoleAdmin = "admin"
+)
+
+// Actions on entities
+const (
+	ActionRead  = "read"
+	ActionWrite = "write"
+)
+
+// Entity types permissions are granted for. They match the entity types of
+// configuration changes where there is one.
+const (
+	EntityBackend           = "backend"
+	EntityAddress           = "address"
+	EntityBackendSet        = "backend_set"
+	EntitySourceDefinition  = "source_definition"
+	EntityRule              = "rule"
+	EntityMaintenanceWindow = "maintenance_window"
+	EntityAPIToken          = "api_token"
+	EntityRoleAssignment    = "role_assignment"
+	// Configuration revisions, restoring one changes the whole routing
+	// configuration
+	EntityRevision = "revision"
+	// The declarative routing configuration, importing it changes the whole
+	// routing configuration
+	EntityConfiguration = "configuration"
+	// Logs, reports and the nftables state
+	EntityStatus = "status"
+)
+
+// writable lists the entity types each role may change. Admins may change
+// everything.
+var writable = map[string][]string{
+	RoleOperator: {
+		EntityBackend, EntityAddress, EntityBackendSet, EntitySourceDefinition,
+		EntityRule, EntityMaintenanceWindow, EntityRevision, EntityConfiguration,
+	},
+	RolePartnerOperator: {EntitySourceDefinition, EntityRule},
+}
+
+// ValidRole checks if role is a known role
+func ValidRole(role string) bool {
+	switch role {
+	case RoleViewer, RoleOperator, RolePartnerOperator, RoleAdmin:
+		return true
+	default:
+		return false
+	}
+}
+
+// Allowed reports whether a role may perform an action on an entity type.
+// Every role may read everything.
+func Allowed(role, action, entity string) bool {
+	if !ValidRole(role) {
+		return false
+	}
+	if role == RoleAdmin || action == ActionRead {
+		return true
+	}
+	for _, e := range writable[role] {
+		if e == entity {
+			return true
+		}
+	}
+	return false
+}
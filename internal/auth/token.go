@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+)
+
+// TokenPrefix starts every static API token, which tells them apart from
+// JWTs
+const TokenPrefix = "b2b_"
+
+// TokenStore looks up static API tokens
+type TokenStore interface {
+	// GetAPITokenByHash returns the token with the given hash
+	GetAPITokenByHash(hash string) (*models.APIToken, error)
+	// TouchAPIToken records that a token has been used
+	TouchAPIToken(id uint, usedAt time.Time) error
+}
+
+// TokenAuthenticator authenticates requests with static API tokens stored
+// hashed in the database
+type TokenAuthenticator struct {
+	store TokenStore
+}
+
+// NewTokenAuthenticator creates an authenticator for static API tokens
+func NewTokenAuthenticator(store TokenStore) *TokenAuthenticator {
+	return &TokenAuthenticator{store: store}
+}
+
+// Authenticate implements Authenticator
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := bearerToken(r)
+	if !strings.HasPrefix(token, TokenPrefix) {
+		return nil, ErrNoCredentials
+	}
+
+	apiToken, err := a.store.GetAPITokenByHash(HashToken(token))
+	if err != nil {
+		return nil, errors.New("unknown API token")
+	}
+
+	now := time.Now()
+	if apiToken.ExpiresAt != nil && now.After(*apiToken.ExpiresAt) {
+		return nil, fmt.Errorf("API token %s has expired", apiToken.Name)
+	}
+
+	// Recording every use would write to the database on each request
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) > time.Minute {
+		_ = a.store.TouchAPIToken(apiToken.ID, now)
+	}
+
+	return &Principal{Name: TokenPrincipal(apiToken.Name), Method: "token"}, nil
+}
+
+// TokenPrincipal returns the principal name of an API token
+func TokenPrincipal(name string) string {
+	return "token:" + name
+}
+
+// GenerateToken returns a new random API token and its hash
+func GenerateToken() (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %v", err)
+	}
+
+	token := TokenPrefix + hex.EncodeToString(secret)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hash stored for an API token. Tokens are random, so
+// an unsalted hash is sufficient.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
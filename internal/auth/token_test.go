@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+)
+
+// fakeTokenStore holds API tokens by hash and records their use
+type fakeTokenStore struct {
+	tokens  map[string]*models.APIToken
+	touched []uint
+}
+
+func (s *fakeTokenStore) GetAPITokenByHash(hash string) (*models.APIToken, error) {
+	token, ok := s.tokens[hash]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	return token, nil
+}
+
+func (s *fakeTokenStore) TouchAPIToken(id uint, usedAt time.Time) error {
+	s.touched = append(s.touched, id)
+	return nil
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	recently := time.Now().Add(-10 * time.Second)
+
+	tests := []struct {
+		name      string
+		token     models.APIToken
+		header    string
+		want      string
+		wantErr   error
+		wantTouch bool
+	}{
+		{
+			name:      "valid",
+			token:     models.APIToken{Name: "ci"},
+			want:      "token:ci",
+			wantTouch: true,
+		},
+		{
+			name:      "not yet expired",
+			token:     models.APIToken{Name: "ci", ExpiresAt: &future},
+			want:      "token:ci",
+			wantTouch: true,
+		},
+		{
+			name:  "expired",
+			token: models.APIToken{Name: "ci", ExpiresAt: &past},
+		},
+		{
+			name:  "used recently",
+			token: models.APIToken{Name: "ci", LastUsedAt: &recently},
+			want:  "token:ci",
+		},
+		{
+			name:   "unknown token",
+			token:  models.APIToken{Name: "ci"},
+			header: "Bearer " + TokenPrefix + "unknown",
+		},
+		{
+			name:    "JWT",
+			token:   models.APIToken{Name: "ci"},
+			header:  "Bearer eyJhbGciOiJSUzI1NiJ9.e30.c2ln",
+			wantErr: ErrNoCredentials,
+		},
+		{
+			name:    "no Authorization header",
+			token:   models.APIToken{Name: "ci"},
+			header:  "-",
+			wantErr: ErrNoCredentials,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secret, hash, err := GenerateToken()
+			if err != nil {
+				t.Fatalf("GenerateToken() error = %v", err)
+			}
+			test.token.ID = 7
+			store := &fakeTokenStore{tokens: map[string]*models.APIToken{hash: &test.token}}
+
+			request := httptest.NewRequest("GET", "/api/rules", nil)
+			switch test.header {
+			case "":
+				request.Header.Set("Authorization", "Bearer "+secret)
+			case "-":
+			default:
+				request.Header.Set("Authorization", test.header)
+			}
+
+			principal, err := NewTokenAuthenticator(store).Authenticate(request)
+			switch {
+			case test.wantErr != nil:
+				if err != test.wantErr {
+					t.Fatalf("Authenticate() error = %v, want %v", err, test.wantErr)
+				}
+			case test.want == "":
+				if err == nil || err == ErrNoCredentials {
+					t.Fatalf("Authenticate() = %+v, %v, want a rejection", principal, err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("Authenticate() error = %v", err)
+				}
+				if principal.Name != test.want || principal.Method != "token" {
+					t.Errorf("Authenticate() = %+v, want %s", principal, test.want)
+				}
+			}
+
+			if touched := len(store.touched) > 0; touched != test.wantTouch {
+				t.Errorf("Authenticate() touched the token = %v, want %v", touched, test.wantTouch)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+// Package cluster elects the leader of the nodes sharing a database. The
+// leader runs health checks and maintenance windows, so that they are not
+// performed and logged once per node; every node applies the ruleset.
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Roles of nodes
+const (
+	RoleLeader   = "leader"
+	RoleFollower = "follower"
+)
+
+// DefaultLockID is the key of the advisory lock held by the leader
+const DefaultLockID = 0x62326269 // "b2bi"
+
+// Node is the identity and role of a node
+type Node struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	// Since is when the node took its current role
+	Since time.Time `json:"since"`
+	// Clustered is false for nodes that are the leader because they do
+	// not share their database
+	Clustered bool `json:"clustered"`
+}
+
+// Config for the elector
+type Config struct {
+	// NodeName identifies the node, e.g. its hostname
+	NodeName string
+	// LockID is the key of the PostgreSQL advisory lock held by the
+	// leader, nodes with the same key form a cluster
+	LockID int64
+	// Interval between attempts to become the leader and checks that the
+	// leader still holds the lock
+	Interval time.Duration
+}
+
+// Elector holds the leadership while it holds a session-level advisory
+// lock on a dedicated connection. The lock is released when the session
+// ends, so another node takes over if the leader stops or loses its
+// connection.
+type Elector struct {
+	db       *sql.DB
+	name     string
+	lockID   int64
+	interval time.Duration
+	logger   *logrus.Logger
+	conn     *sql.Conn
+	stop     chan struct{}
+	wg       sync.WaitGroup
+
+	mu     sync.RWMutex
+	leader bool
+	since  time.Time
+}
+
+// NewElector creates an elector using a PostgreSQL database
+func NewElector(db *sql.DB, config Config, logger *logrus.Logger) *Elector {
+	return &Elector{
+		db:       db,
+		name:     config.NodeName,
+		lockID:   config.LockID,
+		interval: config.Interval,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		since:    time.Now(),
+	}
+}
+
+// Standalone creates an elector for a node that does not share its
+// database, which is always the leader
+func Standalone(name string) *Elector {
+	e := &Elector{name: name, stop: make(chan struct{}), since: time.Now()}
+	e.setLeader(true)
+	return e
+}
+
+// Start begins trying to become the leader
+func (e *Elector) Start() {
+	if e.db == nil {
+		return
+	}
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		for {
+			e.elect()
+
+			select {
+			case <-ticker.C:
+			case <-e.stop:
+				e.resign()
+				return
+			}
+		}
+	}()
+	e.logger.Infof("Leader election started for node %s", e.name)
+}
+
+// Stop gives up the leadership so that another node takes over at once
+func (e *Elector) Stop() {
+	close(e.stop)
+	e.wg.Wait()
+}
+
+// IsLeader reports whether this node is the leader
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.leader
+}
+
+// Node returns the identity and role of this node
+func (e *Elector) Node() Node {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	node := Node{Name: e.name, Role: RoleFollower, Since: e.since, Clustered: e.db != nil}
+	if e.leader {
+		node.Role = RoleLeader
+	}
+	return node
+}
+
+// setLeader records a change of the role
+func (e *Elector) setLeader(leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if leader == e.leader {
+		return
+	}
+	e.leader = leader
+	e.since = time.Now()
+	if leader {
+		metrics.Leader.Set(1)
+	} else {
+		metrics.Leader.Set(0)
+	}
+}
+
+// elect checks that the leader still holds the lock, or tries to acquire
+// it otherwise
+func (e *Elector) elect() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+	defer cancel()
+
+	if e.conn != nil {
+		// The lock is held as long as the session is alive
+		err := e.conn.PingContext(ctx)
+		if err == nil {
+			return
+		}
+		e.logger.Errorf("Lost the leadership of node %s: %v", e.name, err)
+		discard(e.conn)
+		e.conn = nil
+		e.setLeader(false)
+		return
+	}
+
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		e.logger.Errorf("Leader election failed: %v", err)
+		return
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.lockID).Scan(&acquired); err != nil {
+		e.logger.Errorf("Leader election failed: %v", err)
+		// The lock may have been acquired before the query failed
+		discard(conn)
+		return
+	}
+	if !acquired {
+		conn.Close()
+		return
+	}
+
+	e.conn = conn
+	e.setLeader(true)
+	e.logger.Infof("Node %s is now the leader", e.name)
+}
+
+// resign releases the lock if this node is the leader
+func (e *Elector) resign() {
+	if e.conn == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+	defer cancel()
+	if _, err := e.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", e.lockID); err != nil {
+		e.logger.Errorf("Failed to release the leadership: %v", err)
+		discard(e.conn)
+	} else {
+		e.conn.Close()
+	}
+	e.conn = nil
+	e.setLeader(false)
+	e.logger.Infof("Node %s resigned as leader", e.name)
+}
+
+// discard closes the session of a connection instead of returning it to
+// the pool, which releases the locks it may still hold
+func discard(conn *sql.Conn) {
+	conn.Raw(func(interface{}) error {
+		return driver.ErrBadConn
+	})
+	conn.Close()
+}
@@ -0,0 +1,327 @@
+package conflict
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+)
+
+// Conflict kinds
+const (
+	// The rule overlaps rules with the same priority, so the order in which
+	// they are applied is undefined
+	EqualPriority = "equal_priority"
+	// All traffic of the rule is matched by rules with a higher priority
+	Shadowed = "shadowed"
+)
+
+// Conflict describes a rule whose traffic overlaps with other rules
+type Conflict struct {
+	Kind   string `json:"kind"`
+	RuleID uint   `json:"rule_id"`
+	// Rules with the same priority or shadowing the rule
+	With        []uint `json:"with"`
+	Description string `json:"description"`
+}
+
+// Error is returned if a rule overlaps rules with the same priority
+type Error struct {
+	Conflicts []Conflict
+}
+
+func (e *Error) Error() string {
+	descriptions := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		descriptions = append(descriptions, c.Description)
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+// span is an inclusive range of IPv4 addresses
+type span struct {
+	start uint32
+	end   uint32
+}
+
+// sourceSpan returns the addresses matched by a source definition
+func sourceSpan(source models.SourceDefinition) (span, error) {
+	switch source.Type {
+	case "ip":
+		ip := net.ParseIP(source.IPAddress).To4()
+		if ip == nil {
+			return span{}, fmt.Errorf("invalid IP address: %s", source.IPAddress)
+		}
+		value := binary.BigEndian.Uint32(ip)
+		return span{start: value, end: value}, nil
+
+	case "subnet":
+		_, ipnet, err := net.ParseCIDR(source.Subnet)
+		if err != nil || ipnet.IP.To4() == nil {
+			return span{}, fmt.Errorf("invalid subnet: %s", source.Subnet)
+		}
+		ones, _ := ipnet.Mask.Size()
+		start := binary.BigEndian.Uint32(ipnet.IP.To4())
+		return span{start: start, end: start | ^uint32(0)>>ones}, nil
+
+	case "range":
+		start := net.ParseIP(source.RangeStart).To4()
+		end := net.ParseIP(source.RangeEnd).To4()
+		if start == nil || end == nil {
+			return span{}, fmt.Errorf("invalid IP range: %s - %s", source.RangeStart, source.RangeEnd)
+		}
+		return span{start: binary.BigEndian.Uint32(start), end: binary.BigEndian.Uint32(end)}, nil
+
+	default:
+		return span{}, fmt.Errorf("unsupported source type: %s", source.Type)
+	}
+}
+
+// protocolsOverlap reports whether two rule protocols match common traffic
+func protocolsOverlap(a, b string) bool {
+	return a == b || a == "all" || b == "all"
+}
+
+// protocolCovers reports whether all traffic of protocol b matches protocol a
+func protocolCovers(a, b string) bool {
+	return a == b || a == "all"
+}
+
+// registry maps the names of the registered ingress nodes to their group
+type registry map[string]string
+
+// newRegistry returns the groups of the nodes
+func newRegistry(nodes []models.IngressNode) registry {
+	r := make(registry, len(nodes))
+	for _, node := range nodes {
+		r[node.Name] = node.Group
+	}
+	return r
+}
+
+// scoped reports whether a rule is restricted to some ingress nodes
+func scoped(rule models.Rule) bool {
+	return len(rule.Nodes) != 0 || len(rule.NodeGroups) != 0
+}
+
+// has reports whether a list contains a name
+func has(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// mayApplyOn reports whether a scoped rule may apply on a node. Nodes that
+// are not registered yet may join any group.
+func (r registry) mayApplyOn(rule models.Rule, node string) bool {
+	if has(rule.Nodes, node) {
+		return true
+	}
+	group, ok := r[node]
+	if !ok {
+		return len(rule.NodeGroups) != 0
+	}
+	return group != "" && has(rule.NodeGroups, group)
+}
+
+// scopesOverlap reports whether two rules may apply on a common node
+func (r registry) scopesOverlap(a, b models.Rule) bool {
+	if !scoped(a) || !scoped(b) {
+		return true
+	}
+	for _, group := range a.NodeGroups {
+		if has(b.NodeGroups, group) {
+			return true
+		}
+	}
+	for _, node := range a.Nodes {
+		if r.mayApplyOn(b, node) {
+			return true
+		}
+	}
+	for _, node := range b.Nodes {
+		if r.mayApplyOn(a, node) {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeCovers reports whether rule a surely applies on all nodes rule b
+// applies on, including nodes that join the groups of b later
+func (r registry) scopeCovers(a, b models.Rule) bool {
+	if !scoped(a) {
+		return true
+	}
+	if !scoped(b) {
+		return false
+	}
+	for _, group := range b.NodeGroups {
+		if !has(a.NodeGroups, group) {
+			return false
+		}
+	}
+	for _, node := range b.Nodes {
+		group, ok := r[node]
+		if !has(a.Nodes, node) && !(ok && group != "" && has(a.NodeGroups, group)) {
+			return false
+		}
+	}
+	return true
+}
+
+// overlaps reports whether two rules match common traffic
+func (r registry) overlaps(a, b models.Rule) bool {
+	if a.DestinationPort != b.DestinationPort || !protocolsOverlap(a.Protocol, b.Protocol) || !r.scopesOverlap(a, b) {
+		return false
+	}
+	spanA, err := sourceSpan(a.SourceDefinition)
+	if err != nil {
+		return false
+	}
+	spanB, err := sourceSpan(b.SourceDefinition)
+	if err != nil {
+		return false
+	}
+	return spanA.start <= spanB.end && spanB.start <= spanA.end
+}
+
+// shadowedBy returns the rules with a higher priority that together match
+// all traffic of the rule, or nil if some of its traffic gets through
+func (r registry) shadowedBy(rule models.Rule, rules []models.Rule) []uint {
+	target, err := sourceSpan(rule.SourceDefinition)
+	if err != nil {
+		return nil
+	}
+
+	var ids []uint
+	var spans []span
+	for _, other := range rules {
+		if other.ID == rule.ID || other.Priority <= rule.Priority || !protocolCovers(other.Protocol, rule.Protocol) || !r.scopeCovers(other, rule) || !r.overlaps(rule, other) {
+			continue
+		}
+		s, _ := sourceSpan(other.SourceDefinition)
+		ids = append(ids, other.ID)
+		spans = append(spans, s)
+	}
+
+	// Check whether the spans cover the target without gaps
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	next := uint64(target.start)
+	for _, s := range spans {
+		if uint64(s.start) > next {
+			break
+		}
+		if uint64(s.end)+1 > next {
+			next = uint64(s.end) + 1
+		}
+	}
+	if next <= uint64(target.end) {
+		return nil
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// equalPriority returns the rules with the same priority matching common
+// traffic with the rule
+func (r registry) equalPriority(rule models.Rule, rules []models.Rule) []uint {
+	var ids []uint
+	for _, other := range rules {
+		if other.ID != rule.ID && other.Priority == rule.Priority && r.overlaps(rule, other) {
+			ids = append(ids, other.ID)
+		}
+	}
+	return ids
+}
+
+func equalPriorityConflict(ruleID uint, with []uint) Conflict {
+	return Conflict{
+		Kind:        EqualPriority,
+		RuleID:      ruleID,
+		With:        with,
+		Description: fmt.Sprintf("rule %d overlaps %s with the same priority", ruleID, formatRules(with)),
+	}
+}
+
+func shadowedConflict(ruleID uint, by []uint) Conflict {
+	return Conflict{
+		Kind:        Shadowed,
+		RuleID:      ruleID,
+		With:        by,
+		Description: fmt.Sprintf("rule %d is shadowed by %s with a higher priority", ruleID, formatRules(by)),
+	}
+}
+
+// Check returns the conflicts caused by a rule: rules with the same
+// priority it overlaps, rules shadowing it and rules it shadows. Rules
+// must be the other enabled rules, nodes the registered ingress nodes
+// whose groups decide whether node-scoped rules overlap.
+func Check(rule models.Rule, rules []models.Rule, nodes []models.IngressNode) []Conflict {
+	r := newRegistry(nodes)
+	var conflicts []Conflict
+
+	if with := r.equalPriority(rule, rules); len(with) > 0 {
+		conflicts = append(conflicts, equalPriorityConflict(rule.ID, with))
+	}
+	if by := r.shadowedBy(rule, rules); len(by) > 0 {
+		conflicts = append(conflicts, shadowedConflict(rule.ID, by))
+	}
+
+	all := append([]models.Rule{rule}, rules...)
+	for _, other := range rules {
+		if other.Priority >= rule.Priority || !r.overlaps(rule, other) {
+			continue
+		}
+		by := r.shadowedBy(other, all)
+		for _, id := range by {
+			if id == rule.ID {
+				conflicts = append(conflicts, shadowedConflict(other.ID, by))
+				break
+			}
+		}
+	}
+
+	return conflicts
+}
+
+// Find returns all conflicts between enabled rules
+func Find(rules []models.Rule, nodes []models.IngressNode) []Conflict {
+	r := newRegistry(nodes)
+	conflicts := []Conflict{}
+	for _, rule := range rules {
+		var with []uint
+		for _, id := range r.equalPriority(rule, rules) {
+			// Report each pair once
+			if id > rule.ID {
+				with = append(with, id)
+			}
+		}
+		if len(with) > 0 {
+			conflicts = append(conflicts, equalPriorityConflict(rule.ID, with))
+		}
+		if by := r.shadowedBy(rule, rules); len(by) > 0 {
+			conflicts = append(conflicts, shadowedConflict(rule.ID, by))
+		}
+	}
+	return conflicts
+}
+
+// formatRules formats rule IDs as a list, e.g. "rules 1, 2"
+func formatRules(ids []uint) string {
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, fmt.Sprint(id))
+	}
+	if len(parts) == 1 {
+		return "rule " + parts[0]
+	}
+	return "rules " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,283 @@
+package conflict
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+)
+
+func ip(address string) models.SourceDefinition {
+	return models.SourceDefinition{Type: "ip", IPAddress: address}
+}
+
+func subnet(cidr string) models.SourceDefinition {
+	return models.SourceDefinition{Type: "subnet", Subnet: cidr}
+}
+
+func ipRange(start, end string) models.SourceDefinition {
+	return models.SourceDefinition{Type: "range", RangeStart: start, RangeEnd: end}
+}
+
+// rule returns an enabled TCP rule for port 443
+func rule(id uint, priority int, source models.SourceDefinition) models.Rule {
+	r := models.Rule{DestinationPort: 443, Protocol: "tcp", Priority: priority, SourceDefinition: source, Enabled: true}
+	r.ID = id
+	return r
+}
+
+func withProtocol(r models.Rule, protocol string) models.Rule {
+	r.Protocol = protocol
+	return r
+}
+
+func withPort(r models.Rule, port int) models.Rule {
+	r.DestinationPort = port
+	return r
+}
+
+func onNodes(r models.Rule, nodes ...string) models.Rule {
+	r.Nodes = nodes
+	return r
+}
+
+func inGroups(r models.Rule, groups ...string) models.Rule {
+	r.NodeGroups = groups
+	return r
+}
+
+// summarize formats conflicts without their descriptions
+func summarize(conflicts []Conflict) []string {
+	summary := []string{}
+	for _, c := range conflicts {
+		summary = append(summary, fmt.Sprintf("%s %d %v", c.Kind, c.RuleID, c.With))
+	}
+	return summary
+}
+
+var registeredNodes = []models.IngressNode{
+	{Name: "edge-1", Group: "site-a"},
+	{Name: "edge-2", Group: "site-b"},
+	{Name: "edge-3"},
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  models.Rule
+		rules []models.Rule
+		want  []string
+	}{
+		{
+			name:  "ip inside subnet with the same priority",
+			rule:  rule(1, 10, ip("10.0.0.5")),
+			rules: []models.Rule{rule(2, 10, subnet("10.0.0.0/24"))},
+			want:  []string{"equal_priority 1 [2]"},
+		},
+		{
+			name:  "disjoint subnets",
+			rule:  rule(1, 10, subnet("10.0.0.0/24")),
+			rules: []models.Rule{rule(2, 10, subnet("10.0.1.0/24"))},
+			want:  []string{},
+		},
+		{
+			name:  "range overlapping the last address of a subnet",
+			rule:  rule(1, 10, ipRange("10.0.0.255", "10.0.1.10")),
+			rules: []models.Rule{rule(2, 10, subnet("10.0.0.0/24"))},
+			want:  []string{"equal_priority 1 [2]"},
+		},
+		{
+			name:  "range ending before a subnet",
+			rule:  rule(1, 10, ipRange("9.255.255.0", "9.255.255.255")),
+			rules: []models.Rule{rule(2, 10, subnet("10.0.0.0/24"))},
+			want:  []string{},
+		},
+		{
+			name:  "/32 subnet and ip",
+			rule:  rule(1, 10, subnet("10.0.0.5/32")),
+			rules: []models.Rule{rule(2, 10, ip("10.0.0.5"))},
+			want:  []string{"equal_priority 1 [2]"},
+		},
+		{
+			name:  "different ports",
+			rule:  rule(1, 10, subnet("10.0.0.0/24")),
+			rules: []models.Rule{withPort(rule(2, 10, subnet("10.0.0.0/24")), 8443)},
+			want:  []string{},
+		},
+		{
+			name:  "tcp and udp",
+			rule:  rule(1, 10, subnet("10.0.0.0/24")),
+			rules: []models.Rule{withProtocol(rule(2, 10, subnet("10.0.0.0/24")), "udp")},
+			want:  []string{},
+		},
+		{
+			name:  "tcp and all protocols",
+			rule:  rule(1, 10, subnet("10.0.0.0/24")),
+			rules: []models.Rule{withProtocol(rule(2, 10, subnet("10.0.0.0/24")), "all")},
+			want:  []string{"equal_priority 1 [2]"},
+		},
+		{
+			name:  "fully shadowed by a wider subnet",
+			rule:  rule(1, 10, subnet("10.0.0.0/24")),
+			rules: []models.Rule{rule(2, 20, subnet("10.0.0.0/16"))},
+			want:  []string{"shadowed 1 [2]"},
+		},
+		{
+			name:  "partially shadowed by a narrower subnet",
+			rule:  rule(1, 10, subnet("10.0.0.0/16")),
+			rules: []models.Rule{rule(2, 20, subnet("10.0.0.0/24"))},
+			want:  []string{},
+		},
+		{
+			name: "shadowed by adjacent spans together",
+			rule: rule(1, 10, subnet("10.0.0.0/24")),
+			rules: []models.Rule{
+				rule(3, 20, ipRange("10.0.0.128", "10.0.0.255")),
+				rule(2, 20, subnet("10.0.0.0/25")),
+			},
+			want: []string{"shadowed 1 [2 3]"},
+		},
+		{
+			name: "gap between spans",
+			rule: rule(1, 10, subnet("10.0.0.0/24")),
+			rules: []models.Rule{
+				rule(2, 20, subnet("10.0.0.0/25")),
+				rule(3, 20, ipRange("10.0.0.129", "10.0.0.255")),
+			},
+			want: []string{},
+		},
+		{
+			name:  "/0 shadowed by /0",
+			rule:  rule(1, 10, subnet("0.0.0.0/0")),
+			rules: []models.Rule{rule(2, 20, subnet("0.0.0.0/0"))},
+			want:  []string{"shadowed 1 [2]"},
+		},
+		{
+			name:  "last address shadowed",
+			rule:  rule(1, 10, ip("255.255.255.255")),
+			rules: []models.Rule{rule(2, 20, subnet("255.255.255.0/24"))},
+			want:  []string{"shadowed 1 [2]"},
+		},
+		{
+			name:  "udp rule does not shadow all protocols",
+			rule:  withProtocol(rule(1, 10, subnet("10.0.0.0/24")), "all"),
+			rules: []models.Rule{withProtocol(rule(2, 20, subnet("10.0.0.0/16")), "udp")},
+			want:  []string{},
+		},
+		{
+			name:  "shadows a rule with a lower priority",
+			rule:  rule(1, 30, subnet("10.0.0.0/16")),
+			rules: []models.Rule{rule(2, 10, subnet("10.0.1.0/24"))},
+			want:  []string{"shadowed 2 [1]"},
+		},
+		{
+			name:  "rules on different nodes",
+			rule:  onNodes(rule(1, 10, subnet("10.0.0.0/24")), "edge-1"),
+			rules: []models.Rule{onNodes(rule(2, 10, subnet("10.0.0.0/24")), "edge-2")},
+			want:  []string{},
+		},
+		{
+			name:  "node in the group of the other rule",
+			rule:  onNodes(rule(1, 10, subnet("10.0.0.0/24")), "edge-1"),
+			rules: []models.Rule{inGroups(rule(2, 10, subnet("10.0.0.0/24")), "site-a")},
+			want:  []string{"equal_priority 1 [2]"},
+		},
+		{
+			name:  "node in another group",
+			rule:  onNodes(rule(1, 10, subnet("10.0.0.0/24")), "edge-1"),
+			rules: []models.Rule{inGroups(rule(2, 10, subnet("10.0.0.0/24")), "site-b")},
+			want:  []string{},
+		},
+		{
+			name:  "node without a group",
+			rule:  onNodes(rule(1, 10, subnet("10.0.0.0/24")), "edge-3"),
+			rules: []models.Rule{inGroups(rule(2, 10, subnet("10.0.0.0/24")), "site-a")},
+			want:  []string{},
+		},
+		{
+			name:  "unregistered node may join any group",
+			rule:  onNodes(rule(1, 10, subnet("10.0.0.0/24")), "edge-9"),
+			rules: []models.Rule{inGroups(rule(2, 10, subnet("10.0.0.0/24")), "site-b")},
+			want:  []string{"equal_priority 1 [2]"},
+		},
+		{
+			name:  "scoped and unscoped rules",
+			rule:  inGroups(rule(1, 10, subnet("10.0.0.0/24")), "site-a"),
+			rules: []models.Rule{rule(2, 10, subnet("10.0.0.0/24"))},
+			want:  []string{"equal_priority 1 [2]"},
+		},
+		{
+			name:  "shadowed on the nodes of its group",
+			rule:  onNodes(rule(1, 10, subnet("10.0.0.0/24")), "edge-1"),
+			rules: []models.Rule{inGroups(rule(2, 20, subnet("10.0.0.0/16")), "site-a")},
+			want:  []string{"shadowed 1 [2]"},
+		},
+		{
+			name:  "scoped rule does not shadow an unscoped rule",
+			rule:  rule(1, 10, subnet("10.0.0.0/24")),
+			rules: []models.Rule{inGroups(rule(2, 20, subnet("10.0.0.0/16")), "site-a")},
+			want:  []string{},
+		},
+		{
+			name:  "group rule does not shadow a rule of an unregistered node",
+			rule:  onNodes(rule(1, 10, subnet("10.0.0.0/24")), "edge-9"),
+			rules: []models.Rule{inGroups(rule(2, 20, subnet("10.0.0.0/16")), "site-a")},
+			want:  []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := summarize(Check(test.rule, test.rules, registeredNodes))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Check() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []models.Rule
+		want  []string
+	}{
+		{
+			name: "no conflicts",
+			rules: []models.Rule{
+				rule(1, 10, subnet("10.0.0.0/24")),
+				rule(2, 10, subnet("10.0.1.0/24")),
+			},
+			want: []string{},
+		},
+		{
+			name: "equal priority pairs are reported once",
+			rules: []models.Rule{
+				rule(1, 10, subnet("10.0.0.0/16")),
+				rule(2, 10, subnet("10.0.1.0/24")),
+				rule(3, 10, ip("10.0.1.1")),
+			},
+			want: []string{"equal_priority 1 [2 3]", "equal_priority 2 [3]"},
+		},
+		{
+			name: "shadowed rules",
+			rules: []models.Rule{
+				rule(1, 30, subnet("10.0.0.0/25")),
+				rule(2, 30, subnet("10.0.0.128/25")),
+				rule(3, 10, subnet("10.0.0.0/24")),
+				rule(4, 10, subnet("10.0.1.0/24")),
+			},
+			want: []string{"shadowed 3 [1 2]"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := summarize(Find(test.rules, registeredNodes))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Find() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package conntrack
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/ti-mo/conntrack"
+)
+
+// DeleteFlowsTo removes all conntrack entries of connections that were
+// DNATed to the given backend address and returns the number of removed
+// entries. After DNAT the backend is the source of the reply direction.
+func DeleteFlowsTo(ip string, port int) (int, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return 0, fmt.Errorf("invalid IP address %s: %v", ip, err)
+	}
+	addr = addr.Unmap()
+
+	conn, err := conntrack.Dial(nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open conntrack connection: %v", err)
+	}
+	defer conn.Close()
+
+	flows, err := conn.Dump(nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to dump conntrack table: %v", err)
+	}
+
+	deleted := 0
+	for _, flow := range flows {
+		if !flow.Status.DstNAT() || flow.TupleReply.IP.SourceAddress != addr || flow.TupleReply.Proto.SourcePort != uint16(port) {
+			continue
+		}
+		if err := conn.Delete(flow); err != nil {
+			return deleted, fmt.Errorf("failed to delete conntrack entry: %v", err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,115 @@
+package conntrack
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/netfilter"
+	"golang.org/x/sys/unix"
+)
+
+// eventReadBuffer is the size of the netlink receive buffer for conntrack
+// events. Events are lost if the buffer overflows.
+const eventReadBuffer = 4 << 20
+
+// TCP conntrack states, see nf_conntrack_tcp.h
+const (
+	tcpStateEstablished = 3
+	tcpStateClose       = 8
+)
+
+// FlowEventType is the kind of change of a connection
+type FlowEventType int
+
+// Connection changes reported by WatchFlows
+const (
+	FlowNew FlowEventType = iota
+	FlowUpdate
+	FlowDestroy
+)
+
+// FlowEvent describes a change of a DNATed TCP connection
+type FlowEvent struct {
+	Type FlowEventType
+	// ID of the conntrack entry
+	ID uint32
+	// Backend is the address the connection was DNATed to
+	Backend netip.AddrPort
+	// Established is set once the TCP handshake has completed
+	Established bool
+	// Reset is set if the connection was closed by a TCP reset
+	Reset bool
+}
+
+// WatchFlows reports changes of DNATed TCP connections to handle until
+// stop is closed. handle is called from a single goroutine and must not
+// block. It returns nil when stopped and an error if the events could not
+// be received.
+func WatchFlows(stop <-chan struct{}, handle func(FlowEvent)) error {
+	conn, err := conntrack.Dial(nil)
+	if err != nil {
+		return fmt.Errorf("failed to open conntrack connection: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetReadBuffer(eventReadBuffer); err != nil {
+		return fmt.Errorf("failed to set conntrack read buffer: %v", err)
+	}
+
+	events := make(chan conntrack.Event, 1024)
+	errs, err := conn.Listen(events, 1, netfilter.GroupsCT)
+	if err != nil {
+		return fmt.Errorf("failed to listen for conntrack events: %v", err)
+	}
+
+	for {
+		select {
+		case event := <-events:
+			if flowEvent, ok := toFlowEvent(event); ok {
+				handle(flowEvent)
+			}
+		case err := <-errs:
+			return fmt.Errorf("failed to receive conntrack events: %v", err)
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// toFlowEvent converts a conntrack event of a DNATed TCP connection
+func toFlowEvent(event conntrack.Event) (FlowEvent, bool) {
+	flow := event.Flow
+	if flow == nil || !flow.Status.DstNAT() || flow.TupleOrig.Proto.Protocol != unix.IPPROTO_TCP {
+		return FlowEvent{}, false
+	}
+
+	flowEvent := FlowEvent{
+		ID:          flow.ID,
+		Backend:     netip.AddrPortFrom(flow.TupleReply.IP.SourceAddress.Unmap(), flow.TupleReply.Proto.SourcePort),
+		Established: flow.Status.Assured(),
+	}
+
+	switch event.Type {
+	case conntrack.EventNew:
+		flowEvent.Type = FlowNew
+	case conntrack.EventUpdate:
+		flowEvent.Type = FlowUpdate
+	case conntrack.EventDestroy:
+		flowEvent.Type = FlowDestroy
+	default:
+		return FlowEvent{}, false
+	}
+
+	// Protocol info is only included if the TCP state changed
+	if tcp := flow.ProtoInfo.TCP; tcp != nil {
+		switch tcp.State {
+		case tcpStateEstablished:
+			flowEvent.Established = true
+		case tcpStateClose:
+			flowEvent.Reset = true
+		}
+	}
+
+	return flowEvent, true
+}
@@ -1,28 +1,54 @@
 package database
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"reflect"
 	"sync"
 	"time"
 
+	"github.com/sven-borkert/b2b-ingress-manager/internal/conflict"
 	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
 
+	"github.com/glebarez/sqlite"
 	"github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	gormlogger "gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
 )
 
+// Database drivers
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// ErrReadOnly is returned by stores for changes of entities they do not
+// allow to be changed
+var ErrReadOnly = errors.New("the routing configuration is read-only")
+
+// ErrWindowEntityDeleted is returned when a maintenance window is completed
+// because its backend or address no longer exists
+var ErrWindowEntityDeleted = errors.New("the entity of the maintenance window was deleted")
+
 // Config holds database connection configuration
 type Config struct {
+	// Driver is postgres or sqlite, postgres if empty
+	Driver   string
 	Host     string
 	Port     int
 	User     string
 	Password string
 	DBName   string
 	SSLMode  string
+	// Path of the SQLite database file, :memory: keeps it in memory
+	Path string
 }
 
 // Service provides database operations
@@ -30,12 +56,20 @@ type Service struct {
 	db     *gorm.DB
 	logger *logrus.Logger
 	mu     sync.RWMutex
+
+	// dsn of the PostgreSQL database listened to for configuration
+	// changes, empty for other drivers which notify within this process
+	dsn   string
+	local *notifier
 }
 
 // NewService creates a new database service
 func NewService(config Config, logger *logrus.Logger) (*Service, error) {
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		config.Host, config.Port, config.User, config.Password, config.DBName, config.SSLMode)
+	dsn := postgresDSN(config)
+	dialector, err := openDialector(config, dsn)
+	if err != nil {
+		return nil, err
+	}
 
 	// Set up a simple logger for GORM that outputs to stdout
 	newLogger := gormlogger.New(
@@ -48,7 +82,7 @@ func NewService(config Config, logger *logrus.Logger) (*Service, error) {
 		},
 	)
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+	db, err := gorm.Open(dialector, &gorm.Config{
 		Logger: newLogger,
 	})
 	if err != nil {
@@ -60,14 +94,36 @@ func NewService(config Config, logger *logrus.Logger) (*Service, error) {
 		return nil, err
 	}
 
-	// Set connection pool settings
-	sqlDB.SetMaxIdleConns(10)
-	sqlDB.SetMaxOpenConns(100)
-	sqlDB.SetConnMaxLifetime(time.Hour)
+	// Set connection pool settings. SQLite allows a single writer, so
+	// transactions are serialized on one connection, which also keeps an
+	// in-memory database alive.
+	if config.Driver == DriverSQLite {
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+	} else {
+		sqlDB.SetMaxIdleConns(10)
+		sqlDB.SetMaxOpenConns(100)
+		sqlDB.SetConnMaxLifetime(time.Hour)
+	}
+
+	if err := registerQueryMetrics(db); err != nil {
+		return nil, err
+	}
+	if err := registerRevisions(db); err != nil {
+		return nil, err
+	}
+	local := newNotifier()
+	if err := registerNotifications(db, local); err != nil {
+		return nil, err
+	}
 
 	service := &Service{
 		db:     db,
 		logger: logger,
+		local:  local,
+	}
+	if config.Driver != DriverSQLite {
+		service.dsn = dsn
 	}
 
 	// Create tables if they don't exist
@@ -78,10 +134,46 @@ func NewService(config Config, logger *logrus.Logger) (*Service, error) {
 	return service, nil
 }
 
+// postgresDSN returns the connection string of a PostgreSQL database
+func postgresDSN(config Config) string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		config.Host, config.Port, config.User, config.Password, config.DBName, config.SSLMode)
+}
+
+// openDialector returns the dialector of the configured driver
+func openDialector(config Config, dsn string) (gorm.Dialector, error) {
+	switch config.Driver {
+	case "", DriverPostgres:
+		return postgres.Open(dsn), nil
+	case DriverSQLite:
+		if config.Path == "" {
+			return nil, errors.New("the SQLite driver requires a path")
+		}
+		// Enforce foreign keys like PostgreSQL does
+		return sqlite.Open(config.Path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"), nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %s", config.Driver)
+	}
+}
+
+// Ping checks the connection to the database
+func (s *Service) Ping(ctx context.Context) error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// DB returns the connection pool of the database
+func (s *Service) DB() (*sql.DB, error) {
+	return s.db.DB()
+}
+
 // migrateSchema creates database tables if they don't exist
 func (s *Service) migrateSchema() error {
 	// Using GORM AutoMigrate to create or update tables based on struct models
-	return s.db.AutoMigrate(
+	if err := s.db.AutoMigrate(
 		&models.Backend{},
 		&models.Address{},
 		&models.BackendSet{},
@@ -89,7 +181,47 @@ func (s *Service) migrateSchema() error {
 		&models.Rule{},
 		&models.ConfigChange{},
 		&models.AvailabilityLog{},
-	)
+		&models.HealthCheckSample{},
+		&models.MaintenanceWindow{},
+		&models.APIToken{},
+		&models.RoleAssignment{},
+		&models.Revision{},
+		&models.IngressNode{},
+		&models.NodeAvailability{},
+	); err != nil {
+		return err
+	}
+
+	if err := s.refreshCheckConstraints(); err != nil {
+		return err
+	}
+
+	return s.recordBaseline()
+}
+
+// refreshCheckConstraints recreates check constraints whose allowed values
+// have been extended, since AutoMigrate does not update existing constraints
+func (s *Service) refreshCheckConstraints() error {
+	constraints := []struct {
+		model interface{}
+		name  string
+	}{
+		{&models.ConfigChange{}, "chk_config_changes_entity_type"},
+	}
+
+	migrator := s.db.Migrator()
+	for _, constraint := range constraints {
+		if migrator.HasConstraint(constraint.model, constraint.name) {
+			if err := migrator.DropConstraint(constraint.model, constraint.name); err != nil {
+				return fmt.Errorf("failed to drop constraint %s: %v", constraint.name, err)
+			}
+		}
+		if err := migrator.CreateConstraint(constraint.model, constraint.name); err != nil {
+			return fmt.Errorf("failed to create constraint %s: %v", constraint.name, err)
+		}
+	}
+
+	return nil
 }
 
 // LogConfigChange records a configuration change to the database
@@ -133,6 +265,60 @@ func (s *Service) LogAvailabilityChange(addressID uint, available bool, checkErr
 	return s.db.Create(&log).Error
 }
 
+// RecordHealthCheckSamples stores aggregated health check samples
+func (s *Service) RecordHealthCheckSamples(samples []models.HealthCheckSample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.db.Create(&samples).Error
+}
+
+// GetHealthCheckSamples retrieves the health check samples of the given
+// addresses whose sampling period overlaps the time range
+func (s *Service) GetHealthCheckSamples(addressIDs []uint, from, to time.Time) ([]models.HealthCheckSample, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var samples []models.HealthCheckSample
+	if len(addressIDs) == 0 {
+		return samples, nil
+	}
+
+	err := s.db.Where("address_id IN ? AND period_end > ? AND period_start < ?", addressIDs, from, to).
+		Order("period_start").
+		Find(&samples).Error
+	return samples, err
+}
+
+// GetAvailabilityHistory retrieves the cluster-wide availability logs of the
+// given addresses within the time range, preceded by the last log of each
+// address before the range so that the initial state is known. Logs are
+// ordered by check time.
+func (s *Service) GetAvailabilityHistory(addressIDs []uint, from, to time.Time) ([]models.AvailabilityLog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var logs []models.AvailabilityLog
+	if len(addressIDs) == 0 {
+		return logs, nil
+	}
+
+	previous := s.db.Model(&models.AvailabilityLog{}).
+		Select("MAX(id)").
+		Where("node_id = 0 AND address_id IN ? AND check_time < ?", addressIDs, from).
+		Group("address_id")
+
+	err := s.db.Where(s.db.Where("node_id = 0 AND address_id IN ? AND check_time >= ? AND check_time < ?", addressIDs, from, to).
+		Or("id IN (?)", previous)).
+		Order("check_time, id").
+		Find(&logs).Error
+	return logs, err
+}
+
 // GetAllBackends retrieves all backends from the database
 func (s *Service) GetAllBackends() ([]models.Backend, error) {
 	s.mu.RLock()
@@ -161,12 +347,18 @@ func (s *Service) CreateBackend(backend *models.Backend, changedBy string) error
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	tx := s.db.Begin()
+	tx := s.begin()
 	if err := tx.Create(backend).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
+	after, err := snapshot(tx, &models.Backend{}, backend.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// Log the change
 	if err := tx.Create(&models.ConfigChange{
 		ChangeType:  "create",
@@ -174,12 +366,13 @@ func (s *Service) CreateBackend(backend *models.Backend, changedBy string) error
 		EntityID:    backend.ID,
 		Description: fmt.Sprintf("Created backend %s", backend.Name),
 		ChangedBy:   changedBy,
+		After:       after,
 	}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	return tx.Commit().Error
+	return s.commit(tx)
 }
 
 // UpdateBackend updates an existing backend
@@ -187,12 +380,24 @@ func (s *Service) UpdateBackend(backend *models.Backend, changedBy string) error
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	tx := s.db.Begin()
+	tx := s.begin()
+	before, err := snapshot(tx, &models.Backend{}, backend.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := tx.Save(backend).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
+	after, err := snapshot(tx, &models.Backend{}, backend.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// Log the change
 	if err := tx.Create(&models.ConfigChange{
 		ChangeType:  "update",
@@ -200,12 +405,14 @@ func (s *Service) UpdateBackend(backend *models.Backend, changedBy string) error
 		EntityID:    backend.ID,
 		Description: fmt.Sprintf("Updated backend %s", backend.Name),
 		ChangedBy:   changedBy,
+		Before:      before,
+		After:       after,
 	}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	return tx.Commit().Error
+	return s.commit(tx)
 }
 
 // DeleteBackend deletes a backend by ID
@@ -218,25 +425,96 @@ func (s *Service) DeleteBackend(id uint, changedBy string) error {
 		return err
 	}
 
-	tx := s.db.Begin()
+	tx := s.begin()
+	before, err := snapshot(tx, &models.Backend{}, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// First remove the backend from any backend sets, logging the changed
+	// memberships
+	var backendSetIDs []uint
+	if err := tx.Table("backend_set_backends").Where("backend_id = ?", id).Pluck("backend_set_id", &backendSetIDs).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	setsBefore := make([]models.Snapshot, len(backendSetIDs))
+	for i, backendSetID := range backendSetIDs {
+		if setsBefore[i], err = snapshot(tx, &models.BackendSet{}, backendSetID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 
-	// First remove the backend from any backend sets
 	if err := tx.Exec("DELETE FROM backend_set_backends WHERE backend_id = ?", id).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	// Delete associated addresses
-	if err := tx.Delete(&models.Address{}, "backend_id = ?", id).Error; err != nil {
+	for i, backendSetID := range backendSetIDs {
+		setAfter, err := snapshot(tx, &models.BackendSet{}, backendSetID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Create(&models.ConfigChange{
+			ChangeType:  "update",
+			EntityType:  "backend_set",
+			EntityID:    backendSetID,
+			Description: fmt.Sprintf("Removed deleted backend %s from backend set ID %d", backend.Name, backendSetID),
+			ChangedBy:   changedBy,
+			Before:      setsBefore[i],
+			After:       setAfter,
+		}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// Delete associated addresses, each with its own change so that they
+	// can be reconstructed
+	var addresses []models.Address
+	if err := tx.Where("backend_id = ?", id).Find(&addresses).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
+	for _, address := range addresses {
+		addressBefore, err := snapshot(tx, &models.Address{}, address.ID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Delete(&address).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := endEntityWindows(tx, "address", address.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Create(&models.ConfigChange{
+			ChangeType:  "delete",
+			EntityType:  "address",
+			EntityID:    address.ID,
+			Description: fmt.Sprintf("Deleted address %s:%d with backend %s", address.IP, address.Port, backend.Name),
+			ChangedBy:   changedBy,
+			Before:      addressBefore,
+		}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 
 	// Delete the backend
 	if err := tx.Delete(&backend).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
+	if err := endEntityWindows(tx, "backend", id); err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	// Log the change
 	if err := tx.Create(&models.ConfigChange{
@@ -245,12 +523,13 @@ func (s *Service) DeleteBackend(id uint, changedBy string) error {
 		EntityID:    id,
 		Description: fmt.Sprintf("Deleted backend %s", backend.Name),
 		ChangedBy:   changedBy,
+		Before:      before,
 	}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	return tx.Commit().Error
+	return s.commit(tx)
 }
 
 // GetAllRules retrieves all rules with their related entities
@@ -277,8 +556,70 @@ func (s *Service) GetActiveRules() ([]models.Rule, error) {
 	return rules, err
 }
 
-// GetAvailableBackendAddresses gets all available addresses for a given backend set
-func (s *Service) GetAvailableBackendAddresses(backendSetID uint) ([]models.Address, error) {
+// GetAvailableBackendAddresses gets all available addresses for a given
+// backend set. With a node ID, the availability seen by the node is used
+// for the addresses it has checked.
+func (s *Service) GetAvailableBackendAddresses(backendSetID, nodeID uint) ([]models.Address, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var addresses []models.Address
+	err := s.db.Raw(`
+		SELECT a.* FROM addresses a
+		JOIN backends b ON a.backend_id = b.id
+		JOIN backend_set_backends bsb ON b.id = bsb.backend_id
+		LEFT JOIN node_availabilities na ON na.address_id = a.id AND na.node_id = ?
+		WHERE bsb.backend_set_id = ? AND COALESCE(na.available, a.available) = true
+			AND a.admin_state = 'enabled' AND b.admin_state = 'enabled'
+			AND a.deleted_at IS NULL AND b.deleted_at IS NULL
+	`, nodeID, backendSetID).Scan(&addresses).Error
+
+	return addresses, err
+}
+
+// GetAvailableAddressesOfBackends gets all available addresses of the given
+// backends, using the same conditions as GetAvailableBackendAddresses
+func (s *Service) GetAvailableAddressesOfBackends(backendIDs []uint, nodeID uint) ([]models.Address, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var addresses []models.Address
+	if len(backendIDs) == 0 {
+		return addresses, nil
+	}
+
+	err := s.db.Raw(`
+		SELECT a.* FROM addresses a
+		JOIN backends b ON a.backend_id = b.id
+		LEFT JOIN node_availabilities na ON na.address_id = a.id AND na.node_id = ?
+		WHERE b.id IN ? AND COALESCE(na.available, a.available) = true
+			AND a.admin_state = 'enabled' AND b.admin_state = 'enabled'
+			AND a.deleted_at IS NULL AND b.deleted_at IS NULL
+	`, nodeID, backendIDs).Scan(&addresses).Error
+
+	return addresses, err
+}
+
+// GetMaintenanceAddresses gets all addresses that are in maintenance, either
+// directly or through their backend
+func (s *Service) GetMaintenanceAddresses() ([]models.Address, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var addresses []models.Address
+	err := s.db.Raw(`
+		SELECT a.* FROM addresses a
+		JOIN backends b ON a.backend_id = b.id
+		WHERE (a.admin_state = 'maintenance' OR b.admin_state = 'maintenance')
+			AND a.deleted_at IS NULL AND b.deleted_at IS NULL
+	`).Scan(&addresses).Error
+
+	return addresses, err
+}
+
+// GetBackendSetAddresses gets all addresses of a backend set regardless of
+// their availability
+func (s *Service) GetBackendSetAddresses(backendSetID uint) ([]models.Address, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -287,7 +628,7 @@ func (s *Service) GetAvailableBackendAddresses(backendSetID uint) ([]models.Addr
 		SELECT a.* FROM addresses a
 		JOIN backends b ON a.backend_id = b.id
 		JOIN backend_set_backends bsb ON b.id = bsb.backend_id
-		WHERE bsb.backend_set_id = ? AND a.available = true
+		WHERE bsb.backend_set_id = ? AND a.deleted_at IS NULL AND b.deleted_at IS NULL
 	`, backendSetID).Scan(&addresses).Error
 
 	return addresses, err
@@ -301,12 +642,18 @@ func (s *Service) CreateAddress(backendID uint, address *models.Address, changed
 	// Set backend ID
 	address.BackendID = backendID
 
-	tx := s.db.Begin()
+	tx := s.begin()
 	if err := tx.Create(address).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
+	after, err := snapshot(tx, &models.Address{}, address.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// Log the change
 	if err := tx.Create(&models.ConfigChange{
 		ChangeType:  "create",
@@ -314,12 +661,23 @@ func (s *Service) CreateAddress(backendID uint, address *models.Address, changed
 		EntityID:    address.ID,
 		Description: fmt.Sprintf("Added address %s:%d to backend ID %d", address.IP, address.Port, backendID),
 		ChangedBy:   changedBy,
+		After:       after,
 	}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	return tx.Commit().Error
+	return s.commit(tx)
+}
+
+// GetAllAddresses retrieves all addresses from the database
+func (s *Service) GetAllAddresses() ([]models.Address, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var addresses []models.Address
+	err := s.db.Find(&addresses).Error
+	return addresses, err
 }
 
 // GetAddress retrieves an address by ID
@@ -340,12 +698,24 @@ func (s *Service) UpdateAddress(address *models.Address, changedBy string) error
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	tx := s.db.Begin()
+	tx := s.begin()
+	before, err := snapshot(tx, &models.Address{}, address.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := tx.Save(address).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
+	after, err := snapshot(tx, &models.Address{}, address.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// Log the change
 	if err := tx.Create(&models.ConfigChange{
 		ChangeType:  "update",
@@ -353,12 +723,14 @@ func (s *Service) UpdateAddress(address *models.Address, changedBy string) error
 		EntityID:    address.ID,
 		Description: fmt.Sprintf("Updated address %s:%d", address.IP, address.Port),
 		ChangedBy:   changedBy,
+		Before:      before,
+		After:       after,
 	}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	return tx.Commit().Error
+	return s.commit(tx)
 }
 
 // DeleteAddress deletes an address by ID
@@ -371,11 +743,21 @@ func (s *Service) DeleteAddress(id uint, changedBy string) error {
 		return err
 	}
 
-	tx := s.db.Begin()
+	tx := s.begin()
+	before, err := snapshot(tx, &models.Address{}, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := tx.Delete(&address).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
+	if err := endEntityWindows(tx, "address", id); err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	// Log the change
 	if err := tx.Create(&models.ConfigChange{
@@ -384,12 +766,13 @@ func (s *Service) DeleteAddress(id uint, changedBy string) error {
 		EntityID:    id,
 		Description: fmt.Sprintf("Deleted address %s:%d", address.IP, address.Port),
 		ChangedBy:   changedBy,
+		Before:      before,
 	}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	return tx.Commit().Error
+	return s.commit(tx)
 }
 
 // GetAllBackendSets retrieves all backend sets from the database
@@ -420,7 +803,7 @@ func (s *Service) CreateBackendSet(backendSet *models.BackendSet, changedBy stri
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	tx := s.db.Begin()
+	tx := s.begin()
 
 	// First create the backend set
 	if err := tx.Create(backendSet).Error; err != nil {
@@ -436,6 +819,12 @@ func (s *Service) CreateBackendSet(backendSet *models.BackendSet, changedBy stri
 		}
 	}
 
+	after, err := snapshot(tx, &models.BackendSet{}, backendSet.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// Log the change
 	if err := tx.Create(&models.ConfigChange{
 		ChangeType:  "create",
@@ -443,12 +832,13 @@ func (s *Service) CreateBackendSet(backendSet *models.BackendSet, changedBy stri
 		EntityID:    backendSet.ID,
 		Description: fmt.Sprintf("Created backend set %s", backendSet.Name),
 		ChangedBy:   changedBy,
+		After:       after,
 	}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	return tx.Commit().Error
+	return s.commit(tx)
 }
 
 // UpdateBackendSet updates an existing backend set
@@ -456,7 +846,12 @@ func (s *Service) UpdateBackendSet(backendSet *models.BackendSet, changedBy stri
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	tx := s.db.Begin()
+	tx := s.begin()
+	before, err := snapshot(tx, &models.BackendSet{}, backendSet.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	// Clear existing backends associations and re-add them
 	if err := tx.Model(backendSet).Association("Backends").Clear(); err != nil {
@@ -469,6 +864,12 @@ func (s *Service) UpdateBackendSet(backendSet *models.BackendSet, changedBy stri
 		return err
 	}
 
+	after, err := snapshot(tx, &models.BackendSet{}, backendSet.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// Log the change
 	if err := tx.Create(&models.ConfigChange{
 		ChangeType:  "update",
@@ -476,12 +877,14 @@ func (s *Service) UpdateBackendSet(backendSet *models.BackendSet, changedBy stri
 		EntityID:    backendSet.ID,
 		Description: fmt.Sprintf("Updated backend set %s", backendSet.Name),
 		ChangedBy:   changedBy,
+		Before:      before,
+		After:       after,
 	}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	return tx.Commit().Error
+	return s.commit(tx)
 }
 
 // DeleteBackendSet deletes a backend set by ID
@@ -503,7 +906,12 @@ func (s *Service) DeleteBackendSet(id uint, changedBy string) error {
 		return fmt.Errorf("cannot delete backend set: it is used by %d rules", count)
 	}
 
-	tx := s.db.Begin()
+	tx := s.begin()
+	before, err := snapshot(tx, &models.BackendSet{}, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	// Remove associations with backends
 	if err := tx.Model(&backendSet).Association("Backends").Clear(); err != nil {
@@ -524,12 +932,13 @@ func (s *Service) DeleteBackendSet(id uint, changedBy string) error {
 		EntityID:    id,
 		Description: fmt.Sprintf("Deleted backend set %s", backendSet.Name),
 		ChangedBy:   changedBy,
+		Before:      before,
 	}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	return tx.Commit().Error
+	return s.commit(tx)
 }
 
 // GetAllSourceDefinitions retrieves all source definitions from the database
@@ -565,12 +974,18 @@ func (s *Service) CreateSourceDefinition(sourceDefinition *models.SourceDefiniti
 		return fmt.Errorf("invalid source definition parameters")
 	}
 
-	tx := s.db.Begin()
+	tx := s.begin()
 	if err := tx.Create(sourceDefinition).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
+	after, err := snapshot(tx, &models.SourceDefinition{}, sourceDefinition.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// Log the change
 	if err := tx.Create(&models.ConfigChange{
 		ChangeType:  "create",
@@ -578,15 +993,18 @@ func (s *Service) CreateSourceDefinition(sourceDefinition *models.SourceDefiniti
 		EntityID:    sourceDefinition.ID,
 		Description: fmt.Sprintf("Created source definition %s", sourceDefinition.Name),
 		ChangedBy:   changedBy,
+		After:       after,
 	}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	return tx.Commit().Error
+	return s.commit(tx)
 }
 
-// UpdateSourceDefinition updates an existing source definition
+// UpdateSourceDefinition updates an existing source definition. Updates
+// that make one of its enabled rules overlap a rule with the same priority
+// are rejected with a *conflict.Error.
 func (s *Service) UpdateSourceDefinition(sourceDefinition *models.SourceDefinition, changedBy string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -596,12 +1014,30 @@ func (s *Service) UpdateSourceDefinition(sourceDefinition *models.SourceDefiniti
 		return fmt.Errorf("invalid source definition parameters")
 	}
 
-	tx := s.db.Begin()
+	tx := s.begin()
+	before, err := snapshot(tx, &models.SourceDefinition{}, sourceDefinition.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := tx.Save(sourceDefinition).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
+	// The rules of the source match other traffic now
+	if err := sourceRuleConflicts(tx, sourceDefinition.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	after, err := snapshot(tx, &models.SourceDefinition{}, sourceDefinition.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// Log the change
 	if err := tx.Create(&models.ConfigChange{
 		ChangeType:  "update",
@@ -609,12 +1045,14 @@ func (s *Service) UpdateSourceDefinition(sourceDefinition *models.SourceDefiniti
 		EntityID:    sourceDefinition.ID,
 		Description: fmt.Sprintf("Updated source definition %s", sourceDefinition.Name),
 		ChangedBy:   changedBy,
+		Before:      before,
+		After:       after,
 	}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	return tx.Commit().Error
+	return s.commit(tx)
 }
 
 // DeleteSourceDefinition deletes a source definition by ID
@@ -636,7 +1074,13 @@ func (s *Service) DeleteSourceDefinition(id uint, changedBy string) error {
 		return fmt.Errorf("cannot delete source definition: it is used by %d rules", count)
 	}
 
-	tx := s.db.Begin()
+	tx := s.begin()
+	before, err := snapshot(tx, &models.SourceDefinition{}, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := tx.Delete(&sourceDefinition).Error; err != nil {
 		tx.Rollback()
 		return err
@@ -649,12 +1093,13 @@ func (s *Service) DeleteSourceDefinition(id uint, changedBy string) error {
 		EntityID:    id,
 		Description: fmt.Sprintf("Deleted source definition %s", sourceDefinition.Name),
 		ChangedBy:   changedBy,
+		Before:      before,
 	}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	return tx.Commit().Error
+	return s.commit(tx)
 }
 
 // GetRule retrieves a rule by ID
@@ -671,14 +1116,26 @@ func (s *Service) GetRule(id uint) (*models.Rule, error) {
 }
 
 // CreateRule creates a new rule
-func (s *Service) CreateRule(rule *models.Rule, changedBy string) error {
+func (s *Service) CreateRule(rule *models.Rule, changedBy string) ([]conflict.Conflict, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	tx := s.db.Begin()
+	tx := s.begin()
 	if err := tx.Create(rule).Error; err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
+	}
+
+	conflicts, err := ruleConflicts(tx, rule)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	after, err := snapshot(tx, &models.Rule{}, rule.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
 	}
 
 	// Log the change
@@ -688,38 +1145,126 @@ func (s *Service) CreateRule(rule *models.Rule, changedBy string) error {
 		EntityID:    rule.ID,
 		Description: fmt.Sprintf("Created rule with priority %d", rule.Priority),
 		ChangedBy:   changedBy,
+		After:       after,
 	}).Error; err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
 	}
 
-	return tx.Commit().Error
+	return conflicts, s.commit(tx)
 }
 
 // UpdateRule updates an existing rule
-func (s *Service) UpdateRule(rule *models.Rule, changedBy string) error {
+func (s *Service) UpdateRule(rule *models.Rule, changedBy string) ([]conflict.Conflict, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	tx := s.db.Begin()
+	tx := s.begin()
+	before, err := snapshot(tx, &models.Rule{}, rule.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	if err := tx.Save(rule).Error; err != nil {
 		tx.Rollback()
-		return err
+		return nil, err
 	}
 
-	// Log the change
-	if err := tx.Create(&models.ConfigChange{
-		ChangeType:  "update",
-		EntityType:  "rule",
+	conflicts, err := ruleConflicts(tx, rule)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	after, err := snapshot(tx, &models.Rule{}, rule.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Log the change
+	if err := tx.Create(&models.ConfigChange{
+		ChangeType:  "update",
+		EntityType:  "rule",
 		EntityID:    rule.ID,
 		Description: fmt.Sprintf("Updated rule with priority %d", rule.Priority),
 		ChangedBy:   changedBy,
+		Before:      before,
+		After:       after,
 	}).Error; err != nil {
 		tx.Rollback()
+		return nil, err
+	}
+
+	return conflicts, s.commit(tx)
+}
+
+// ruleConflicts checks a saved rule for conflicts with the other enabled
+// rules. Overlapping rules with the same priority are rejected with a
+// *conflict.Error, other conflicts are returned as warnings.
+func ruleConflicts(tx *gorm.DB, rule *models.Rule) ([]conflict.Conflict, error) {
+	if !rule.Enabled {
+		return nil, nil
+	}
+
+	candidate := *rule
+	if err := tx.First(&candidate.SourceDefinition, rule.SourceDefinitionID).Error; err != nil {
+		return nil, err
+	}
+
+	var rules []models.Rule
+	if err := tx.Preload("SourceDefinition").
+		Where("enabled = ? AND destination_port = ? AND id <> ?", true, rule.DestinationPort, rule.ID).
+		Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	var nodes []models.IngressNode
+	if err := tx.Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+
+	var rejected []conflict.Conflict
+	conflicts := conflict.Check(candidate, rules, nodes)
+	for _, c := range conflicts {
+		if c.Kind == conflict.EqualPriority {
+			rejected = append(rejected, c)
+		}
+	}
+	if len(rejected) > 0 {
+		return nil, &conflict.Error{Conflicts: rejected}
+	}
+
+	return conflicts, nil
+}
+
+// sourceRuleConflicts checks the enabled rules of a source definition for
+// overlaps with rules of the same priority, which are rejected with a
+// *conflict.Error. Other conflicts are not reported.
+func sourceRuleConflicts(tx *gorm.DB, sourceDefinitionID uint) error {
+	var rules []models.Rule
+	if err := tx.Where("source_definition_id = ? AND enabled = ?", sourceDefinitionID, true).
+		Order("id").Find(&rules).Error; err != nil {
 		return err
 	}
 
-	return tx.Commit().Error
+	var rejected []conflict.Conflict
+	for i := range rules {
+		_, err := ruleConflicts(tx, &rules[i])
+		var conflictErr *conflict.Error
+		if errors.As(err, &conflictErr) {
+			rejected = append(rejected, conflictErr.Conflicts...)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(rejected) > 0 {
+		return &conflict.Error{Conflicts: rejected}
+	}
+
+	return nil
 }
 
 // DeleteRule deletes a rule by ID
@@ -732,7 +1277,13 @@ func (s *Service) DeleteRule(id uint, changedBy string) error {
 		return err
 	}
 
-	tx := s.db.Begin()
+	tx := s.begin()
+	before, err := snapshot(tx, &models.Rule{}, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := tx.Delete(&rule).Error; err != nil {
 		tx.Rollback()
 		return err
@@ -745,24 +1296,712 @@ func (s *Service) DeleteRule(id uint, changedBy string) error {
 		EntityID:    id,
 		Description: fmt.Sprintf("Deleted rule with priority %d", rule.Priority),
 		ChangedBy:   changedBy,
+		Before:      before,
 	}).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	return tx.Commit().Error
+	return s.commit(tx)
+}
+
+// SetBackendAdminState changes the administrative state of a backend
+func (s *Service) SetBackendAdminState(id uint, state, changedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var backend models.Backend
+	if err := s.db.First(&backend, id).Error; err != nil {
+		return err
+	}
+
+	tx := s.begin()
+	if err := setAdminState(tx, &backend, "backend", backend.ID, backend.Name, backend.AdminState, state, changedBy); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return s.commit(tx)
+}
+
+// SetAddressAdminState changes the administrative state of an address
+func (s *Service) SetAddressAdminState(id uint, state, changedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var address models.Address
+	if err := s.db.First(&address, id).Error; err != nil {
+		return err
+	}
+
+	tx := s.begin()
+	name := fmt.Sprintf("%s:%d", address.IP, address.Port)
+	if err := setAdminState(tx, &address, "address", address.ID, name, address.AdminState, state, changedBy); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return s.commit(tx)
+}
+
+// setAdminState updates the admin_state column of a backend or address and
+// logs the change within the given transaction
+func setAdminState(tx *gorm.DB, model interface{}, entityType string, id uint, name, from, to, changedBy string) error {
+	if !models.ValidAdminState(to) {
+		return fmt.Errorf("invalid admin state: %s", to)
+	}
+
+	before, err := snapshot(tx, model, id)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Model(model).Update("admin_state", to).Error; err != nil {
+		return err
+	}
+
+	after, err := snapshot(tx, model, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Create(&models.ConfigChange{
+		ChangeType:  "update",
+		EntityType:  entityType,
+		EntityID:    id,
+		Description: fmt.Sprintf("Changed admin state of %s %s from %s to %s", entityType, name, from, to),
+		ChangedBy:   changedBy,
+		Before:      before,
+		After:       after,
+	}).Error
+}
+
+// snapshot returns the columns of an entity as stored in the transaction
+func snapshot(tx *gorm.DB, model interface{}, id uint) (models.Snapshot, error) {
+	value := reflect.New(reflect.TypeOf(model).Elem())
+	result := tx.First(value.Interface(), id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return encodeSnapshot(tx, result.Statement.Schema, value.Elem())
+}
+
+// encodeSnapshot returns the snapshot of a loaded entity. Backend sets
+// include their backend IDs, token hashes are left out.
+func encodeSnapshot(tx *gorm.DB, entitySchema *schema.Schema, value reflect.Value) (models.Snapshot, error) {
+	row := map[string]interface{}{}
+	for _, field := range entitySchema.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		row[field.DBName] = field.ReflectValueOf(tx.Statement.Context, value).Interface()
+	}
+
+	switch entity := value.Addr().Interface().(type) {
+	case *models.BackendSet:
+		backendIDs := []uint{}
+		if err := tx.Table("backend_set_backends").Where("backend_set_id = ?", entity.ID).Order("backend_id").Pluck("backend_id", &backendIDs).Error; err != nil {
+			return nil, err
+		}
+		row["backend_ids"] = backendIDs
+	case *models.APIToken:
+		delete(row, "token_hash")
+	}
+
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil, err
+	}
+	return models.Snapshot(data), nil
+}
+
+// GetMaintenanceWindows retrieves all maintenance windows
+func (s *Service) GetMaintenanceWindows() ([]models.MaintenanceWindow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var windows []models.MaintenanceWindow
+	err := s.db.Order("starts_at DESC").Find(&windows).Error
+	return windows, err
+}
+
+// GetMaintenanceWindow retrieves a maintenance window by ID
+func (s *Service) GetMaintenanceWindow(id uint) (*models.MaintenanceWindow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var window models.MaintenanceWindow
+	err := s.db.First(&window, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &window, nil
 }
 
-// GetConfigChangeLogs retrieves configuration change logs
-func (s *Service) GetConfigChangeLogs(limit, offset int) ([]models.ConfigChange, error) {
+// GetDueMaintenanceWindows retrieves scheduled windows that have started and
+// active windows that have ended
+func (s *Service) GetDueMaintenanceWindows(now time.Time) ([]models.MaintenanceWindow, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	var windows []models.MaintenanceWindow
+	err := s.db.Where("(status = ? AND starts_at <= ?) OR (status = ? AND ends_at <= ?)",
+		models.MaintenanceScheduled, now, models.MaintenanceActive, now).
+		Order("starts_at").
+		Find(&windows).Error
+	return windows, err
+}
+
+// CreateMaintenanceWindow schedules a new maintenance window
+func (s *Service) CreateMaintenanceWindow(window *models.MaintenanceWindow, changedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	window.Status = models.MaintenanceScheduled
+
+	tx := s.begin()
+	if err := tx.Create(window).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	after, err := snapshot(tx, &models.MaintenanceWindow{}, window.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Log the change
+	if err := tx.Create(&models.ConfigChange{
+		ChangeType:  "create",
+		EntityType:  "maintenance_window",
+		EntityID:    window.ID,
+		Description: fmt.Sprintf("Scheduled %s of %s ID %d from %s to %s", window.AdminState, window.EntityType, window.EntityID, window.StartsAt.Format(time.RFC3339), window.EndsAt.Format(time.RFC3339)),
+		ChangedBy:   changedBy,
+		After:       after,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return s.commit(tx)
+}
+
+// UpdateMaintenanceWindow updates the schedule and description of a
+// maintenance window
+func (s *Service) UpdateMaintenanceWindow(window *models.MaintenanceWindow, changedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := s.begin()
+	before, err := snapshot(tx, &models.MaintenanceWindow{}, window.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Model(window).Updates(map[string]interface{}{
+		"starts_at":   window.StartsAt,
+		"ends_at":     window.EndsAt,
+		"description": window.Description,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	after, err := snapshot(tx, &models.MaintenanceWindow{}, window.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Log the change
+	if err := tx.Create(&models.ConfigChange{
+		ChangeType:  "update",
+		EntityType:  "maintenance_window",
+		EntityID:    window.ID,
+		Description: fmt.Sprintf("Rescheduled maintenance window to %s - %s", window.StartsAt.Format(time.RFC3339), window.EndsAt.Format(time.RFC3339)),
+		ChangedBy:   changedBy,
+		Before:      before,
+		After:       after,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return s.commit(tx)
+}
+
+// DeleteMaintenanceWindow deletes a maintenance window that is not active
+func (s *Service) DeleteMaintenanceWindow(id uint, changedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var window models.MaintenanceWindow
+	if err := s.db.First(&window, id).Error; err != nil {
+		return err
+	}
+	if window.Status == models.MaintenanceActive {
+		return fmt.Errorf("cannot delete maintenance window: it is active")
+	}
+
+	tx := s.begin()
+	before, err := snapshot(tx, &models.MaintenanceWindow{}, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Delete(&window).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Log the change
+	if err := tx.Create(&models.ConfigChange{
+		ChangeType:  "delete",
+		EntityType:  "maintenance_window",
+		EntityID:    id,
+		Description: fmt.Sprintf("Deleted maintenance window for %s ID %d", window.EntityType, window.EntityID),
+		ChangedBy:   changedBy,
+		Before:      before,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return s.commit(tx)
+}
+
+// ActivateMaintenanceWindow applies the state of a maintenance window to its
+// entity and remembers the previous state
+func (s *Service) ActivateMaintenanceWindow(id uint, changedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var window models.MaintenanceWindow
+	if err := s.db.First(&window, id).Error; err != nil {
+		return err
+	}
+
+	tx := s.begin()
+	previous, err := setWindowEntityState(tx, window, window.AdminState, "", changedBy)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return s.completeOrphanedWindow(tx, &window)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Model(&window).Updates(map[string]interface{}{
+		"status":         models.MaintenanceActive,
+		"previous_state": previous,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return s.commit(tx)
+}
+
+// CompleteMaintenanceWindow ends a maintenance window. If it is active, its
+// entity is restored to the state it had before, unless its state was
+// changed in the meantime.
+func (s *Service) CompleteMaintenanceWindow(id uint, changedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var window models.MaintenanceWindow
+	if err := s.db.First(&window, id).Error; err != nil {
+		return err
+	}
+
+	tx := s.begin()
+	if window.Status == models.MaintenanceActive {
+		restore := window.PreviousState
+		if restore == "" {
+			restore = models.AdminStateEnabled
+		}
+		_, err := setWindowEntityState(tx, window, restore, window.AdminState, changedBy)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return s.completeOrphanedWindow(tx, &window)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Model(&window).Update("status", models.MaintenanceCompleted).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return s.commit(tx)
+}
+
+// completeOrphanedWindow completes a window whose entity was deleted and
+// returns ErrWindowEntityDeleted once the transaction is committed
+func (s *Service) completeOrphanedWindow(tx *gorm.DB, window *models.MaintenanceWindow) error {
+	if err := tx.Model(window).Update("status", models.MaintenanceCompleted).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := s.commit(tx); err != nil {
+		return err
+	}
+	return ErrWindowEntityDeleted
+}
+
+// endEntityWindows completes the scheduled and active maintenance windows
+// of a backend or address that is deleted
+func endEntityWindows(tx *gorm.DB, entityType string, entityID uint) error {
+	return tx.Model(&models.MaintenanceWindow{}).
+		Where("entity_type = ? AND entity_id = ? AND status IN ?", entityType, entityID,
+			[]string{models.MaintenanceScheduled, models.MaintenanceActive}).
+		Update("status", models.MaintenanceCompleted).Error
+}
+
+// setWindowEntityState sets the admin state of the backend or address of a
+// maintenance window and returns its previous state. If expected is set,
+// the state is only changed while the entity is still in that state.
+func setWindowEntityState(tx *gorm.DB, window models.MaintenanceWindow, state, expected, changedBy string) (string, error) {
+	switch window.EntityType {
+	case "backend":
+		var backend models.Backend
+		if err := tx.First(&backend, window.EntityID).Error; err != nil {
+			return "", err
+		}
+		previous := backend.AdminState
+		if expected == "" || previous == expected {
+			if err := setAdminState(tx, &backend, "backend", backend.ID, backend.Name, previous, state, changedBy); err != nil {
+				return "", err
+			}
+		}
+		return previous, nil
+	case "address":
+		var address models.Address
+		if err := tx.First(&address, window.EntityID).Error; err != nil {
+			return "", err
+		}
+		previous := address.AdminState
+		if expected == "" || previous == expected {
+			name := fmt.Sprintf("%s:%d", address.IP, address.Port)
+			if err := setAdminState(tx, &address, "address", address.ID, name, previous, state, changedBy); err != nil {
+				return "", err
+			}
+		}
+		return previous, nil
+	default:
+		return "", fmt.Errorf("unsupported maintenance window entity type: %s", window.EntityType)
+	}
+}
+
+// GetAllAPITokens retrieves all API tokens
+func (s *Service) GetAllAPITokens() ([]models.APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tokens []models.APIToken
+	err := s.db.Order("name").Find(&tokens).Error
+	return tokens, err
+}
+
+// GetAPITokenByHash retrieves an API token by the hash of the token
+func (s *Service) GetAPITokenByHash(hash string) (*models.APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var token models.APIToken
+	err := s.db.Where("token_hash = ?", hash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// TouchAPIToken records when an API token was last used
+func (s *Service) TouchAPIToken(id uint, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.db.Model(&models.APIToken{}).Where("id = ?", id).UpdateColumn("last_used_at", usedAt).Error
+}
+
+// CreateAPIToken creates a new API token
+func (s *Service) CreateAPIToken(token *models.APIToken, changedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := s.begin()
+	if err := tx.Create(token).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	after, err := snapshot(tx, &models.APIToken{}, token.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Log the change
+	if err := tx.Create(&models.ConfigChange{
+		ChangeType:  "create",
+		EntityType:  "api_token",
+		EntityID:    token.ID,
+		Description: fmt.Sprintf("Created API token %s", token.Name),
+		ChangedBy:   changedBy,
+		After:       after,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return s.commit(tx)
+}
+
+// DeleteAPIToken revokes an API token by ID
+func (s *Service) DeleteAPIToken(id uint, changedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var token models.APIToken
+	if err := s.db.First(&token, id).Error; err != nil {
+		return err
+	}
+
+	tx := s.begin()
+	before, err := snapshot(tx, &models.APIToken{}, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Delete permanently, so the name can be used again
+	if err := tx.Unscoped().Delete(&token).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Log the change
+	if err := tx.Create(&models.ConfigChange{
+		ChangeType:  "delete",
+		EntityType:  "api_token",
+		EntityID:    id,
+		Description: fmt.Sprintf("Deleted API token %s", token.Name),
+		ChangedBy:   changedBy,
+		Before:      before,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return s.commit(tx)
+}
+
+// GetAllRoleAssignments retrieves all role assignments
+func (s *Service) GetAllRoleAssignments() ([]models.RoleAssignment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var assignments []models.RoleAssignment
+	err := s.db.Order("principal").Find(&assignments).Error
+	return assignments, err
+}
+
+// GetRoleAssignment retrieves a role assignment by ID
+func (s *Service) GetRoleAssignment(id uint) (*models.RoleAssignment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var assignment models.RoleAssignment
+	err := s.db.First(&assignment, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &assignment, nil
+}
+
+// GetRoleAssignmentByPrincipal retrieves the role assignment of a
+// principal. It returns nil if the principal has no role.
+func (s *Service) GetRoleAssignmentByPrincipal(principal string) (*models.RoleAssignment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var assignments []models.RoleAssignment
+	err := s.db.Where("principal = ?", principal).Limit(1).Find(&assignments).Error
+	if err != nil || len(assignments) == 0 {
+		return nil, err
+	}
+	return &assignments[0], nil
+}
+
+// CreateRoleAssignment assigns a role to a principal
+func (s *Service) CreateRoleAssignment(assignment *models.RoleAssignment, changedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := s.begin()
+	if err := tx.Create(assignment).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	after, err := snapshot(tx, &models.RoleAssignment{}, assignment.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Log the change
+	if err := tx.Create(&models.ConfigChange{
+		ChangeType:  "create",
+		EntityType:  "role_assignment",
+		EntityID:    assignment.ID,
+		Description: fmt.Sprintf("Assigned role %s to %s", assignment.Role, assignment.Principal),
+		ChangedBy:   changedBy,
+		After:       after,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return s.commit(tx)
+}
+
+// UpdateRoleAssignment updates a role assignment
+func (s *Service) UpdateRoleAssignment(assignment *models.RoleAssignment, changedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := s.begin()
+	before, err := snapshot(tx, &models.RoleAssignment{}, assignment.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Save(assignment).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	after, err := snapshot(tx, &models.RoleAssignment{}, assignment.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Log the change
+	if err := tx.Create(&models.ConfigChange{
+		ChangeType:  "update",
+		EntityType:  "role_assignment",
+		EntityID:    assignment.ID,
+		Description: fmt.Sprintf("Assigned role %s to %s", assignment.Role, assignment.Principal),
+		ChangedBy:   changedBy,
+		Before:      before,
+		After:       after,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return s.commit(tx)
+}
+
+// DeleteRoleAssignment removes a role assignment by ID
+func (s *Service) DeleteRoleAssignment(id uint, changedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var assignment models.RoleAssignment
+	if err := s.db.First(&assignment, id).Error; err != nil {
+		return err
+	}
+
+	tx := s.begin()
+	before, err := snapshot(tx, &models.RoleAssignment{}, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Delete permanently, so the principal can be assigned again
+	if err := tx.Unscoped().Delete(&assignment).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Log the change
+	if err := tx.Create(&models.ConfigChange{
+		ChangeType:  "delete",
+		EntityType:  "role_assignment",
+		EntityID:    id,
+		Description: fmt.Sprintf("Removed role %s from %s", assignment.Role, assignment.Principal),
+		ChangedBy:   changedBy,
+		Before:      before,
+	}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return s.commit(tx)
+}
+
+// ConfigChangeFilter restricts the configuration change logs returned by
+// GetConfigChangeLogs. Empty fields match all changes.
+type ConfigChangeFilter struct {
+	EntityType string
+	EntityID   uint
+	ChangedBy  string
+	From       time.Time
+	To         time.Time
+}
+
+// GetConfigChangeLogs retrieves configuration change logs, newest first
+func (s *Service) GetConfigChangeLogs(filter ConfigChangeFilter, limit, offset int) ([]models.ConfigChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query := s.db.Model(&models.ConfigChange{})
+	if filter.EntityType != "" {
+		query = query.Where("entity_type = ?", filter.EntityType)
+	}
+	if filter.EntityID != 0 {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+	if filter.ChangedBy != "" {
+		query = query.Where("changed_by = ?", filter.ChangedBy)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+
 	var logs []models.ConfigChange
-	err := s.db.Order("created_at DESC").Limit(limit).Offset(offset).Find(&logs).Error
+	err := query.Order("created_at DESC").Order("id DESC").Limit(limit).Offset(offset).Find(&logs).Error
 	return logs, err
 }
 
+// CountConfigChanges counts the configuration changes by entity type and
+// change type
+func (s *Service) CountConfigChanges() ([]models.ConfigChangeCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var counts []models.ConfigChangeCount
+	err := s.db.Model(&models.ConfigChange{}).
+		Select("entity_type, change_type, COUNT(*) AS count").
+		Group("entity_type, change_type").
+		Scan(&counts).Error
+	return counts, err
+}
+
 // GetAvailabilityLogs retrieves backend availability logs
 func (s *Service) GetAvailabilityLogs(limit, offset int) ([]models.AvailabilityLog, error) {
 	s.mu.RLock()
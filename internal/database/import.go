@@ -0,0 +1,576 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/conflict"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/declarative"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ImportResult is the outcome of importing a declarative configuration
+type ImportResult struct {
+	DryRun  bool                 `json:"dry_run"`
+	Changes []declarative.Change `json:"changes"`
+	// Conflicts between the imported rules that were accepted as warnings
+	Conflicts []conflict.Conflict `json:"conflicts,omitempty"`
+	// Revision the import was recorded as, 0 for dry runs and imports
+	// without changes
+	Revision uint `json:"revision,omitempty"`
+}
+
+// importer applies the changes of an import in a transaction, keeping
+// track of the stored entities by name
+type importer struct {
+	tx          *gorm.DB
+	changedBy   string
+	backends    map[string]*models.Backend
+	backendSets map[string]*models.BackendSet
+	sources     map[string]*models.SourceDefinition
+	// Rules by their key and priority
+	rules map[string]*models.Rule
+	// IDs of the rules created or updated by the import, including the
+	// rules of updated source definitions
+	saved map[uint]bool
+}
+
+// loadState loads the routing configuration
+func loadState(db *gorm.DB) (declarative.State, error) {
+	var state declarative.State
+	if err := db.Preload("Addresses").Order("id").Find(&state.Backends).Error; err != nil {
+		return state, err
+	}
+	if err := db.Preload("Backends").Order("id").Find(&state.BackendSets).Error; err != nil {
+		return state, err
+	}
+	if err := db.Order("id").Find(&state.SourceDefinitions).Error; err != nil {
+		return state, err
+	}
+	if err := db.Order("id").Find(&state.Rules).Error; err != nil {
+		return state, err
+	}
+	return state, nil
+}
+
+// ExportConfiguration returns the routing configuration as a declarative
+// document
+func (s *Service) ExportConfiguration() (*declarative.Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, err := loadState(s.db)
+	if err != nil {
+		return nil, err
+	}
+	return declarative.Export(state), nil
+}
+
+// ImportConfiguration applies a declarative document as the desired state
+// of the routing configuration in a single transaction, recorded as one
+// revision. Entities missing from the document are deleted if prune is
+// set. Dry runs apply the changes and roll them back, so that they are
+// checked like a real import. Overlapping rules with the same priority
+// are rejected with a *conflict.Error, invalid documents with an error
+// wrapping declarative.ErrInvalid.
+func (s *Service) ImportConfiguration(doc *declarative.Document, prune, dryRun bool, changedBy string) (*ImportResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revision := &revisionState{description: "Imported configuration"}
+	tx := s.beginRevision(revision)
+
+	state, err := loadState(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	current := declarative.Export(state)
+	desired, err := declarative.Desired(current, doc, prune)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	result := &ImportResult{DryRun: dryRun, Changes: declarative.Diff(current, desired)}
+	if result.Changes == nil {
+		result.Changes = []declarative.Change{}
+	}
+
+	imp := newImporter(tx, state, changedBy)
+	for _, change := range result.Changes {
+		if err := imp.apply(change); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("%s %s %s: %w", change.Action, change.EntityType, change.Name, err)
+		}
+	}
+
+	if result.Conflicts, err = imp.conflicts(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if dryRun || revision.id == 0 {
+		tx.Rollback()
+		return result, nil
+	}
+
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+	result.Revision = revision.id
+	return result, nil
+}
+
+// newImporter indexes the stored routing configuration
+func newImporter(tx *gorm.DB, state declarative.State, changedBy string) *importer {
+	imp := &importer{
+		tx:          tx,
+		changedBy:   changedBy,
+		backends:    make(map[string]*models.Backend),
+		backendSets: make(map[string]*models.BackendSet),
+		sources:     make(map[string]*models.SourceDefinition),
+		rules:       make(map[string]*models.Rule),
+		saved:       make(map[uint]bool),
+	}
+
+	for i := range state.Backends {
+		imp.backends[state.Backends[i].Name] = &state.Backends[i]
+	}
+	for i := range state.BackendSets {
+		imp.backendSets[state.BackendSets[i].Name] = &state.BackendSets[i]
+	}
+	sourceNames := make(map[uint]string)
+	for i := range state.SourceDefinitions {
+		imp.sources[state.SourceDefinitions[i].Name] = &state.SourceDefinitions[i]
+		sourceNames[state.SourceDefinitions[i].ID] = state.SourceDefinitions[i].Name
+	}
+	rules := make([]declarative.Rule, len(state.Rules))
+	for i, rule := range state.Rules {
+		rules[i] = declarative.Rule{
+			Source:          sourceNames[rule.SourceDefinitionID],
+			Protocol:        rule.Protocol,
+			DestinationPort: rule.DestinationPort,
+			Priority:        rule.Priority,
+			Nodes:           rule.Nodes,
+			NodeGroups:      rule.NodeGroups,
+		}
+	}
+	for i, rule := range rules {
+		imp.rules[rule.PriorityKey()] = &state.Rules[i]
+	}
+
+	return imp
+}
+
+// apply applies a single change
+func (imp *importer) apply(change declarative.Change) error {
+	switch change.EntityType {
+	case declarative.EntityBackend:
+		if change.Action == declarative.ActionDelete {
+			return imp.deleteBackend(change.Name)
+		}
+		return imp.saveBackend(change)
+	case declarative.EntityBackendSet:
+		if change.Action == declarative.ActionDelete {
+			return imp.deleteBackendSet(change.Name)
+		}
+		return imp.saveBackendSet(change.After.(declarative.BackendSet))
+	case declarative.EntitySourceDefinition:
+		if change.Action == declarative.ActionDelete {
+			return imp.deleteSourceDefinition(change.Name)
+		}
+		return imp.saveSourceDefinition(change.After.(declarative.SourceDefinition))
+	case declarative.EntityRule:
+		if change.Action == declarative.ActionDelete {
+			return imp.deleteRule(change.Before.(declarative.Rule))
+		}
+		return imp.saveRule(change)
+	default:
+		return fmt.Errorf("unknown entity type %s", change.EntityType)
+	}
+}
+
+// log records a configuration change of the import
+func (imp *importer) log(changeType, entityType string, entityID uint, description string, before, after models.Snapshot) error {
+	return imp.tx.Create(&models.ConfigChange{
+		ChangeType:  changeType,
+		EntityType:  entityType,
+		EntityID:    entityID,
+		Description: description,
+		ChangedBy:   imp.changedBy,
+		Before:      before,
+		After:       after,
+	}).Error
+}
+
+// saveBackend creates or updates a backend and its addresses
+func (imp *importer) saveBackend(change declarative.Change) error {
+	entry := change.After.(declarative.Backend)
+	backend, exists := imp.backends[entry.Name]
+	if !exists {
+		backend = &models.Backend{}
+		entry.Apply(backend)
+		if err := imp.tx.Omit(clause.Associations).Create(backend).Error; err != nil {
+			return err
+		}
+		after, err := snapshot(imp.tx, &models.Backend{}, backend.ID)
+		if err != nil {
+			return err
+		}
+		if err := imp.log("create", "backend", backend.ID, fmt.Sprintf("Created backend %s", backend.Name), nil, after); err != nil {
+			return err
+		}
+		imp.backends[entry.Name] = backend
+	} else if backendChanged(change) {
+		before, err := snapshot(imp.tx, &models.Backend{}, backend.ID)
+		if err != nil {
+			return err
+		}
+		entry.Apply(backend)
+		if err := imp.tx.Omit(clause.Associations).Save(backend).Error; err != nil {
+			return err
+		}
+		after, err := snapshot(imp.tx, &models.Backend{}, backend.ID)
+		if err != nil {
+			return err
+		}
+		if err := imp.log("update", "backend", backend.ID, fmt.Sprintf("Updated backend %s", backend.Name), before, after); err != nil {
+			return err
+		}
+	}
+
+	return imp.syncAddresses(backend, entry.Addresses)
+}
+
+// backendChanged reports whether a backend update changes more than its
+// addresses
+func backendChanged(change declarative.Change) bool {
+	before, after := change.Before.(declarative.Backend), change.After.(declarative.Backend)
+	before.Addresses, after.Addresses = nil, nil
+	return !reflect.DeepEqual(before, after)
+}
+
+// syncAddresses deletes the addresses of a backend missing from the
+// document and creates the new ones. Runtime and admin states of the
+// remaining addresses are kept.
+func (imp *importer) syncAddresses(backend *models.Backend, entries []declarative.Address) error {
+	desired := make(map[declarative.Address]bool)
+	for _, entry := range entries {
+		desired[entry] = true
+	}
+
+	stored := make(map[declarative.Address]bool)
+	for _, address := range backend.Addresses {
+		key := declarative.Address{IP: address.IP, Port: address.Port}
+		stored[key] = true
+		if desired[key] {
+			continue
+		}
+
+		before, err := snapshot(imp.tx, &models.Address{}, address.ID)
+		if err != nil {
+			return err
+		}
+		if err := imp.tx.Delete(&models.Address{}, address.ID).Error; err != nil {
+			return err
+		}
+		if err := endEntityWindows(imp.tx, "address", address.ID); err != nil {
+			return err
+		}
+		if err := imp.log("delete", "address", address.ID, fmt.Sprintf("Deleted address %s:%d", address.IP, address.Port), before, nil); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		if stored[entry] {
+			continue
+		}
+
+		address := models.Address{BackendID: backend.ID, IP: entry.IP, Port: entry.Port}
+		if err := imp.tx.Create(&address).Error; err != nil {
+			return err
+		}
+		after, err := snapshot(imp.tx, &models.Address{}, address.ID)
+		if err != nil {
+			return err
+		}
+		if err := imp.log("create", "address", address.ID, fmt.Sprintf("Added address %s:%d to backend ID %d", address.IP, address.Port, backend.ID), nil, after); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteBackend deletes a backend with its addresses. The backend sets
+// referencing it were changed or deleted before.
+func (imp *importer) deleteBackend(name string) error {
+	backend := imp.backends[name]
+	before, err := snapshot(imp.tx, &models.Backend{}, backend.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := imp.syncAddresses(backend, nil); err != nil {
+		return err
+	}
+	if err := imp.tx.Exec("DELETE FROM backend_set_backends WHERE backend_id = ?", backend.ID).Error; err != nil {
+		return err
+	}
+	if err := imp.tx.Delete(&models.Backend{}, backend.ID).Error; err != nil {
+		return err
+	}
+	if err := endEntityWindows(imp.tx, "backend", backend.ID); err != nil {
+		return err
+	}
+
+	delete(imp.backends, name)
+	return imp.log("delete", "backend", backend.ID, fmt.Sprintf("Deleted backend %s", backend.Name), before, nil)
+}
+
+// saveBackendSet creates or updates a backend set and its backends
+func (imp *importer) saveBackendSet(entry declarative.BackendSet) error {
+	backendSet, exists := imp.backendSets[entry.Name]
+
+	var before models.Snapshot
+	var err error
+	if exists {
+		if before, err = snapshot(imp.tx, &models.BackendSet{}, backendSet.ID); err != nil {
+			return err
+		}
+		backendSet.Description = entry.Description
+		if err := imp.tx.Omit(clause.Associations).Save(backendSet).Error; err != nil {
+			return err
+		}
+	} else {
+		backendSet = &models.BackendSet{Name: entry.Name, Description: entry.Description}
+		if err := imp.tx.Omit(clause.Associations).Create(backendSet).Error; err != nil {
+			return err
+		}
+		imp.backendSets[entry.Name] = backendSet
+	}
+
+	if err := imp.tx.Exec("DELETE FROM backend_set_backends WHERE backend_set_id = ?", backendSet.ID).Error; err != nil {
+		return err
+	}
+	for _, name := range entry.Backends {
+		if err := imp.tx.Exec("INSERT INTO backend_set_backends (backend_set_id, backend_id) VALUES (?, ?)", backendSet.ID, imp.backends[name].ID).Error; err != nil {
+			return err
+		}
+	}
+
+	after, err := snapshot(imp.tx, &models.BackendSet{}, backendSet.ID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return imp.log("update", "backend_set", backendSet.ID, fmt.Sprintf("Updated backend set %s", backendSet.Name), before, after)
+	}
+	return imp.log("create", "backend_set", backendSet.ID, fmt.Sprintf("Created backend set %s", backendSet.Name), nil, after)
+}
+
+// deleteBackendSet deletes a backend set. The rules referencing it were
+// deleted before.
+func (imp *importer) deleteBackendSet(name string) error {
+	backendSet := imp.backendSets[name]
+	before, err := snapshot(imp.tx, &models.BackendSet{}, backendSet.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := imp.tx.Exec("DELETE FROM backend_set_backends WHERE backend_set_id = ?", backendSet.ID).Error; err != nil {
+		return err
+	}
+	if err := imp.tx.Delete(&models.BackendSet{}, backendSet.ID).Error; err != nil {
+		return err
+	}
+
+	delete(imp.backendSets, name)
+	return imp.log("delete", "backend_set", backendSet.ID, fmt.Sprintf("Deleted backend set %s", backendSet.Name), before, nil)
+}
+
+// saveSourceDefinition creates or updates a source definition
+func (imp *importer) saveSourceDefinition(entry declarative.SourceDefinition) error {
+	sourceDefinition, exists := imp.sources[entry.Name]
+	if !exists {
+		sourceDefinition = &models.SourceDefinition{}
+		entry.Apply(sourceDefinition)
+		if err := imp.tx.Create(sourceDefinition).Error; err != nil {
+			return err
+		}
+		after, err := snapshot(imp.tx, &models.SourceDefinition{}, sourceDefinition.ID)
+		if err != nil {
+			return err
+		}
+		imp.sources[entry.Name] = sourceDefinition
+		return imp.log("create", "source_definition", sourceDefinition.ID, fmt.Sprintf("Created source definition %s", sourceDefinition.Name), nil, after)
+	}
+
+	before, err := snapshot(imp.tx, &models.SourceDefinition{}, sourceDefinition.ID)
+	if err != nil {
+		return err
+	}
+	entry.Apply(sourceDefinition)
+	if err := imp.tx.Save(sourceDefinition).Error; err != nil {
+		return err
+	}
+	after, err := snapshot(imp.tx, &models.SourceDefinition{}, sourceDefinition.ID)
+	if err != nil {
+		return err
+	}
+	// The rules of the source match other traffic now
+	for _, rule := range imp.rules {
+		if rule.SourceDefinitionID == sourceDefinition.ID {
+			imp.saved[rule.ID] = true
+		}
+	}
+	return imp.log("update", "source_definition", sourceDefinition.ID, fmt.Sprintf("Updated source definition %s", sourceDefinition.Name), before, after)
+}
+
+// deleteSourceDefinition deletes a source definition. The rules
+// referencing it were deleted before.
+func (imp *importer) deleteSourceDefinition(name string) error {
+	sourceDefinition := imp.sources[name]
+	before, err := snapshot(imp.tx, &models.SourceDefinition{}, sourceDefinition.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := imp.tx.Delete(&models.SourceDefinition{}, sourceDefinition.ID).Error; err != nil {
+		return err
+	}
+
+	delete(imp.sources, name)
+	return imp.log("delete", "source_definition", sourceDefinition.ID, fmt.Sprintf("Deleted source definition %s", sourceDefinition.Name), before, nil)
+}
+
+// saveRule creates or updates a rule. Updated rules keep their key, so
+// only the priority, the backend set and whether they are enabled can
+// change.
+func (imp *importer) saveRule(change declarative.Change) error {
+	entry := change.After.(declarative.Rule)
+	var rule *models.Rule
+	exists := change.Action == declarative.ActionUpdate
+	if exists {
+		previous := change.Before.(declarative.Rule).PriorityKey()
+		rule = imp.rules[previous]
+		delete(imp.rules, previous)
+	}
+
+	var before models.Snapshot
+	var err error
+	if exists {
+		if before, err = snapshot(imp.tx, &models.Rule{}, rule.ID); err != nil {
+			return err
+		}
+	} else {
+		rule = &models.Rule{
+			SourceDefinitionID: imp.sources[entry.Source].ID,
+			DestinationPort:    entry.DestinationPort,
+			Protocol:           entry.Protocol,
+			Nodes:              entry.Nodes,
+			NodeGroups:         entry.NodeGroups,
+		}
+	}
+	rule.Priority = entry.Priority
+	rule.BackendSetID = imp.backendSets[entry.BackendSet].ID
+	rule.Enabled = entry.IsEnabled()
+
+	if exists {
+		if err := imp.tx.Omit(clause.Associations).Save(rule).Error; err != nil {
+			return err
+		}
+	} else {
+		if err := imp.tx.Omit(clause.Associations).Create(rule).Error; err != nil {
+			return err
+		}
+		// Enabled defaults to true, so false is not inserted and has to be
+		// set afterwards
+		if !entry.IsEnabled() {
+			if err := imp.tx.Model(rule).Update("enabled", false).Error; err != nil {
+				return err
+			}
+		}
+	}
+	imp.rules[entry.PriorityKey()] = rule
+
+	imp.saved[rule.ID] = true
+
+	after, err := snapshot(imp.tx, &models.Rule{}, rule.ID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return imp.log("update", "rule", rule.ID, fmt.Sprintf("Updated rule with priority %d", rule.Priority), before, after)
+	}
+	return imp.log("create", "rule", rule.ID, fmt.Sprintf("Created rule with priority %d", rule.Priority), nil, after)
+}
+
+// deleteRule deletes a rule
+func (imp *importer) deleteRule(entry declarative.Rule) error {
+	rule := imp.rules[entry.PriorityKey()]
+	before, err := snapshot(imp.tx, &models.Rule{}, rule.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := imp.tx.Delete(&models.Rule{}, rule.ID).Error; err != nil {
+		return err
+	}
+
+	delete(imp.rules, entry.PriorityKey())
+	return imp.log("delete", "rule", rule.ID, fmt.Sprintf("Deleted rule with priority %d", rule.Priority), before, nil)
+}
+
+// conflicts checks the enabled rules after the import for conflicts
+// involving the rules it created or updated, so that conflicts between
+// other rules do not prevent imports. Overlapping rules with the same
+// priority are rejected with a *conflict.Error, other conflicts are
+// returned as warnings.
+func (imp *importer) conflicts() ([]conflict.Conflict, error) {
+	var rules []models.Rule
+	if err := imp.tx.Preload("SourceDefinition").Where("enabled = ?", true).Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	var nodes []models.IngressNode
+	if err := imp.tx.Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+
+	var rejected, warnings []conflict.Conflict
+	for _, c := range conflict.Find(rules, nodes) {
+		if !imp.involvesSaved(c) {
+			continue
+		}
+		if c.Kind == conflict.EqualPriority {
+			rejected = append(rejected, c)
+		} else {
+			warnings = append(warnings, c)
+		}
+	}
+	if len(rejected) > 0 {
+		return nil, &conflict.Error{Conflicts: rejected}
+	}
+
+	return warnings, nil
+}
+
+// involvesSaved reports whether a conflict involves a rule created or
+// updated by the import
+func (imp *importer) involvesSaved(c conflict.Conflict) bool {
+	if imp.saved[c.RuleID] {
+		return true
+	}
+	for _, id := range c.With {
+		if imp.saved[id] {
+			return true
+		}
+	}
+	return false
+}
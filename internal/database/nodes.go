@@ -0,0 +1,90 @@
+package database
+
+import (
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"gorm.io/gorm/clause"
+)
+
+// RegisterIngressNode adds a node to the registry, or updates the group of
+// a node registered before
+func (s *Service) RegisterIngressNode(name, group string) (*models.IngressNode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node := models.IngressNode{Name: name, Group: group}
+	err := s.db.Where(models.IngressNode{Name: name}).
+		Assign(map[string]interface{}{"node_group": group, "updated_at": time.Now()}).
+		FirstOrCreate(&node).Error
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// GetIngressNodes retrieves all registered nodes
+func (s *Service) GetIngressNodes() ([]models.IngressNode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var nodes []models.IngressNode
+	err := s.db.Order("name").Find(&nodes).Error
+	return nodes, err
+}
+
+// GetIngressNode retrieves a registered node by ID
+func (s *Service) GetIngressNode(id uint) (*models.IngressNode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var node models.IngressNode
+	err := s.db.First(&node, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// GetNodeAvailabilities retrieves the availability of the addresses as seen
+// by a node. Addresses the node has not checked yet are missing.
+func (s *Service) GetNodeAvailabilities(nodeID uint) ([]models.NodeAvailability, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var availabilities []models.NodeAvailability
+	err := s.db.Where("node_id = ?", nodeID).Order("address_id").Find(&availabilities).Error
+	return availabilities, err
+}
+
+// LogNodeAvailabilityChange records a change of the availability of an
+// address as seen by a node
+func (s *Service) LogNodeAvailabilityChange(nodeID, addressID uint, available bool, checkError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	availability := models.NodeAvailability{
+		NodeID:      nodeID,
+		AddressID:   addressID,
+		Available:   available,
+		LastChecked: now,
+	}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "node_id"}, {Name: "address_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"available", "last_checked"}),
+	}).Create(&availability).Error
+	if err != nil {
+		return err
+	}
+
+	log := models.AvailabilityLog{
+		NodeID:     nodeID,
+		AddressID:  addressID,
+		Available:  available,
+		CheckTime:  now,
+		CheckError: checkError,
+	}
+	return s.db.Create(&log).Error
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ChangeChannel is the PostgreSQL notification channel of configuration
+// changes. The payload is the entity type of the change.
+const ChangeChannel = "b2b_config_changes"
+
+// Reconnect intervals of the PostgreSQL listener
+const (
+	listenerMinReconnect = time.Second
+	listenerMaxReconnect = time.Minute
+)
+
+// registerNotifications notifies of configuration changes once they are
+// committed. On PostgreSQL the notification is sent in the transaction of
+// the change, which delivers it to all nodes on commit and not at all on
+// rollback. Other drivers notify the listeners of this process: changes
+// made in a transaction are collected in its revision state and sent by
+// commit, other changes as soon as their implicit transaction committed.
+func registerNotifications(db *gorm.DB, local *notifier) error {
+	if db.Dialector.Name() == DriverPostgres {
+		return db.Callback().Create().After("gorm:create").Register("notifications:pg_notify", func(tx *gorm.DB) {
+			change, ok := tx.Statement.Dest.(*models.ConfigChange)
+			if !ok || tx.Error != nil {
+				return
+			}
+			err := tx.Session(&gorm.Session{NewDB: true}).
+				Exec("SELECT pg_notify(?, ?)", ChangeChannel, change.EntityType).Error
+			if err != nil {
+				tx.AddError(err)
+			}
+		})
+	}
+
+	return db.Callback().Create().After("gorm:commit_or_rollback_transaction").Register("notifications:local", func(tx *gorm.DB) {
+		change, ok := tx.Statement.Dest.(*models.ConfigChange)
+		if !ok || tx.Error != nil {
+			return
+		}
+		if revision, ok := tx.Statement.Context.Value(revisionKey{}).(*revisionState); ok {
+			revision.changes = append(revision.changes, change.EntityType)
+			return
+		}
+		local.notify(change.EntityType)
+	})
+}
+
+// commit commits a transaction and sends the notifications of its
+// configuration changes to the listeners of this process
+func (s *Service) commit(tx *gorm.DB) error {
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	if revision, ok := tx.Statement.Context.Value(revisionKey{}).(*revisionState); ok {
+		for _, entityType := range revision.changes {
+			s.local.notify(entityType)
+		}
+		revision.changes = nil
+	}
+	return nil
+}
+
+// notifier fans out notifications of configuration changes within this
+// process
+type notifier struct {
+	mu        sync.Mutex
+	listeners map[chan string]struct{}
+}
+
+func newNotifier() *notifier {
+	return &notifier{listeners: make(map[chan string]struct{})}
+}
+
+// notify sends a notification to all listeners without waiting for them.
+// A listener with a pending notification does not need another one.
+func (n *notifier) notify(payload string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for listener := range n.listeners {
+		select {
+		case listener <- payload:
+		default:
+		}
+	}
+}
+
+func (n *notifier) subscribe() chan string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	listener := make(chan string, 1)
+	n.listeners[listener] = struct{}{}
+	return listener
+}
+
+func (n *notifier) unsubscribe(listener chan string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.listeners, listener)
+	close(listener)
+}
+
+// Listener receives notifications of committed configuration changes,
+// including those made by other nodes sharing the database
+type Listener struct {
+	changes chan string
+	local   *notifier
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// Listen starts listening for configuration changes. On PostgreSQL a
+// dedicated connection waits for notifications, which is reconnected
+// when it is lost.
+func (s *Service) Listen() (*Listener, error) {
+	if s.dsn == "" {
+		return &Listener{changes: s.local.subscribe(), local: s.local}, nil
+	}
+
+	config, err := pgx.ParseConfig(s.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Listener{
+		changes: make(chan string, 1),
+		cancel:  cancel,
+	}
+	l.wg.Add(1)
+	go l.run(ctx, config, s.logger)
+	return l, nil
+}
+
+// Changes returns the channel receiving the entity types of the changes.
+// An empty entity type reports that changes may have been missed while
+// the connection was lost. Notifications are coalesced while the receiver
+// is busy.
+func (l *Listener) Changes() <-chan string {
+	return l.changes
+}
+
+// Close stops listening and closes the channel of changes
+func (l *Listener) Close() {
+	if l.cancel == nil {
+		l.local.unsubscribe(l.changes)
+		return
+	}
+
+	l.cancel()
+	l.wg.Wait()
+	close(l.changes)
+}
+
+// run listens for notifications until ctx is cancelled, reconnecting with
+// an increasing delay
+func (l *Listener) run(ctx context.Context, config *pgx.ConnConfig, logger *logrus.Logger) {
+	defer l.wg.Done()
+
+	delay := listenerMinReconnect
+	connected := false
+	for {
+		err := l.listen(ctx, config, func() {
+			if connected {
+				logger.Info("Configuration change listener reconnected")
+				l.send("")
+			}
+			connected = true
+			delay = listenerMinReconnect
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Errorf("Configuration change listener disconnected: %v", err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		if delay *= 2; delay > listenerMaxReconnect {
+			delay = listenerMaxReconnect
+		}
+	}
+}
+
+// listen connects, calls listening once it listens and forwards the
+// notifications until the connection fails
+func (l *Listener) listen(ctx context.Context, config *pgx.ConnConfig, listening func()) error {
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+ChangeChannel); err != nil {
+		return err
+	}
+	listening()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		l.send(notification.Payload)
+	}
+}
+
+// send passes a notification to the channel of changes unless one is
+// pending
+func (l *Listener) send(payload string) {
+	select {
+	case l.changes <- payload:
+	default:
+	}
+}
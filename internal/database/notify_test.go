@@ -0,0 +1,111 @@
+package database
+
+import (
+	"io"
+	"testing"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	s, err := NewService(Config{Driver: DriverSQLite, Path: ":memory:"}, logger)
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+	return s
+}
+
+// pending returns the pending notification of a listener
+func pending(l *Listener) (string, bool) {
+	select {
+	case entityType := <-l.Changes():
+		return entityType, true
+	default:
+		return "", false
+	}
+}
+
+func TestLocalNotificationsAfterCommit(t *testing.T) {
+	s := newTestService(t)
+	l, err := s.Listen()
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+
+	tx := s.begin()
+	if err := tx.Create(&models.ConfigChange{ChangeType: "create", EntityType: "backend"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if entityType, ok := pending(l); ok {
+		t.Fatalf("notified of %q before the commit", entityType)
+	}
+	if err := s.commit(tx); err != nil {
+		t.Fatalf("commit() error = %v", err)
+	}
+	if entityType, ok := pending(l); !ok || entityType != "backend" {
+		t.Errorf("notification after the commit = %q, %v, want backend", entityType, ok)
+	}
+}
+
+func TestLocalNotificationsRolledBack(t *testing.T) {
+	s := newTestService(t)
+	l, err := s.Listen()
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+
+	tx := s.begin()
+	if err := tx.Create(&models.ConfigChange{ChangeType: "create", EntityType: "backend"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+	if entityType, ok := pending(l); ok {
+		t.Errorf("notified of %q of a rolled back transaction", entityType)
+	}
+
+	// A failed change is rolled back by the service
+	if err := s.CreateBackend(&models.Backend{Name: "web1"}, "test"); err != nil {
+		t.Fatalf("CreateBackend() error = %v", err)
+	}
+	pending(l)
+	if err := s.CreateBackend(&models.Backend{Name: "web1"}, "test"); err == nil {
+		t.Fatal("CreateBackend() of a duplicate name succeeded")
+	}
+	if entityType, ok := pending(l); ok {
+		t.Errorf("notified of %q of a failed change", entityType)
+	}
+}
+
+func TestLocalNotificationsWithoutTransaction(t *testing.T) {
+	s := newTestService(t)
+	l, err := s.Listen()
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+
+	if err := s.db.Create(&models.ConfigChange{ChangeType: "update", EntityType: "address"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if entityType, ok := pending(l); !ok || entityType != "address" {
+		t.Errorf("notification = %q, %v, want address", entityType, ok)
+	}
+}
+
+func TestNotificationCallbacks(t *testing.T) {
+	s := newTestService(t)
+	if s.db.Callback().Create().Get("notifications:local") == nil {
+		t.Error("local notifications are not registered")
+	}
+	if s.db.Callback().Create().Get("notifications:pg_notify") != nil {
+		t.Error("PostgreSQL notifications are registered for SQLite")
+	}
+}
@@ -0,0 +1,517 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// revisionKey is the context key of the revision of a transaction
+type revisionKey struct{}
+
+// revisionState is the revision the configuration changes of a transaction
+// belong to. The revision is created with the first change.
+type revisionState struct {
+	id uint
+	// description is used instead of the description of the last change
+	description string
+	// changes are the entity types of the changes, notified within the
+	// process on commit
+	changes []string
+}
+
+// routingEntities are the entity types of the routing configuration in the
+// order they are restored. Entities are deleted in reverse order.
+var routingEntities = []struct {
+	entityType string
+	model      interface{}
+}{
+	{"backend", &models.Backend{}},
+	{"address", &models.Address{}},
+	{"backend_set", &models.BackendSet{}},
+	{"source_definition", &models.SourceDefinition{}},
+	{"rule", &models.Rule{}},
+}
+
+// runtimeColumns are updated by health checks and are neither compared nor
+// restored
+var runtimeColumns = map[string]bool{
+	"updated_at":   true,
+	"available":    true,
+	"last_checked": true,
+}
+
+// Configuration is the routing configuration as snapshots of its entities
+// by entity type and ID
+type Configuration map[string]map[uint]models.Snapshot
+
+// EntityDiff is the difference of an entity between two revisions
+type EntityDiff struct {
+	EntityType string          `json:"entity_type"`
+	EntityID   uint            `json:"entity_id"`
+	ChangeType string          `json:"change_type"`
+	Before     models.Snapshot `json:"before,omitempty"`
+	After      models.Snapshot `json:"after,omitempty"`
+}
+
+// begin starts a transaction whose configuration changes form one revision
+func (s *Service) begin() *gorm.DB {
+	return s.beginRevision(&revisionState{})
+}
+
+// beginRevision starts a transaction whose configuration changes form the
+// given revision
+func (s *Service) beginRevision(revision *revisionState) *gorm.DB {
+	ctx := context.WithValue(context.Background(), revisionKey{}, revision)
+	return s.db.WithContext(ctx).Begin()
+}
+
+// registerRevisions assigns configuration changes to the revision of their
+// transaction. Changes made outside of a transaction get a revision of
+// their own.
+func registerRevisions(db *gorm.DB) error {
+	return db.Callback().Create().Before("gorm:create").Register("revisions:assign", func(tx *gorm.DB) {
+		change, ok := tx.Statement.Dest.(*models.ConfigChange)
+		if !ok || change.Revision != 0 {
+			return
+		}
+
+		revision, ok := tx.Statement.Context.Value(revisionKey{}).(*revisionState)
+		if !ok {
+			revision = &revisionState{}
+		}
+		if err := recordRevision(tx.Session(&gorm.Session{NewDB: true}), revision, change.ChangedBy, change.Description); err != nil {
+			tx.AddError(err)
+			return
+		}
+		change.Revision = revision.id
+	})
+}
+
+// recordRevision creates the revision of a transaction, or updates its
+// description with the latest change
+func recordRevision(tx *gorm.DB, revision *revisionState, changedBy, description string) error {
+	if revision.description != "" {
+		description = revision.description
+	}
+
+	if revision.id != 0 {
+		if revision.description != "" {
+			return nil
+		}
+		return tx.Model(&models.Revision{}).Where("id = ?", revision.id).Update("description", description).Error
+	}
+
+	record := models.Revision{ChangedBy: changedBy, Description: description}
+	if err := tx.Create(&record).Error; err != nil {
+		return err
+	}
+	revision.id = record.ID
+	return nil
+}
+
+// recordBaseline records the existing routing configuration as the first
+// revision, so that it can be restored
+func (s *Service) recordBaseline() error {
+	var count int64
+	if err := s.db.Model(&models.Revision{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	revision := &revisionState{description: "Baseline of the existing configuration"}
+	tx := s.beginRevision(revision)
+	if err := recordRevision(tx, revision, "system", ""); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, entity := range routingEntities {
+		snapshots, err := snapshotAll(tx, entity.model)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		for _, id := range sortedIDs(snapshots) {
+			if err := tx.Create(&models.ConfigChange{
+				ChangeType:  "create",
+				EntityType:  entity.entityType,
+				EntityID:    id,
+				Description: fmt.Sprintf("Recorded existing %s ID %d in the baseline", entity.entityType, id),
+				ChangedBy:   "system",
+				After:       snapshots[id],
+			}).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	return s.commit(tx)
+}
+
+// snapshotAll returns the snapshots of all stored entities of a model
+func snapshotAll(tx *gorm.DB, model interface{}) (map[uint]models.Snapshot, error) {
+	rows := reflect.New(reflect.SliceOf(reflect.TypeOf(model).Elem()))
+	result := tx.Order("id").Find(rows.Interface())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	snapshots := make(map[uint]models.Snapshot)
+	for i := 0; i < rows.Elem().Len(); i++ {
+		value := rows.Elem().Index(i)
+		data, err := encodeSnapshot(tx, result.Statement.Schema, value)
+		if err != nil {
+			return nil, err
+		}
+		id := result.Statement.Schema.PrioritizedPrimaryField.ReflectValueOf(tx.Statement.Context, value).Interface().(uint)
+		snapshots[id] = data
+	}
+	return snapshots, nil
+}
+
+// GetRevisions retrieves revisions, newest first
+func (s *Service) GetRevisions(limit, offset int) ([]models.Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var revisions []models.Revision
+	err := s.db.Order("id DESC").Limit(limit).Offset(offset).Find(&revisions).Error
+	return revisions, err
+}
+
+// GetRevision retrieves a revision with its configuration changes
+func (s *Service) GetRevision(id uint) (*models.Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return getRevision(s.db, id)
+}
+
+// getRevision retrieves a revision with its configuration changes
+func getRevision(db *gorm.DB, id uint) (*models.Revision, error) {
+	var revision models.Revision
+	if err := db.First(&revision, id).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Where("revision = ?", id).Order("id").Find(&revision.Changes).Error; err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// GetLatestRevision retrieves the current revision
+func (s *Service) GetLatestRevision() (*models.Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var revision models.Revision
+	if err := s.db.Order("id DESC").First(&revision).Error; err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// configurationAt returns the routing configuration as of a revision from
+// the latest change of each entity up to the revision
+func configurationAt(db *gorm.DB, revision uint) (Configuration, error) {
+	if err := db.First(&models.Revision{}, revision).Error; err != nil {
+		return nil, err
+	}
+
+	entityTypes := make([]string, 0, len(routingEntities))
+	for _, entity := range routingEntities {
+		entityTypes = append(entityTypes, entity.entityType)
+	}
+
+	latest := db.Model(&models.ConfigChange{}).
+		Select("MAX(id)").
+		Where("revision > 0 AND revision <= ? AND entity_type IN ?", revision, entityTypes).
+		Group("entity_type, entity_id")
+
+	var changes []models.ConfigChange
+	if err := db.Where("id IN (?)", latest).Find(&changes).Error; err != nil {
+		return nil, err
+	}
+
+	config := make(Configuration)
+	for _, entityType := range entityTypes {
+		config[entityType] = make(map[uint]models.Snapshot)
+	}
+	for _, change := range changes {
+		if change.ChangeType != "delete" {
+			config[change.EntityType][change.EntityID] = change.After
+		}
+	}
+	return config, nil
+}
+
+// GetConfigurationAt retrieves the routing configuration as of a revision
+func (s *Service) GetConfigurationAt(revision uint) (Configuration, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return configurationAt(s.db, revision)
+}
+
+// DiffRevisions compares the routing configuration of two revisions
+func (s *Service) DiffRevisions(from, to uint) ([]EntityDiff, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	before, err := configurationAt(s.db, from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := configurationAt(s.db, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffConfigurations(before, after)
+}
+
+// diffConfigurations returns the entities that differ between two
+// configurations
+func diffConfigurations(before, after Configuration) ([]EntityDiff, error) {
+	diffs := []EntityDiff{}
+	for _, entity := range routingEntities {
+		ids := make(map[uint]models.Snapshot)
+		for id, data := range before[entity.entityType] {
+			ids[id] = data
+		}
+		for id, data := range after[entity.entityType] {
+			ids[id] = data
+		}
+
+		for _, id := range sortedIDs(ids) {
+			b, inBefore := before[entity.entityType][id]
+			a, inAfter := after[entity.entityType][id]
+
+			diff := EntityDiff{EntityType: entity.entityType, EntityID: id, Before: b, After: a}
+			switch {
+			case !inBefore:
+				diff.ChangeType = "create"
+			case !inAfter:
+				diff.ChangeType = "delete"
+			default:
+				equal, err := equalSnapshots(b, a)
+				if err != nil {
+					return nil, err
+				}
+				if equal {
+					continue
+				}
+				diff.ChangeType = "update"
+			}
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs, nil
+}
+
+// RestoreRevision restores the routing configuration as of a revision in a
+// single transaction. The restore is recorded as a new revision, which is
+// returned. It returns nil if the configuration already matches.
+func (s *Service) RestoreRevision(id uint, changedBy string) (*models.Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	target, err := configurationAt(s.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	revision := &revisionState{description: fmt.Sprintf("Restored revision %d", id)}
+	tx := s.beginRevision(revision)
+
+	current := make(Configuration)
+	for _, entity := range routingEntities {
+		if current[entity.entityType], err = snapshotAll(tx, entity.model); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	// Delete entities that did not exist at the revision, dependents first
+	for i := len(routingEntities) - 1; i >= 0; i-- {
+		entity := routingEntities[i]
+		for _, entityID := range sortedIDs(current[entity.entityType]) {
+			if _, ok := target[entity.entityType][entityID]; ok {
+				continue
+			}
+			if err := restoreDelete(tx, entity.entityType, entity.model, entityID, current[entity.entityType][entityID], id, changedBy); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+	}
+
+	// Create and update entities, dependencies first
+	for _, entity := range routingEntities {
+		for _, entityID := range sortedIDs(target[entity.entityType]) {
+			before, exists := current[entity.entityType][entityID]
+			if exists {
+				equal, err := equalSnapshots(before, target[entity.entityType][entityID])
+				if err != nil {
+					tx.Rollback()
+					return nil, err
+				}
+				if equal {
+					continue
+				}
+			}
+			if err := restoreEntity(tx, entity.entityType, entity.model, entityID, before, target[entity.entityType][entityID], id, changedBy); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+	}
+
+	if revision.id == 0 {
+		tx.Rollback()
+		return nil, nil
+	}
+
+	if err := s.commit(tx); err != nil {
+		return nil, err
+	}
+
+	return getRevision(s.db, revision.id)
+}
+
+// restoreDelete deletes an entity that did not exist at the restored
+// revision
+func restoreDelete(tx *gorm.DB, entityType string, model interface{}, id uint, before models.Snapshot, revision uint, changedBy string) error {
+	value := reflect.New(reflect.TypeOf(model).Elem())
+	if err := tx.First(value.Interface(), id).Error; err != nil {
+		return err
+	}
+
+	if _, ok := model.(*models.BackendSet); ok {
+		if err := tx.Exec("DELETE FROM backend_set_backends WHERE backend_set_id = ?", id).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Delete(value.Interface()).Error; err != nil {
+		return err
+	}
+	if entityType == "backend" || entityType == "address" {
+		if err := endEntityWindows(tx, entityType, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Create(&models.ConfigChange{
+		ChangeType:  "delete",
+		EntityType:  entityType,
+		EntityID:    id,
+		Description: fmt.Sprintf("Deleted %s ID %d to restore revision %d", entityType, id, revision),
+		ChangedBy:   changedBy,
+		Before:      before,
+	}).Error
+}
+
+// restoreEntity creates or updates an entity to match its snapshot at the
+// restored revision. Deleted entities are restored with their ID, so that
+// references to them stay valid.
+func restoreEntity(tx *gorm.DB, entityType string, model interface{}, id uint, before, target models.Snapshot, revision uint, changedBy string) error {
+	value := reflect.New(reflect.TypeOf(model).Elem())
+	result := tx.Unscoped().Limit(1).Find(value.Interface(), id)
+	if result.Error != nil {
+		return result.Error
+	}
+	stored := result.RowsAffected > 0
+
+	var columns map[string]json.RawMessage
+	if err := json.Unmarshal(target, &columns); err != nil {
+		return err
+	}
+	for _, field := range result.Statement.Schema.Fields {
+		data, ok := columns[field.DBName]
+		if field.DBName == "" || !ok || (stored && runtimeColumns[field.DBName]) {
+			continue
+		}
+		fieldValue := reflect.New(field.FieldType)
+		if err := json.Unmarshal(data, fieldValue.Interface()); err != nil {
+			return fmt.Errorf("invalid %s of %s ID %d: %v", field.DBName, entityType, id, err)
+		}
+		field.ReflectValueOf(tx.Statement.Context, value.Elem()).Set(fieldValue.Elem())
+	}
+
+	if err := tx.Unscoped().Omit(clause.Associations).Save(value.Interface()).Error; err != nil {
+		return err
+	}
+
+	if _, ok := model.(*models.BackendSet); ok {
+		var backendIDs []uint
+		if err := json.Unmarshal(columns["backend_ids"], &backendIDs); err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM backend_set_backends WHERE backend_set_id = ?", id).Error; err != nil {
+			return err
+		}
+		for _, backendID := range backendIDs {
+			if err := tx.Exec("INSERT INTO backend_set_backends (backend_set_id, backend_id) VALUES (?, ?)", id, backendID).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	after, err := snapshot(tx, model, id)
+	if err != nil {
+		return err
+	}
+
+	changeType := "update"
+	if before == nil {
+		changeType = "create"
+	}
+
+	return tx.Create(&models.ConfigChange{
+		ChangeType:  changeType,
+		EntityType:  entityType,
+		EntityID:    id,
+		Description: fmt.Sprintf("Restored %s ID %d from revision %d", entityType, id, revision),
+		ChangedBy:   changedBy,
+		Before:      before,
+		After:       after,
+	}).Error
+}
+
+// equalSnapshots reports whether two snapshots match, ignoring runtime
+// columns
+func equalSnapshots(a, b models.Snapshot) (bool, error) {
+	var columnsA, columnsB map[string]interface{}
+	if err := json.Unmarshal(a, &columnsA); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, &columnsB); err != nil {
+		return false, err
+	}
+	for column := range runtimeColumns {
+		delete(columnsA, column)
+		delete(columnsB, column)
+	}
+	return reflect.DeepEqual(columnsA, columnsB), nil
+}
+
+// sortedIDs returns the IDs of a set of snapshots in ascending order
+func sortedIDs(snapshots map[uint]models.Snapshot) []uint {
+	ids := make([]uint, 0, len(snapshots))
+	for id := range snapshots {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,516 @@
+// Package declarative converts the routing configuration to and from a
+// document that references entities by name instead of ID, so that it can
+// be kept in version control and applied as a desired state.
+package declarative
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalid is wrapped by errors of documents that cannot be applied
+var ErrInvalid = errors.New("invalid document")
+
+// Document is the routing configuration
+type Document struct {
+	Backends          []Backend          `yaml:"backends" json:"backends"`
+	BackendSets       []BackendSet       `yaml:"backend_sets" json:"backend_sets"`
+	SourceDefinitions []SourceDefinition `yaml:"source_definitions" json:"source_definitions"`
+	Rules             []Rule             `yaml:"rules" json:"rules"`
+}
+
+// Backend is a backend with its addresses. Admin states are operational and
+// not part of the document.
+type Backend struct {
+	Name                       string    `yaml:"name" json:"name"`
+	Description                string    `yaml:"description,omitempty" json:"description,omitempty"`
+	HealthCheckInterval        int       `yaml:"health_check_interval,omitempty" json:"health_check_interval,omitempty"`
+	HealthCheckTimeout         int       `yaml:"health_check_timeout,omitempty" json:"health_check_timeout,omitempty"`
+	HealthCheckSourceAddress   string    `yaml:"health_check_source_address,omitempty" json:"health_check_source_address,omitempty"`
+	HealthCheckSourceInterface string    `yaml:"health_check_source_interface,omitempty" json:"health_check_source_interface,omitempty"`
+	HealthCheckType            string    `yaml:"health_check_type,omitempty" json:"health_check_type,omitempty"`
+	HealthCheckCommand         string    `yaml:"health_check_command,omitempty" json:"health_check_command,omitempty"`
+	HealthCheckArgs            []string  `yaml:"health_check_args,omitempty" json:"health_check_args,omitempty"`
+	Addresses                  []Address `yaml:"addresses,omitempty" json:"addresses,omitempty"`
+}
+
+// Address is an address of a backend
+type Address struct {
+	IP   string `yaml:"ip" json:"ip"`
+	Port int    `yaml:"port" json:"port"`
+}
+
+// BackendSet is a backend set with the names of its backends
+type BackendSet struct {
+	Name        string   `yaml:"name" json:"name"`
+	Description string   `yaml:"description,omitempty" json:"description,omitempty"`
+	Backends    []string `yaml:"backends" json:"backends"`
+}
+
+// SourceDefinition is a source definition
+type SourceDefinition struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Type        string `yaml:"type" json:"type"`
+	IPAddress   string `yaml:"ip_address,omitempty" json:"ip_address,omitempty"`
+	Subnet      string `yaml:"subnet,omitempty" json:"subnet,omitempty"`
+	RangeStart  string `yaml:"range_start,omitempty" json:"range_start,omitempty"`
+	RangeEnd    string `yaml:"range_end,omitempty" json:"range_end,omitempty"`
+	Partner     string `yaml:"partner,omitempty" json:"partner,omitempty"`
+}
+
+// Rule is a rule referencing its source definition and backend set by
+// name. Rules have no name and are identified by their source, protocol,
+// destination port, node scope and priority. Several rules may share all
+// but the priority, e.g. a disabled rule and a fallback with a lower
+// priority.
+type Rule struct {
+	Source          string `yaml:"source" json:"source"`
+	Protocol        string `yaml:"protocol" json:"protocol"`
+	DestinationPort int    `yaml:"destination_port" json:"destination_port"`
+	Priority        int    `yaml:"priority" json:"priority"`
+	BackendSet      string `yaml:"backend_set" json:"backend_set"`
+	// Enabled defaults to true
+	Enabled *bool `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	// Nodes and NodeGroups restrict the rule to ingress nodes
+	Nodes      []string `yaml:"nodes,omitempty" json:"nodes,omitempty"`
+	NodeGroups []string `yaml:"node_groups,omitempty" json:"node_groups,omitempty"`
+}
+
+// Key identifies the traffic of a rule: its source, protocol, destination
+// port and node scope. Rules scoped to different nodes are different rules.
+func (r Rule) Key() string {
+	key := fmt.Sprintf("%s %s/%d", r.Source, r.Protocol, r.DestinationPort)
+	if len(r.Nodes) != 0 {
+		key += " nodes " + strings.Join(sortedNames(r.Nodes), ",")
+	}
+	if len(r.NodeGroups) != 0 {
+		key += " node groups " + strings.Join(sortedNames(r.NodeGroups), ",")
+	}
+	return key
+}
+
+// PriorityKey identifies a rule by its key and priority
+func (r Rule) PriorityKey() string {
+	return fmt.Sprintf("%s priority %d", r.Key(), r.Priority)
+}
+
+// RuleNames returns the names of rules in changes: their key, or their
+// key and priority if other rules share the key
+func RuleNames(rules []Rule) []string {
+	counts := make(map[string]int)
+	for _, rule := range rules {
+		counts[rule.Key()]++
+	}
+
+	names := make([]string, len(rules))
+	for i, rule := range rules {
+		names[i] = rule.Key()
+		if counts[rule.Key()] > 1 {
+			names[i] = rule.PriorityKey()
+		}
+	}
+	return names
+}
+
+// matchRules pairs rules with the current rules they replace and returns
+// the index of the current rule of each rule, or -1 for new rules. Rules
+// are paired by their key and priority first; a remaining rule replaces
+// the only remaining current rule of its key, which changes its priority.
+func matchRules(current, rules []Rule) []int {
+	byPriorityKey := make(map[string]int)
+	for i, rule := range current {
+		byPriorityKey[rule.PriorityKey()] = i
+	}
+
+	matches := make([]int, len(rules))
+	matched := make([]bool, len(current))
+	for i, rule := range rules {
+		matches[i] = -1
+		if j, ok := byPriorityKey[rule.PriorityKey()]; ok && !matched[j] {
+			matches[i] = j
+			matched[j] = true
+		}
+	}
+
+	remainingCurrent := make(map[string][]int)
+	for j, rule := range current {
+		if !matched[j] {
+			remainingCurrent[rule.Key()] = append(remainingCurrent[rule.Key()], j)
+		}
+	}
+	remaining := make(map[string][]int)
+	for i, rule := range rules {
+		if matches[i] < 0 {
+			remaining[rule.Key()] = append(remaining[rule.Key()], i)
+		}
+	}
+	for key, indexes := range remaining {
+		if len(indexes) == 1 && len(remainingCurrent[key]) == 1 {
+			matches[indexes[0]] = remainingCurrent[key][0]
+		}
+	}
+
+	return matches
+}
+
+// sortedNames returns a sorted copy of names, or nil if there are none
+func sortedNames(names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// IsEnabled reports whether a rule is enabled
+func (r Rule) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// State is the stored routing configuration. Backends include their
+// addresses and backend sets their backends.
+type State struct {
+	Backends          []models.Backend
+	BackendSets       []models.BackendSet
+	SourceDefinitions []models.SourceDefinition
+	Rules             []models.Rule
+}
+
+// Export converts the stored routing configuration to a document
+func Export(state State) *Document {
+	doc := &Document{
+		Backends:          []Backend{},
+		BackendSets:       []BackendSet{},
+		SourceDefinitions: []SourceDefinition{},
+		Rules:             []Rule{},
+	}
+
+	for _, backend := range state.Backends {
+		doc.Backends = append(doc.Backends, FromBackend(backend))
+	}
+
+	backendSetNames := make(map[uint]string)
+	for _, backendSet := range state.BackendSets {
+		backendSetNames[backendSet.ID] = backendSet.Name
+		entry := BackendSet{Name: backendSet.Name, Description: backendSet.Description, Backends: []string{}}
+		for _, backend := range backendSet.Backends {
+			entry.Backends = append(entry.Backends, backend.Name)
+		}
+		doc.BackendSets = append(doc.BackendSets, entry)
+	}
+
+	sourceNames := make(map[uint]string)
+	for _, sourceDefinition := range state.SourceDefinitions {
+		sourceNames[sourceDefinition.ID] = sourceDefinition.Name
+		doc.SourceDefinitions = append(doc.SourceDefinitions, FromSourceDefinition(sourceDefinition))
+	}
+
+	for _, rule := range state.Rules {
+		enabled := rule.Enabled
+		doc.Rules = append(doc.Rules, Rule{
+			Source:          sourceNames[rule.SourceDefinitionID],
+			Protocol:        rule.Protocol,
+			DestinationPort: rule.DestinationPort,
+			Priority:        rule.Priority,
+			BackendSet:      backendSetNames[rule.BackendSetID],
+			Enabled:         &enabled,
+			Nodes:           rule.Nodes,
+			NodeGroups:      rule.NodeGroups,
+		})
+	}
+
+	doc.normalize()
+	return doc
+}
+
+// FromBackend converts a backend with its addresses
+func FromBackend(backend models.Backend) Backend {
+	entry := Backend{
+		Name:                       backend.Name,
+		Description:                backend.Description,
+		HealthCheckInterval:        backend.HealthCheckInterval,
+		HealthCheckTimeout:         backend.HealthCheckTimeout,
+		HealthCheckSourceAddress:   backend.HealthCheckSourceAddress,
+		HealthCheckSourceInterface: backend.HealthCheckSourceInterface,
+		HealthCheckType:            backend.HealthCheckType,
+		HealthCheckCommand:         backend.HealthCheckCommand,
+		HealthCheckArgs:            backend.HealthCheckArgs,
+	}
+	for _, address := range backend.Addresses {
+		entry.Addresses = append(entry.Addresses, Address{IP: address.IP, Port: address.Port})
+	}
+	return entry
+}
+
+// Apply sets the fields of a backend, except for its addresses
+func (b Backend) Apply(backend *models.Backend) {
+	backend.Name = b.Name
+	backend.Description = b.Description
+	backend.HealthCheckInterval = b.HealthCheckInterval
+	backend.HealthCheckTimeout = b.HealthCheckTimeout
+	backend.HealthCheckSourceAddress = b.HealthCheckSourceAddress
+	backend.HealthCheckSourceInterface = b.HealthCheckSourceInterface
+	backend.HealthCheckType = b.HealthCheckType
+	backend.HealthCheckCommand = b.HealthCheckCommand
+	backend.HealthCheckArgs = b.HealthCheckArgs
+}
+
+// FromSourceDefinition converts a source definition
+func FromSourceDefinition(sourceDefinition models.SourceDefinition) SourceDefinition {
+	return SourceDefinition{
+		Name:        sourceDefinition.Name,
+		Description: sourceDefinition.Description,
+		Type:        sourceDefinition.Type,
+		IPAddress:   sourceDefinition.IPAddress,
+		Subnet:      sourceDefinition.Subnet,
+		RangeStart:  sourceDefinition.RangeStart,
+		RangeEnd:    sourceDefinition.RangeEnd,
+		Partner:     sourceDefinition.Partner,
+	}
+}
+
+// Apply sets the fields of a source definition
+func (s SourceDefinition) Apply(sourceDefinition *models.SourceDefinition) {
+	sourceDefinition.Name = s.Name
+	sourceDefinition.Description = s.Description
+	sourceDefinition.Type = s.Type
+	sourceDefinition.IPAddress = s.IPAddress
+	sourceDefinition.Subnet = s.Subnet
+	sourceDefinition.RangeStart = s.RangeStart
+	sourceDefinition.RangeEnd = s.RangeEnd
+	sourceDefinition.Partner = s.Partner
+}
+
+// Parse reads a YAML or JSON document. Unknown fields are rejected.
+func Parse(data []byte) (*Document, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var doc Document
+	if err := decoder.Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("the document is empty")
+		}
+		return nil, err
+	}
+
+	doc.normalize()
+	if err := doc.validateEntries(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return &doc, nil
+}
+
+// Marshal writes a document as YAML or JSON
+func Marshal(doc *Document, format string) ([]byte, error) {
+	switch format {
+	case "yaml":
+		return yaml.Marshal(doc)
+	case "json":
+		return json.MarshalIndent(doc, "", "  ")
+	default:
+		return nil, fmt.Errorf("unsupported format %s", format)
+	}
+}
+
+// normalize fills in defaults and sorts all entries, so that equal
+// configurations result in equal documents
+func (d *Document) normalize() {
+	for i := range d.Backends {
+		backend := &d.Backends[i]
+		if backend.HealthCheckType == "" {
+			backend.HealthCheckType = "tcp"
+		}
+		if len(backend.HealthCheckArgs) == 0 {
+			backend.HealthCheckArgs = nil
+		}
+		if len(backend.Addresses) == 0 {
+			backend.Addresses = nil
+		}
+		sort.Slice(backend.Addresses, func(a, b int) bool {
+			if backend.Addresses[a].IP != backend.Addresses[b].IP {
+				return backend.Addresses[a].IP < backend.Addresses[b].IP
+			}
+			return backend.Addresses[a].Port < backend.Addresses[b].Port
+		})
+	}
+	sort.Slice(d.Backends, func(a, b int) bool { return d.Backends[a].Name < d.Backends[b].Name })
+
+	for i := range d.BackendSets {
+		if d.BackendSets[i].Backends == nil {
+			d.BackendSets[i].Backends = []string{}
+		}
+		sort.Strings(d.BackendSets[i].Backends)
+	}
+	sort.Slice(d.BackendSets, func(a, b int) bool { return d.BackendSets[a].Name < d.BackendSets[b].Name })
+
+	sort.Slice(d.SourceDefinitions, func(a, b int) bool { return d.SourceDefinitions[a].Name < d.SourceDefinitions[b].Name })
+
+	for i := range d.Rules {
+		if d.Rules[i].Enabled == nil {
+			enabled := true
+			d.Rules[i].Enabled = &enabled
+		}
+		d.Rules[i].Nodes = sortedNames(d.Rules[i].Nodes)
+		d.Rules[i].NodeGroups = sortedNames(d.Rules[i].NodeGroups)
+	}
+	sort.Slice(d.Rules, func(a, b int) bool {
+		ra, rb := d.Rules[a], d.Rules[b]
+		if ra.Source != rb.Source {
+			return ra.Source < rb.Source
+		}
+		if ra.Protocol != rb.Protocol {
+			return ra.Protocol < rb.Protocol
+		}
+		if ra.DestinationPort != rb.DestinationPort {
+			return ra.DestinationPort < rb.DestinationPort
+		}
+		if ra.Priority != rb.Priority {
+			return ra.Priority > rb.Priority
+		}
+		return ra.Key() < rb.Key()
+	})
+}
+
+// Validate checks the entries of a document and that the names they
+// reference are defined in it
+func (d *Document) Validate() error {
+	if err := d.validateEntries(); err != nil {
+		return err
+	}
+
+	backends := make(map[string]bool)
+	for _, backend := range d.Backends {
+		backends[backend.Name] = true
+	}
+	backendSets := make(map[string]bool)
+	for _, backendSet := range d.BackendSets {
+		backendSets[backendSet.Name] = true
+		for _, name := range backendSet.Backends {
+			if !backends[name] {
+				return fmt.Errorf("backend set %s: unknown backend %s", backendSet.Name, name)
+			}
+		}
+	}
+	sources := make(map[string]bool)
+	for _, source := range d.SourceDefinitions {
+		sources[source.Name] = true
+	}
+	for _, rule := range d.Rules {
+		if !sources[rule.Source] {
+			return fmt.Errorf("rule %s: unknown source definition %s", rule.Key(), rule.Source)
+		}
+		if !backendSets[rule.BackendSet] {
+			return fmt.Errorf("rule %s: unknown backend set %s", rule.Key(), rule.BackendSet)
+		}
+	}
+
+	return nil
+}
+
+// validateEntries checks the entries of a document on their own
+func (d *Document) validateEntries() error {
+	backends := make(map[string]bool)
+	for _, backend := range d.Backends {
+		if backend.Name == "" {
+			return errors.New("backend without a name")
+		}
+		if backends[backend.Name] {
+			return fmt.Errorf("duplicate backend %s", backend.Name)
+		}
+		backends[backend.Name] = true
+
+		if backend.HealthCheckType != "tcp" && backend.HealthCheckType != "exec" {
+			return fmt.Errorf("backend %s: health_check_type must be tcp or exec", backend.Name)
+		}
+		if backend.HealthCheckType == "exec" && backend.HealthCheckCommand == "" {
+			return fmt.Errorf("backend %s: exec health checks require a health_check_command", backend.Name)
+		}
+
+		addresses := make(map[Address]bool)
+		for _, address := range backend.Addresses {
+			if net.ParseIP(address.IP) == nil {
+				return fmt.Errorf("backend %s: invalid address %s", backend.Name, address.IP)
+			}
+			if address.Port < 1 || address.Port > 65535 {
+				return fmt.Errorf("backend %s: invalid port %d", backend.Name, address.Port)
+			}
+			if addresses[address] {
+				return fmt.Errorf("backend %s: duplicate address %s:%d", backend.Name, address.IP, address.Port)
+			}
+			addresses[address] = true
+		}
+	}
+
+	backendSets := make(map[string]bool)
+	for _, backendSet := range d.BackendSets {
+		if backendSet.Name == "" {
+			return errors.New("backend set without a name")
+		}
+		if backendSets[backendSet.Name] {
+			return fmt.Errorf("duplicate backend set %s", backendSet.Name)
+		}
+		backendSets[backendSet.Name] = true
+
+		for i, name := range backendSet.Backends {
+			if i > 0 && backendSet.Backends[i-1] == name {
+				return fmt.Errorf("backend set %s: duplicate backend %s", backendSet.Name, name)
+			}
+		}
+	}
+
+	sources := make(map[string]bool)
+	for _, source := range d.SourceDefinitions {
+		if source.Name == "" {
+			return errors.New("source definition without a name")
+		}
+		if sources[source.Name] {
+			return fmt.Errorf("duplicate source definition %s", source.Name)
+		}
+		sources[source.Name] = true
+
+		var model models.SourceDefinition
+		source.Apply(&model)
+		if !model.Validate() {
+			return fmt.Errorf("source definition %s: invalid %s", source.Name, source.Type)
+		}
+	}
+
+	rules := make(map[string]bool)
+	for _, rule := range d.Rules {
+		if rule.Source == "" || rule.BackendSet == "" {
+			return fmt.Errorf("rule %s: source and backend_set are required", rule.Key())
+		}
+		if rule.Protocol != "tcp" && rule.Protocol != "udp" && rule.Protocol != "all" {
+			return fmt.Errorf("rule %s: protocol must be tcp, udp or all", rule.Key())
+		}
+		if rule.DestinationPort < 1 || rule.DestinationPort > 65535 {
+			return fmt.Errorf("rule %s: invalid destination port", rule.Key())
+		}
+		for _, names := range [][]string{rule.Nodes, rule.NodeGroups} {
+			for _, name := range names {
+				if name == "" {
+					return fmt.Errorf("rule %s: node names and groups must not be empty", rule.Key())
+				}
+			}
+		}
+		if rules[rule.PriorityKey()] {
+			return fmt.Errorf("duplicate rule %s", rule.PriorityKey())
+		}
+		rules[rule.PriorityKey()] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package declarative
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Actions of changes
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Entity types of changes
+const (
+	EntityBackend          = "backend"
+	EntityBackendSet       = "backend_set"
+	EntitySourceDefinition = "source_definition"
+	EntityRule             = "rule"
+)
+
+// Change is a change needed to reach the desired state. Before and After
+// are entries of the document, address changes are backend updates.
+type Change struct {
+	Action     string      `json:"action" yaml:"action"`
+	EntityType string      `json:"entity_type" yaml:"entity_type"`
+	Name       string      `json:"name" yaml:"name"`
+	Before     interface{} `json:"before,omitempty" yaml:"before,omitempty"`
+	After      interface{} `json:"after,omitempty" yaml:"after,omitempty"`
+}
+
+// Desired returns the desired state of importing a document. Entries of
+// the current state missing from the document are kept unless prune is
+// set. The result is validated.
+func Desired(current, doc *Document, prune bool) (*Document, error) {
+	desired := &Document{
+		Backends:          append([]Backend{}, doc.Backends...),
+		BackendSets:       append([]BackendSet{}, doc.BackendSets...),
+		SourceDefinitions: append([]SourceDefinition{}, doc.SourceDefinitions...),
+		Rules:             append([]Rule{}, doc.Rules...),
+	}
+
+	if !prune {
+		backends := make(map[string]bool)
+		for _, backend := range doc.Backends {
+			backends[backend.Name] = true
+		}
+		for _, backend := range current.Backends {
+			if !backends[backend.Name] {
+				desired.Backends = append(desired.Backends, backend)
+			}
+		}
+
+		backendSets := make(map[string]bool)
+		for _, backendSet := range doc.BackendSets {
+			backendSets[backendSet.Name] = true
+		}
+		for _, backendSet := range current.BackendSets {
+			if !backendSets[backendSet.Name] {
+				desired.BackendSets = append(desired.BackendSets, backendSet)
+			}
+		}
+
+		sources := make(map[string]bool)
+		for _, source := range doc.SourceDefinitions {
+			sources[source.Name] = true
+		}
+		for _, source := range current.SourceDefinitions {
+			if !sources[source.Name] {
+				desired.SourceDefinitions = append(desired.SourceDefinitions, source)
+			}
+		}
+
+		replaced := make([]bool, len(current.Rules))
+		for _, j := range matchRules(current.Rules, doc.Rules) {
+			if j >= 0 {
+				replaced[j] = true
+			}
+		}
+		for i, rule := range current.Rules {
+			if !replaced[i] {
+				desired.Rules = append(desired.Rules, rule)
+			}
+		}
+	}
+
+	desired.normalize()
+	if err := desired.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return desired, nil
+}
+
+// Diff returns the changes from the current to the desired state in the
+// order they can be applied: creations and updates with dependencies
+// first, then deletions with dependents first
+func Diff(current, desired *Document) []Change {
+	var changes []Change
+
+	currentBackends := make(map[string]Backend)
+	for _, backend := range current.Backends {
+		currentBackends[backend.Name] = backend
+	}
+	desiredBackends := make(map[string]bool)
+	for _, backend := range desired.Backends {
+		desiredBackends[backend.Name] = true
+		changes = appendChange(changes, EntityBackend, backend.Name, currentBackends[backend.Name], backend, hasKey(currentBackends, backend.Name))
+	}
+
+	currentBackendSets := make(map[string]BackendSet)
+	for _, backendSet := range current.BackendSets {
+		currentBackendSets[backendSet.Name] = backendSet
+	}
+	desiredBackendSets := make(map[string]bool)
+	for _, backendSet := range desired.BackendSets {
+		desiredBackendSets[backendSet.Name] = true
+		changes = appendChange(changes, EntityBackendSet, backendSet.Name, currentBackendSets[backendSet.Name], backendSet, hasKey(currentBackendSets, backendSet.Name))
+	}
+
+	currentSources := make(map[string]SourceDefinition)
+	for _, source := range current.SourceDefinitions {
+		currentSources[source.Name] = source
+	}
+	desiredSources := make(map[string]bool)
+	for _, source := range desired.SourceDefinitions {
+		desiredSources[source.Name] = true
+		changes = appendChange(changes, EntitySourceDefinition, source.Name, currentSources[source.Name], source, hasKey(currentSources, source.Name))
+	}
+
+	currentRuleNames := RuleNames(current.Rules)
+	desiredRuleNames := RuleNames(desired.Rules)
+	desiredRules := make([]bool, len(current.Rules))
+	for i, j := range matchRules(current.Rules, desired.Rules) {
+		if j < 0 {
+			changes = appendChange(changes, EntityRule, desiredRuleNames[i], nil, desired.Rules[i], false)
+			continue
+		}
+		desiredRules[j] = true
+		changes = appendChange(changes, EntityRule, desiredRuleNames[i], current.Rules[j], desired.Rules[i], true)
+	}
+
+	for i, rule := range current.Rules {
+		if !desiredRules[i] {
+			changes = append(changes, Change{Action: ActionDelete, EntityType: EntityRule, Name: currentRuleNames[i], Before: rule})
+		}
+	}
+	for _, source := range current.SourceDefinitions {
+		if !desiredSources[source.Name] {
+			changes = append(changes, Change{Action: ActionDelete, EntityType: EntitySourceDefinition, Name: source.Name, Before: source})
+		}
+	}
+	for _, backendSet := range current.BackendSets {
+		if !desiredBackendSets[backendSet.Name] {
+			changes = append(changes, Change{Action: ActionDelete, EntityType: EntityBackendSet, Name: backendSet.Name, Before: backendSet})
+		}
+	}
+	for _, backend := range current.Backends {
+		if !desiredBackends[backend.Name] {
+			changes = append(changes, Change{Action: ActionDelete, EntityType: EntityBackend, Name: backend.Name, Before: backend})
+		}
+	}
+
+	return changes
+}
+
+// appendChange appends the creation or update of an entry if it differs
+// from the current one
+func appendChange(changes []Change, entityType, name string, before, after interface{}, exists bool) []Change {
+	if !exists {
+		return append(changes, Change{Action: ActionCreate, EntityType: entityType, Name: name, After: after})
+	}
+	if reflect.DeepEqual(before, after) {
+		return changes
+	}
+	return append(changes, Change{Action: ActionUpdate, EntityType: entityType, Name: name, Before: before, After: after})
+}
+
+// hasKey reports whether a map contains a key
+func hasKey[T any](entries map[string]T, key string) bool {
+	_, ok := entries[key]
+	return ok
+}
@@ -0,0 +1,337 @@
+package declarative
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+)
+
+const currentYAML = `
+backends:
+  - name: web1
+    addresses:
+      - {ip: 10.0.0.1, port: 80}
+  - name: web2
+    addresses:
+      - {ip: 10.0.0.2, port: 80}
+backend_sets:
+  - name: web
+    backends: [web1, web2]
+source_definitions:
+  - {name: acme, type: subnet, subnet: 192.168.0.0/24}
+  - {name: globex, type: ip, ip_address: 172.16.0.1}
+rules:
+  - {source: acme, protocol: tcp, destination_port: 443, priority: 20, backend_set: web}
+  - {source: globex, protocol: tcp, destination_port: 443, priority: 20, backend_set: web}
+`
+
+func parse(t *testing.T, data string) *Document {
+	t.Helper()
+	doc, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return doc
+}
+
+// summarize formats changes without their entries
+func summarize(changes []Change) []string {
+	summary := []string{}
+	for _, change := range changes {
+		summary = append(summary, change.Action+" "+change.EntityType+" "+change.Name)
+	}
+	return summary
+}
+
+func ruleKeys(doc *Document) []string {
+	keys := []string{}
+	for _, rule := range doc.Rules {
+		keys = append(keys, rule.Key())
+	}
+	return keys
+}
+
+func TestDesired(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		prune   bool
+		want    []string
+		invalid bool
+	}{
+		{
+			name:  "entries missing from the document are kept",
+			doc:   "rules:\n  - {source: acme, protocol: udp, destination_port: 53, priority: 10, backend_set: web}\n",
+			prune: false,
+			want:  []string{"acme tcp/443", "acme udp/53", "globex tcp/443"},
+		},
+		{
+			name:  "entries missing from the document are pruned",
+			doc:   "rules:\n  - {source: acme, protocol: udp, destination_port: 53, priority: 10, backend_set: web}\n",
+			prune: true,
+			// The rule references entries that are pruned
+			invalid: true,
+		},
+		{
+			name:  "rules of the document replace rules with their key",
+			doc:   "rules:\n  - {source: acme, protocol: tcp, destination_port: 443, priority: 50, backend_set: web}\n",
+			prune: false,
+			want:  []string{"acme tcp/443", "globex tcp/443"},
+		},
+		{
+			name:    "unknown backend set",
+			doc:     "rules:\n  - {source: acme, protocol: tcp, destination_port: 80, priority: 10, backend_set: api}\n",
+			prune:   false,
+			invalid: true,
+		},
+		{
+			name:  "scoped rules are different rules",
+			doc:   "rules:\n  - {source: acme, protocol: tcp, destination_port: 443, priority: 20, backend_set: web, node_groups: [site-a]}\n",
+			prune: false,
+			want:  []string{"acme tcp/443", "acme tcp/443 node groups site-a", "globex tcp/443"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			desired, err := Desired(parse(t, currentYAML), parse(t, test.doc), test.prune)
+			if test.invalid {
+				if !errors.Is(err, ErrInvalid) {
+					t.Fatalf("Desired() error = %v, want ErrInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Desired() error = %v", err)
+			}
+			if got := ruleKeys(desired); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Desired() rules = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDesiredUpdatesPriority(t *testing.T) {
+	doc := parse(t, "rules:\n  - {source: acme, protocol: tcp, destination_port: 443, priority: 50, backend_set: web}\n")
+	desired, err := Desired(parse(t, currentYAML), doc, false)
+	if err != nil {
+		t.Fatalf("Desired() error = %v", err)
+	}
+	if desired.Rules[0].Key() != "acme tcp/443" || desired.Rules[0].Priority != 50 {
+		t.Errorf("Desired() rule = %+v, want acme tcp/443 with priority 50", desired.Rules[0])
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		desired string
+		want    []string
+	}{
+		{
+			name:    "no changes",
+			desired: currentYAML,
+			want:    []string{},
+		},
+		{
+			name: "creations with dependencies first, deletions with dependents first",
+			desired: `
+backends:
+  - name: api1
+    addresses:
+      - {ip: 10.0.1.1, port: 8080}
+backend_sets:
+  - name: api
+    backends: [api1]
+source_definitions:
+  - {name: acme, type: subnet, subnet: 192.168.0.0/24}
+rules:
+  - {source: acme, protocol: tcp, destination_port: 443, priority: 20, backend_set: api}
+`,
+			want: []string{
+				"create backend api1",
+				"create backend_set api",
+				"update rule acme tcp/443",
+				"delete rule globex tcp/443",
+				"delete source_definition globex",
+				"delete backend_set web",
+				"delete backend web1",
+				"delete backend web2",
+			},
+		},
+		{
+			name: "address and priority changes are updates",
+			desired: `
+backends:
+  - name: web1
+    addresses:
+      - {ip: 10.0.0.1, port: 80}
+      - {ip: 10.0.0.3, port: 80}
+  - name: web2
+    addresses:
+      - {ip: 10.0.0.2, port: 80}
+backend_sets:
+  - name: web
+    backends: [web1, web2]
+source_definitions:
+  - {name: acme, type: subnet, subnet: 192.168.0.0/24}
+  - {name: globex, type: ip, ip_address: 172.16.0.1}
+rules:
+  - {source: acme, protocol: tcp, destination_port: 443, priority: 30, backend_set: web}
+  - {source: globex, protocol: tcp, destination_port: 443, priority: 20, backend_set: web}
+`,
+			want: []string{"update backend web1", "update rule acme tcp/443"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := summarize(Diff(parse(t, currentYAML), parse(t, test.desired)))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Diff() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// fallbackYAML has a disabled rule and an enabled fallback with a lower
+// priority, which matches instead of it
+const fallbackYAML = `
+backends:
+  - name: web1
+  - name: web2
+backend_sets:
+  - {name: primary, backends: [web1]}
+  - {name: fallback, backends: [web2]}
+source_definitions:
+  - {name: acme, type: subnet, subnet: 192.168.0.0/24}
+rules:
+  - {source: acme, protocol: tcp, destination_port: 443, priority: 10, backend_set: primary, enabled: false}
+  - {source: acme, protocol: tcp, destination_port: 443, priority: 5, backend_set: fallback}
+`
+
+func TestExportFallbackRules(t *testing.T) {
+	source := models.SourceDefinition{Name: "acme", Type: "subnet", Subnet: "192.168.0.0/24"}
+	source.ID = 1
+	primary := models.BackendSet{Name: "primary"}
+	primary.ID = 1
+	fallback := models.BackendSet{Name: "fallback"}
+	fallback.ID = 2
+
+	doc := Export(State{
+		BackendSets:       []models.BackendSet{primary, fallback},
+		SourceDefinitions: []models.SourceDefinition{source},
+		Rules: []models.Rule{
+			{SourceDefinitionID: 1, BackendSetID: 1, Protocol: "tcp", DestinationPort: 443, Priority: 10, Enabled: false},
+			{SourceDefinitionID: 1, BackendSetID: 2, Protocol: "tcp", DestinationPort: 443, Priority: 5, Enabled: true},
+		},
+	})
+
+	got := []string{}
+	for _, rule := range doc.Rules {
+		got = append(got, fmt.Sprintf("%s %s %v", rule.PriorityKey(), rule.BackendSet, rule.IsEnabled()))
+	}
+	want := []string{"acme tcp/443 priority 10 primary false", "acme tcp/443 priority 5 fallback true"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Export() rules = %v, want %v", got, want)
+	}
+}
+
+func TestDiffFallbackRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		prune bool
+		want  []string
+	}{
+		{
+			name:  "empty document",
+			doc:   "rules: []\n",
+			prune: false,
+			want:  []string{},
+		},
+		{
+			name:  "unchanged document",
+			doc:   fallbackYAML,
+			prune: true,
+			want:  []string{},
+		},
+		{
+			name:  "enabling the primary rule",
+			doc:   "rules:\n  - {source: acme, protocol: tcp, destination_port: 443, priority: 10, backend_set: primary}\n",
+			prune: false,
+			want:  []string{"update rule acme tcp/443 priority 10"},
+		},
+		{
+			name:  "another priority is a new rule",
+			doc:   "rules:\n  - {source: acme, protocol: tcp, destination_port: 443, priority: 20, backend_set: primary}\n",
+			prune: false,
+			want:  []string{"create rule acme tcp/443 priority 20"},
+		},
+		{
+			name: "removing the fallback",
+			doc: `
+backends:
+  - name: web1
+backend_sets:
+  - {name: primary, backends: [web1]}
+source_definitions:
+  - {name: acme, type: subnet, subnet: 192.168.0.0/24}
+rules:
+  - {source: acme, protocol: tcp, destination_port: 443, priority: 10, backend_set: primary}
+`,
+			prune: true,
+			want: []string{
+				"update rule acme tcp/443",
+				"delete rule acme tcp/443 priority 5",
+				"delete backend_set fallback",
+				"delete backend web2",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := parse(t, fallbackYAML)
+			desired, err := Desired(current, parse(t, test.doc), test.prune)
+			if err != nil {
+				t.Fatalf("Desired() error = %v", err)
+			}
+			if got := summarize(Diff(current, desired)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Diff() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRejectsDuplicateRules(t *testing.T) {
+	_, err := Parse([]byte(`
+rules:
+  - {source: acme, protocol: tcp, destination_port: 443, priority: 10, backend_set: web}
+  - {source: acme, protocol: tcp, destination_port: 443, priority: 10, backend_set: api}
+`))
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf("Parse() error = %v, want ErrInvalid", err)
+	}
+}
+
+func TestRuleNames(t *testing.T) {
+	rules := []Rule{
+		{Source: "acme", Protocol: "tcp", DestinationPort: 443, Priority: 10},
+		{Source: "acme", Protocol: "tcp", DestinationPort: 443, Priority: 30},
+		{Source: "acme", Protocol: "tcp", DestinationPort: 443, Priority: 30, Nodes: []string{"edge-1"}},
+		{Source: "acme", Protocol: "udp", DestinationPort: 443, Priority: 10},
+	}
+	want := []string{
+		"acme tcp/443 priority 10",
+		"acme tcp/443 priority 30",
+		"acme tcp/443 nodes edge-1",
+		"acme udp/443",
+	}
+	if got := RuleNames(rules); !reflect.DeepEqual(got, want) {
+		t.Errorf("RuleNames() = %v, want %v", got, want)
+	}
+}
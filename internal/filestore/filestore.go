@@ -0,0 +1,213 @@
+// Package filestore keeps the routing configuration in a declarative file
+// instead of managing it through the API, e.g. for small sites whose
+// configuration is kept in version control.
+package filestore
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/conflict"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/database"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/declarative"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Store applies a declarative file as the routing configuration of a
+// database and re-applies it when the file changes. Everything else, e.g.
+// availability, logs, admin states and API tokens, is kept in the database.
+// Changes of the routing configuration through the store are refused with
+// database.ErrReadOnly.
+type Store struct {
+	*database.Service
+	path     string
+	interval time.Duration
+	logger   *logrus.Logger
+	modTime  time.Time
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Config for the file store
+type Config struct {
+	// Path of the YAML or JSON file
+	Path string
+	// Interval between checks of the file for changes
+	Interval time.Duration
+}
+
+// New creates a file store and applies the file. It fails if the file
+// can not be applied.
+func New(db *database.Service, config Config, logger *logrus.Logger) (*Store, error) {
+	s := &Store{
+		Service:  db,
+		path:     config.Path,
+		interval: config.Interval,
+		logger:   logger,
+		stop:     make(chan struct{}),
+	}
+
+	if _, err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Start begins watching the file for changes
+func (s *Store) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				// A broken file keeps the last applied configuration
+				if _, err := s.reload(); err != nil {
+					s.logger.Errorf("Failed to apply %s: %v", s.path, err)
+				}
+			case <-s.stop:
+				s.logger.Info("Configuration file watcher stopped")
+				return
+			}
+		}
+	}()
+	s.logger.Infof("Watching %s for configuration changes", s.path)
+}
+
+// Stop ends watching the file
+func (s *Store) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// reload applies the file if it was modified since it was last applied.
+// It reports whether the file was applied.
+func (s *Store) reload() (bool, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, err
+	}
+	doc, err := declarative.Parse(data)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := s.Service.ImportConfiguration(doc, true, false, "file:"+s.path)
+	if err != nil {
+		return false, err
+	}
+	s.modTime = info.ModTime()
+
+	for _, ruleConflict := range result.Conflicts {
+		s.logger.Warnf("Rule conflict in %s: %s", s.path, ruleConflict.Description)
+	}
+	if result.Revision != 0 {
+		s.logger.Infof("Applied %d changes of %s as revision %d", len(result.Changes), s.path, result.Revision)
+	}
+	return true, nil
+}
+
+// readOnly returns the error of changes of the routing configuration
+func (s *Store) readOnly() error {
+	return fmt.Errorf("%w, it is managed in %s", database.ErrReadOnly, s.path)
+}
+
+// CreateBackend is refused
+func (s *Store) CreateBackend(backend *models.Backend, changedBy string) error {
+	return s.readOnly()
+}
+
+// UpdateBackend is refused
+func (s *Store) UpdateBackend(backend *models.Backend, changedBy string) error {
+	return s.readOnly()
+}
+
+// DeleteBackend is refused
+func (s *Store) DeleteBackend(id uint, changedBy string) error {
+	return s.readOnly()
+}
+
+// CreateAddress is refused
+func (s *Store) CreateAddress(backendID uint, address *models.Address, changedBy string) error {
+	return s.readOnly()
+}
+
+// UpdateAddress is refused
+func (s *Store) UpdateAddress(address *models.Address, changedBy string) error {
+	return s.readOnly()
+}
+
+// DeleteAddress is refused
+func (s *Store) DeleteAddress(id uint, changedBy string) error {
+	return s.readOnly()
+}
+
+// CreateBackendSet is refused
+func (s *Store) CreateBackendSet(backendSet *models.BackendSet, changedBy string) error {
+	return s.readOnly()
+}
+
+// UpdateBackendSet is refused
+func (s *Store) UpdateBackendSet(backendSet *models.BackendSet, changedBy string) error {
+	return s.readOnly()
+}
+
+// DeleteBackendSet is refused
+func (s *Store) DeleteBackendSet(id uint, changedBy string) error {
+	return s.readOnly()
+}
+
+// CreateSourceDefinition is refused
+func (s *Store) CreateSourceDefinition(sourceDefinition *models.SourceDefinition, changedBy string) error {
+	return s.readOnly()
+}
+
+// UpdateSourceDefinition is refused
+func (s *Store) UpdateSourceDefinition(sourceDefinition *models.SourceDefinition, changedBy string) error {
+	return s.readOnly()
+}
+
+// DeleteSourceDefinition is refused
+func (s *Store) DeleteSourceDefinition(id uint, changedBy string) error {
+	return s.readOnly()
+}
+
+// CreateRule is refused
+func (s *Store) CreateRule(rule *models.Rule, changedBy string) ([]conflict.Conflict, error) {
+	return nil, s.readOnly()
+}
+
+// UpdateRule is refused
+func (s *Store) UpdateRule(rule *models.Rule, changedBy string) ([]conflict.Conflict, error) {
+	return nil, s.readOnly()
+}
+
+// DeleteRule is refused
+func (s *Store) DeleteRule(id uint, changedBy string) error {
+	return s.readOnly()
+}
+
+// RestoreRevision is refused, the file would be applied again anyway
+func (s *Store) RestoreRevision(id uint, changedBy string) (*models.Revision, error) {
+	return nil, s.readOnly()
+}
+
+// ImportConfiguration is refused
+func (s *Store) ImportConfiguration(doc *declarative.Document, prune, dryRun bool, changedBy string) (*database.ImportResult, error) {
+	return nil, s.readOnly()
+}
@@ -0,0 +1,205 @@
+package filestore
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/database"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/declarative"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+const configYAML = `
+backends:
+  - name: web1
+    addresses:
+      - {ip: 10.0.0.1, port: 80}
+backend_sets:
+  - {name: web, backends: [web1]}
+source_definitions:
+  - {name: acme, type: subnet, subnet: 192.168.0.0/24}
+rules:
+  - {source: acme, protocol: tcp, destination_port: 443, priority: 10, backend_set: web}
+`
+
+// newTestStore applies configYAML to an in-memory database
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	db, err := database.NewService(database.Config{Driver: database.DriverSQLite, Path: ":memory:"}, logger)
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "routing.yaml")
+	writeFile(t, path, configYAML, time.Now().Add(-time.Hour))
+
+	s, err := New(db, Config{Path: path, Interval: time.Minute}, logger)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return s
+}
+
+// writeFile writes a file with the given modification time
+func writeFile(t *testing.T, path, data string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func backendNames(t *testing.T, s *Store) []string {
+	t.Helper()
+	doc, err := s.ExportConfiguration()
+	if err != nil {
+		t.Fatalf("ExportConfiguration() error = %v", err)
+	}
+	names := []string{}
+	for _, backend := range doc.Backends {
+		names = append(names, backend.Name)
+	}
+	return names
+}
+
+func TestReloadUnchangedFile(t *testing.T) {
+	s := newTestStore(t)
+
+	// A backend the file does not list would be pruned by an import
+	if err := s.Service.CreateBackend(&models.Backend{Name: "web2"}, "test"); err != nil {
+		t.Fatalf("CreateBackend() error = %v", err)
+	}
+
+	applied, err := s.reload()
+	if err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	if applied {
+		t.Error("reload() applied an unchanged file")
+	}
+	if names := backendNames(t, s); len(names) != 2 {
+		t.Errorf("backends = %v, want web1 and web2", names)
+	}
+}
+
+func TestReloadChangedFile(t *testing.T) {
+	s := newTestStore(t)
+
+	writeFile(t, s.path, `
+backends:
+  - name: web2
+`, time.Now())
+
+	applied, err := s.reload()
+	if err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	if !applied {
+		t.Error("reload() did not apply a changed file")
+	}
+	if names := backendNames(t, s); len(names) != 1 || names[0] != "web2" {
+		t.Errorf("backends = %v, want web2", names)
+	}
+}
+
+func TestReloadBrokenFile(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "invalid YAML", data: "backends: [\n"},
+		{name: "unknown field", data: "backends:\n  - name: web1\n    weight: 3\n"},
+		{name: "unknown reference", data: "backend_sets:\n  - {name: web, backends: [web9]}\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestStore(t)
+			modTime := s.modTime
+
+			writeFile(t, s.path, test.data, time.Now())
+			applied, err := s.reload()
+			if err == nil || applied {
+				t.Fatalf("reload() = %v, %v, want an error", applied, err)
+			}
+			if names := backendNames(t, s); len(names) != 1 || names[0] != "web1" {
+				t.Errorf("backends = %v, want the last applied web1", names)
+			}
+			if !s.modTime.Equal(modTime) {
+				t.Error("reload() recorded a broken file as applied")
+			}
+
+			// The file is applied once it is fixed
+			writeFile(t, s.path, configYAML, time.Now().Add(time.Minute))
+			if applied, err := s.reload(); err != nil || !applied {
+				t.Errorf("reload() of the fixed file = %v, %v", applied, err)
+			}
+		})
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	s := newTestStore(t)
+
+	tests := []struct {
+		name   string
+		change func() error
+	}{
+		{"CreateBackend", func() error { return s.CreateBackend(&models.Backend{Name: "web2"}, "test") }},
+		{"UpdateBackend", func() error { return s.UpdateBackend(&models.Backend{Name: "web1"}, "test") }},
+		{"DeleteBackend", func() error { return s.DeleteBackend(1, "test") }},
+		{"CreateAddress", func() error { return s.CreateAddress(1, &models.Address{IP: "10.0.0.2", Port: 80}, "test") }},
+		{"UpdateAddress", func() error { return s.UpdateAddress(&models.Address{IP: "10.0.0.2", Port: 80}, "test") }},
+		{"DeleteAddress", func() error { return s.DeleteAddress(1, "test") }},
+		{"CreateBackendSet", func() error { return s.CreateBackendSet(&models.BackendSet{Name: "api"}, "test") }},
+		{"UpdateBackendSet", func() error { return s.UpdateBackendSet(&models.BackendSet{Name: "web"}, "test") }},
+		{"DeleteBackendSet", func() error { return s.DeleteBackendSet(1, "test") }},
+		{"CreateSourceDefinition", func() error {
+			return s.CreateSourceDefinition(&models.SourceDefinition{Name: "globex", Type: "ip", IPAddress: "172.16.0.1"}, "test")
+		}},
+		{"UpdateSourceDefinition", func() error {
+			return s.UpdateSourceDefinition(&models.SourceDefinition{Name: "acme", Type: "ip", IPAddress: "172.16.0.1"}, "test")
+		}},
+		{"DeleteSourceDefinition", func() error { return s.DeleteSourceDefinition(1, "test") }},
+		{"CreateRule", func() error {
+			_, err := s.CreateRule(&models.Rule{SourceDefinitionID: 1, BackendSetID: 1, Protocol: "tcp", DestinationPort: 80}, "test")
+			return err
+		}},
+		{"UpdateRule", func() error {
+			_, err := s.UpdateRule(&models.Rule{SourceDefinitionID: 1, BackendSetID: 1, Protocol: "tcp", DestinationPort: 80}, "test")
+			return err
+		}},
+		{"DeleteRule", func() error { return s.DeleteRule(1, "test") }},
+		{"RestoreRevision", func() error {
+			_, err := s.RestoreRevision(1, "test")
+			return err
+		}},
+		{"ImportConfiguration", func() error {
+			_, err := s.ImportConfiguration(&declarative.Document{}, true, false, "test")
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.change(); !errors.Is(err, database.ErrReadOnly) {
+				t.Errorf("%s() error = %v, want ErrReadOnly", test.name, err)
+			}
+		})
+	}
+
+	if names := backendNames(t, s); len(names) != 1 || names[0] != "web1" {
+		t.Errorf("backends = %v, want the unchanged web1", names)
+	}
+}
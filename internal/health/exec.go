@@ -0,0 +1,142 @@
+package health
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// execWaitDelay is how long to wait for the output of a killed command
+const execWaitDelay = time.Second
+
+// limitedBuffer keeps the first limit bytes written to it and discards
+// the rest
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+// Write implements io.Writer
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.buf.Len(); remaining > 0 {
+		if len(p) > remaining {
+			b.buf.Write(p[:remaining])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// resolveCommand checks that command is located inside the allowed
+// directory and returns its absolute path
+func (c *Checker) resolveCommand(command string) (string, error) {
+	if c.execDir == "" {
+		return "", fmt.Errorf("exec health checks are disabled")
+	}
+	if !filepath.IsAbs(command) {
+		command = filepath.Join(c.execDir, command)
+	}
+
+	resolved, err := filepath.EvalSymlinks(command)
+	if err != nil {
+		return "", fmt.Errorf("invalid health check command: %v", err)
+	}
+
+	dir, err := filepath.EvalSymlinks(c.execDir)
+	if err != nil {
+		return "", fmt.Errorf("invalid health check command directory: %v", err)
+	}
+
+	if rel, err := filepath.Rel(dir, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("health check command %s is outside of %s", command, c.execDir)
+	}
+
+	return resolved, nil
+}
+
+// execCheck runs the backend's health check command for an address. The
+// address is passed in the B2B_CHECK_IP and B2B_CHECK_PORT environment
+// variables and replaces the {ip} and {port} placeholders in the arguments.
+// The address is available if the command exits with status 0; otherwise
+// the first line of its output is returned as the error.
+func (c *Checker) execCheck(ip string, port int, settings checkSettings) (bool, error) {
+	command, err := c.resolveCommand(settings.command)
+	if err != nil {
+		return false, err
+	}
+
+	// Limit the number of commands running at the same time
+	select {
+	case c.execSlots <- struct{}{}:
+		defer func() { <-c.execSlots }()
+	case <-c.stop:
+		return false, fmt.Errorf("health checker stopped")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), settings.timeout)
+	defer cancel()
+
+	portStr := strconv.Itoa(port)
+	args := make([]string, len(settings.args))
+	for i, arg := range settings.args {
+		args[i] = strings.NewReplacer("{ip}", ip, "{port}", portStr).Replace(arg)
+	}
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Dir = filepath.Dir(command)
+	cmd.Env = []string{
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"B2B_CHECK_IP=" + ip,
+		"B2B_CHECK_PORT=" + portStr,
+	}
+
+	// Run the command in its own process group so that the whole group
+	// can be killed on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = execWaitDelay
+
+	output := &limitedBuffer{limit: c.execOutLimit}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return false, fmt.Errorf("health check command timed out after %v", settings.timeout)
+	}
+	if err == nil {
+		return true, nil
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false, fmt.Errorf("failed to run health check command: %v", err)
+	}
+
+	if line := firstLine(output.buf.String()); line != "" {
+		return false, errors.New(line)
+	}
+	return false, fmt.Errorf("health check command failed: %v", err)
+}
+
+// firstLine returns the first non-empty line of s
+func firstLine(s string) string {
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			return line
+		}
+	}
+	return ""
+}
@@ -2,122 +2,670 @@ package health
 
 import (
 	"fmt"
+	"math/rand"
 	"net"
+	"net/netip"
+	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/sven-borkert/b2b-ingress-manager/internal/database"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/metrics"
 	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
+const (
+	// eventBufferSize is the number of availability events buffered before
+	// further events are dropped
+	eventBufferSize = 256
+
+	// scheduleResolution is how often the scheduler looks for due checks
+	scheduleResolution = time.Second
+
+	// targetRefreshInterval is how often the list of addresses to check
+	// is reloaded from the database
+	targetRefreshInterval = 15 * time.Second
+)
+
+// Event describes a change in the availability of a backend address
+type Event struct {
+	AddressID uint
+	BackendID uint
+	Available bool
+}
+
+// checkSettings describes how an address is checked
+type checkSettings struct {
+	timeout time.Duration
+	// Local address and interface the check connects from
+	sourceAddress   string
+	sourceInterface string
+	// Command run by exec checks
+	checkType string
+	command   string
+	args      []string
+}
+
+// target is a backend address with its check schedule
+type target struct {
+	address  models.Address
+	interval time.Duration
+	settings checkSettings
+	next     time.Time
+	checked  time.Time
+	running  bool
+	// Availability of the address as seen by this node
+	nodeAvailable bool
+	// Addresses in maintenance are not checked, so that planned work
+	// is not recorded as an outage
+	maintenance bool
+}
+
+// Store is the storage health checks read their targets from and record
+// their results in
+type Store interface {
+	GetAllBackends() ([]models.Backend, error)
+	LogAvailabilityChange(addressID uint, available bool, checkError string) error
+	LogNodeAvailabilityChange(nodeID, addressID uint, available bool, checkError string) error
+	GetNodeAvailabilities(nodeID uint) ([]models.NodeAvailability, error)
+	RecordHealthCheckSamples(samples []models.HealthCheckSample) error
+}
+
 // Checker handles health checks for backends
 type Checker struct {
-	db           *database.Service
+	db           Store
 	logger       *logrus.Logger
 	checkTimeout time.Duration
 	interval     time.Duration
+	sourceAddr   string
+	sourceIface  string
+	jitter       float64
+	workers      int
+	sampleEvery  time.Duration
+	execDir      string
+	execOutLimit int
+	execSlots    chan struct{}
+	events       chan Event
+	jobs         chan *target
 	stop         chan struct{}
 	wg           sync.WaitGroup
+
+	mu        sync.Mutex
+	targets   map[uint]*target
+	rng       *rand.Rand
+	heartbeat time.Time
+
+	// Health check results aggregated since sampleStart
+	samplesMu   sync.Mutex
+	samples     map[uint]*models.HealthCheckSample
+	sampleStart time.Time
+
+	// Passive detection settings and observed connection outcomes
+	passive         bool
+	passiveWindow   time.Duration
+	passiveMinFlows int
+	passiveRatio    float64
+	passiveMu       sync.Mutex
+	endpoints       map[netip.AddrPort]uint
+	pendingFlows    map[uint32]pendingFlow
+	flowStats       map[uint]*flowStats
+
+	// Reports whether this node records the cluster-wide availability
+	isLeader func() bool
+	// Node whose view of the availability is recorded, 0 for none
+	nodeID uint
 }
 
 // Config for the health checker
 type Config struct {
 	CheckTimeout time.Duration
 	Interval     time.Duration
+	// Jitter is the fraction of the interval by which each scheduled
+	// check is randomly moved, e.g. 0.1 for +/-10%
+	Jitter float64
+	// Workers limits the number of checks running at the same time
+	Workers int
+	// SampleInterval is the period over which check results and
+	// latencies are aggregated before they are stored
+	SampleInterval time.Duration
+	// SourceAddress and SourceInterface bind checks to a local address
+	// or network interface, e.g. to match the path of DNATed traffic
+	SourceAddress   string
+	SourceInterface string
+	// ExecDir is the directory exec check commands must be located in,
+	// exec checks are disabled if it is empty
+	ExecDir string
+	// ExecConcurrency limits the number of exec checks running at the
+	// same time
+	ExecConcurrency int
+	// ExecOutputLimit is the number of bytes of command output kept
+	ExecOutputLimit int
+	// Passive enables detecting failures from the conntrack events of
+	// DNATed connections. An address is marked unavailable if more than
+	// PassiveFailureRatio of at least PassiveMinFlows connections in a
+	// PassiveWindow failed.
+	Passive             bool
+	PassiveWindow       time.Duration
+	PassiveMinFlows     int
+	PassiveFailureRatio float64
+	// IsLeader reports whether this node runs the checks of its cluster.
+	// Checks always run if it is nil.
+	IsLeader func() bool
+	// NodeID records the availability as seen by this node of the node
+	// registry, so that its routing follows its own checks. Checks then
+	// run on every node; the leader also records the cluster-wide
+	// availability.
+	NodeID uint
 }
 
 // NewChecker creates a new health checker
-func NewChecker(db *database.Service, config Config, logger *logrus.Logger) *Checker {
+func NewChecker(db Store, config Config, logger *logrus.Logger) *Checker {
+	workers := config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	execConcurrency := config.ExecConcurrency
+	if execConcurrency <= 0 {
+		execConcurrency = 1
+	}
+
 	return &Checker{
 		db:           db,
 		logger:       logger,
 		checkTimeout: config.CheckTimeout,
 		interval:     config.Interval,
+		sourceAddr:   config.SourceAddress,
+		sourceIface:  config.SourceInterface,
+		jitter:       config.Jitter,
+		workers:      workers,
+		sampleEvery:  config.SampleInterval,
+		execDir:      config.ExecDir,
+		execOutLimit: config.ExecOutputLimit,
+		execSlots:    make(chan struct{}, execConcurrency),
+		events:       make(chan Event, eventBufferSize),
+		jobs:         make(chan *target),
 		stop:         make(chan struct{}),
+		targets:      make(map[uint]*target),
+		rng:          rand.New(rand.NewSource(time.Now().UnixNano())),
+		samples:      make(map[uint]*models.HealthCheckSample),
+		sampleStart:  time.Now(),
+
+		passive:         config.Passive,
+		passiveWindow:   config.PassiveWindow,
+		passiveMinFlows: config.PassiveMinFlows,
+		passiveRatio:    config.PassiveFailureRatio,
+		isLeader:        config.IsLeader,
+		nodeID:          config.NodeID,
+		endpoints:       make(map[netip.AddrPort]uint),
+		pendingFlows:    make(map[uint32]pendingFlow),
+		flowStats:       make(map[uint]*flowStats),
+	}
+}
+
+// Events returns the channel on which availability changes are published
+func (c *Checker) Events() <-chan Event {
+	return c.events
+}
+
+// notify publishes an availability change without blocking the checker.
+// Events are dropped when the buffer is full; the periodic update picks
+// up the change in that case.
+func (c *Checker) notify(address models.Address, available bool) {
+	select {
+	case c.events <- Event{AddressID: address.ID, BackendID: address.BackendID, Available: available}:
+	default:
+		c.logger.Warnf("Availability event for address ID %d dropped, event buffer full", address.ID)
 	}
 }
 
 // Start begins the health check process
 func (c *Checker) Start() {
+	for i := 0; i < c.workers; i++ {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.runWorker()
+		}()
+	}
+
 	c.wg.Add(1)
 	go func() {
 		defer c.wg.Done()
-		ticker := time.NewTicker(c.interval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				if err := c.checkAllBackends(); err != nil {
-					c.logger.Errorf("Error during health check: %v", err)
-				}
-			case <-c.stop:
-				c.logger.Info("Health checker stopped")
-				return
-			}
-		}
+		c.runScheduler()
 	}()
-	c.logger.Info("Health checker started")
+
+	if c.passive {
+		c.wg.Add(2)
+		go func() {
+			defer c.wg.Done()
+			c.watchFlows()
+		}()
+		go func() {
+			defer c.wg.Done()
+			c.runPassive()
+		}()
+		c.logger.Info("Passive health detection enabled")
+	}
+	c.logger.Infof("Health checker started with %d workers", c.workers)
 }
 
 // Stop ends the health check process
 func (c *Checker) Stop() {
 	close(c.stop)
 	c.wg.Wait()
+	c.flushSamples()
+}
+
+// Heartbeat returns the time the scheduler last ran. A stale heartbeat
+// means that no checks are being scheduled.
+func (c *Checker) Heartbeat() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.heartbeat
+}
+
+// beat records that the scheduler is running
+func (c *Checker) beat() {
+	c.mu.Lock()
+	c.heartbeat = time.Now()
+	c.mu.Unlock()
+}
+
+// leads reports whether this node records the cluster-wide availability
+func (c *Checker) leads() bool {
+	return c.isLeader == nil || c.isLeader()
 }
 
-// checkAllBackends performs health checks on all backend addresses
-func (c *Checker) checkAllBackends() error {
+// runsChecks reports whether this node runs the checks
+func (c *Checker) runsChecks() bool {
+	return c.nodeID != 0 || c.leads()
+}
+
+// ownAvailability returns the availability the routing of this node
+// follows. The caller must hold c.mu.
+func (c *Checker) ownAvailability(t *target) bool {
+	if c.nodeID != 0 {
+		return t.nodeAvailable
+	}
+	return t.address.Available
+}
+
+// runScheduler hands due checks to the workers until the checker is stopped
+func (c *Checker) runScheduler() {
+	ticker := time.NewTicker(scheduleResolution)
+	defer ticker.Stop()
+
+	var lastRefresh time.Time
+	leader := false
+	for {
+		// A new leader starts from the availability stored by the last one
+		if c.leads() != leader {
+			leader = !leader
+			lastRefresh = time.Time{}
+		}
+
+		if time.Since(lastRefresh) >= targetRefreshInterval {
+			if err := c.refreshTargets(); err != nil {
+				c.logger.Errorf("Error during health check: %v", err)
+			}
+			lastRefresh = time.Now()
+		}
+
+		if leader || c.nodeID != 0 {
+			c.dispatchDueTargets()
+		}
+		c.beat()
+
+		if c.sampleEvery > 0 && time.Since(c.sampleStartTime()) >= c.sampleEvery {
+			c.flushSamples()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-c.stop:
+			c.logger.Info("Health checker stopped")
+			return
+		}
+	}
+}
+
+// refreshTargets reloads the backend addresses and their check settings.
+// New addresses are scheduled at a random offset within their interval so
+// that checks are spread evenly instead of firing at the same instant.
+func (c *Checker) refreshTargets() error {
+	queried := time.Now()
+
 	// Get all backends from the database
 	backends, err := c.db.GetAllBackends()
 	if err != nil {
 		return fmt.Errorf("failed to get backends: %v", err)
 	}
 
-	// Check each backend address in parallel
-	var wg sync.WaitGroup
+	// Addresses this node has not checked yet start from the cluster-wide
+	// availability
+	var seenByNode map[uint]bool
+	if c.nodeID != 0 {
+		availabilities, err := c.db.GetNodeAvailabilities(c.nodeID)
+		if err != nil {
+			return fmt.Errorf("failed to get node availability: %v", err)
+		}
+		seenByNode = make(map[uint]bool, len(availabilities))
+		for _, availability := range availabilities {
+			seenByNode[availability.AddressID] = availability.Available
+		}
+	}
+	nodeAvailable := func(address models.Address) bool {
+		if available, ok := seenByNode[address.ID]; ok {
+			return available
+		}
+		return address.Available
+	}
+
+	if c.passive {
+		var addresses []models.Address
+		for _, backend := range backends {
+			addresses = append(addresses, backend.Addresses...)
+		}
+		c.setEndpoints(addresses)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := make(map[uint]bool)
 	for _, backend := range backends {
+		interval := c.interval
+		if backend.HealthCheckInterval > 0 {
+			interval = time.Duration(backend.HealthCheckInterval) * time.Second
+		}
+		settings := c.settingsFor(backend)
+
 		for _, address := range backend.Addresses {
-			wg.Add(1)
-			go func(address models.Address) {
-				defer wg.Done()
-				available, err := c.checkAddress(address.IP, address.Port)
-
-				// Only log and update if status changed
-				if available != address.Available {
-					var errStr string
-					if err != nil {
-						errStr = err.Error()
-					}
-
-					// Log the change to the database
-					if err := c.db.LogAvailabilityChange(address.ID, available, errStr); err != nil {
-						c.logger.Errorf("Failed to log availability change for address ID %d: %v", address.ID, err)
-					}
-
-					if available {
-						c.logger.Infof("Backend %s:%d is now available", address.IP, address.Port)
-					} else {
-						c.logger.Warnf("Backend %s:%d is now unavailable: %v", address.IP, address.Port, err)
-					}
+			seen[address.ID] = true
+			maintenance := backend.AdminState == models.AdminStateMaintenance ||
+				address.AdminState == models.AdminStateMaintenance
+
+			t, ok := c.targets[address.ID]
+			if !ok {
+				c.targets[address.ID] = &target{
+					address:       address,
+					interval:      interval,
+					settings:      settings,
+					next:          queried.Add(time.Duration(c.rng.Int63n(int64(interval)))),
+					nodeAvailable: nodeAvailable(address),
+					maintenance:   maintenance,
 				}
-			}(address)
+				continue
+			}
+
+			// Keep the availability known to the checker if a check
+			// finished after the database was queried
+			available := t.address.Available
+			t.address = address
+			if t.running || t.checked.After(queried) {
+				t.address.Available = available
+			} else {
+				t.nodeAvailable = nodeAvailable(address)
+			}
+
+			if interval != t.interval {
+				t.next = queried.Add(time.Duration(c.rng.Int63n(int64(interval))))
+			}
+			t.interval = interval
+			t.settings = settings
+			t.maintenance = maintenance
+		}
+	}
+
+	// Forget addresses that no longer exist
+	for id := range c.targets {
+		if !seen[id] {
+			delete(c.targets, id)
+			metrics.ForgetAddress(id)
 		}
 	}
 
-	wg.Wait()
 	return nil
 }
 
+// settingsFor returns the check settings of a backend's addresses, using
+// the global settings unless the backend overrides them
+func (c *Checker) settingsFor(backend models.Backend) checkSettings {
+	settings := checkSettings{
+		timeout:         c.checkTimeout,
+		sourceAddress:   c.sourceAddr,
+		sourceInterface: c.sourceIface,
+	}
+
+	if backend.HealthCheckTimeout > 0 {
+		settings.timeout = time.Duration(backend.HealthCheckTimeout) * time.Second
+	}
+	if backend.HealthCheckSourceAddress != "" {
+		settings.sourceAddress = backend.HealthCheckSourceAddress
+	}
+	if backend.HealthCheckSourceInterface != "" {
+		settings.sourceInterface = backend.HealthCheckSourceInterface
+	}
+	if backend.HealthCheckType == models.HealthCheckExec {
+		settings.checkType = models.HealthCheckExec
+		settings.command = backend.HealthCheckCommand
+		settings.args = backend.HealthCheckArgs
+	}
+
+	return settings
+}
+
+// dispatchDueTargets hands all due checks to idle workers. Checks that find
+// no idle worker stay due and are retried on the next scheduler tick.
+func (c *Checker) dispatchDueTargets() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, t := range c.targets {
+		if t.running || t.maintenance || now.Before(t.next) {
+			continue
+		}
+
+		select {
+		case c.jobs <- t:
+			t.running = true
+		default:
+			return
+		}
+	}
+}
+
+// runWorker performs checks handed out by the scheduler
+func (c *Checker) runWorker() {
+	for {
+		select {
+		case t := <-c.jobs:
+			c.runCheck(t)
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// runCheck checks a single address and records availability changes
+func (c *Checker) runCheck(t *target) {
+	c.mu.Lock()
+	address := t.address
+	own := c.ownAvailability(t)
+	nodeAvailable := t.nodeAvailable
+	settings := t.settings
+	c.mu.Unlock()
+
+	started := time.Now()
+	var available bool
+	var err error
+	if settings.checkType == models.HealthCheckExec {
+		available, err = c.execCheck(address.IP, address.Port, settings)
+	} else {
+		available, err = c.checkAddress(address.IP, address.Port, settings)
+	}
+	latency := time.Since(started)
+	// Samples are cluster-wide like the availability of the reports, so
+	// that nodes checking for their own view do not record them again
+	if c.leads() {
+		c.recordSample(address.ID, available, latency)
+	}
+
+	addressID := strconv.FormatUint(uint64(address.ID), 10)
+	metrics.HealthCheckDuration.WithLabelValues(addressID).Observe(latency.Seconds())
+	metrics.HealthChecks.WithLabelValues(addressID, metrics.Result(available)).Inc()
+
+	var errStr string
+	if err != nil {
+		errStr = err.Error()
+	}
+
+	// Only log and update if status changed. The cluster-wide availability
+	// is only recorded by the leader.
+	recorded := true
+	clusterChanged := false
+	if c.leads() && available != address.Available {
+		if err := c.db.LogAvailabilityChange(address.ID, available, errStr); err != nil {
+			c.logger.Errorf("Failed to log availability change for address ID %d: %v", address.ID, err)
+			recorded = false
+		} else {
+			clusterChanged = true
+		}
+	}
+	nodeChanged := false
+	if c.nodeID != 0 && available != nodeAvailable {
+		if err := c.db.LogNodeAvailabilityChange(c.nodeID, address.ID, available, errStr); err != nil {
+			c.logger.Errorf("Failed to log node availability change for address ID %d: %v", address.ID, err)
+			recorded = false
+		} else {
+			nodeChanged = true
+		}
+	}
+
+	// The updater follows the availability seen by this node
+	if available != own {
+		if nodeChanged || (c.nodeID == 0 && clusterChanged) {
+			c.notify(address, available)
+		}
+
+		if available {
+			c.logger.Infof("Backend %s:%d is now available", address.IP, address.Port)
+		} else {
+			c.logger.Warnf("Backend %s:%d is now unavailable: %v", address.IP, address.Port, err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if clusterChanged {
+		t.address.Available = available
+	}
+	if nodeChanged {
+		t.nodeAvailable = available
+	}
+	if recorded {
+		t.checked = time.Now()
+	}
+	t.running = false
+	t.next = time.Now().Add(c.jitteredInterval(t.interval))
+}
+
+// recordSample adds a check result to the current sampling period
+func (c *Checker) recordSample(addressID uint, available bool, latency time.Duration) {
+	c.samplesMu.Lock()
+	defer c.samplesMu.Unlock()
+
+	sample, ok := c.samples[addressID]
+	if !ok {
+		sample = &models.HealthCheckSample{AddressID: addressID}
+		c.samples[addressID] = sample
+	}
+	sample.AddCheck(available, latency)
+}
+
+// sampleStartTime returns the start of the current sampling period
+func (c *Checker) sampleStartTime() time.Time {
+	c.samplesMu.Lock()
+	defer c.samplesMu.Unlock()
+	return c.sampleStart
+}
+
+// flushSamples stores the samples of the current sampling period and
+// starts a new one
+func (c *Checker) flushSamples() {
+	c.samplesMu.Lock()
+	pending := c.samples
+	start := c.sampleStart
+	end := time.Now()
+	c.samples = make(map[uint]*models.HealthCheckSample)
+	c.sampleStart = end
+	c.samplesMu.Unlock()
+
+	samples := make([]models.HealthCheckSample, 0, len(pending))
+	for _, sample := range pending {
+		sample.PeriodStart = start
+		sample.PeriodEnd = end
+		samples = append(samples, *sample)
+	}
+
+	if err := c.db.RecordHealthCheckSamples(samples); err != nil {
+		c.logger.Errorf("Failed to record %d health check samples: %v", len(samples), err)
+	}
+}
+
+// jitteredInterval returns the interval moved by a random amount of up to
+// the configured jitter fraction. The caller must hold c.mu.
+func (c *Checker) jitteredInterval(interval time.Duration) time.Duration {
+	if c.jitter <= 0 {
+		return interval
+	}
+	offset := (c.rng.Float64()*2 - 1) * c.jitter * float64(interval)
+	return interval + time.Duration(offset)
+}
+
 // checkAddress tests if a TCP endpoint is reachable
-func (c *Checker) checkAddress(ip string, port int) (bool, error) {
-	address := fmt.Sprintf("%s:%d", ip, port)
-	conn, err := net.DialTimeout("tcp", address, c.checkTimeout)
+func (c *Checker) checkAddress(ip string, port int, settings checkSettings) (bool, error) {
+	dialer := net.Dialer{Timeout: settings.timeout}
+
+	if settings.sourceAddress != "" {
+		localIP := net.ParseIP(settings.sourceAddress)
+		if localIP == nil {
+			return false, fmt.Errorf("invalid source address: %s", settings.sourceAddress)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: localIP}
+	}
+
+	if settings.sourceInterface != "" {
+		dialer.Control = bindToInterface(settings.sourceInterface)
+	}
+
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	conn, err := dialer.Dial("tcp", address)
 	if err != nil {
 		return false, err
 	}
 	conn.Close()
 	return true, nil
 }
+
+// bindToInterface returns a dialer control function that binds the socket
+// to a network interface
+func bindToInterface(iface string) func(network, address string, rc syscall.RawConn) error {
+	return func(network, address string, rc syscall.RawConn) error {
+		var bindErr error
+		if err := rc.Control(func(fd uintptr) {
+			bindErr = unix.BindToDevice(int(fd), iface)
+		}); err != nil {
+			return err
+		}
+		if bindErr != nil {
+			return fmt.Errorf("failed to bind to interface %s: %v", iface, bindErr)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,203 @@
+package health
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/conntrack"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+)
+
+// passiveRetryDelay is the delay before watching conntrack events again
+// after an error
+const passiveRetryDelay = 10 * time.Second
+
+// pendingFlow is a connection whose TCP handshake has not completed yet
+type pendingFlow struct {
+	addressID uint
+	started   time.Time
+}
+
+// flowStats counts the connections to an address in the current window
+type flowStats struct {
+	flows    int
+	failures int
+}
+
+// runPassive evaluates the failure ratio of the connections to each
+// address once per window until the checker is stopped
+func (c *Checker) runPassive() {
+	ticker := time.NewTicker(c.passiveWindow)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if c.runsChecks() {
+				c.evaluatePassive()
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// watchFlows feeds conntrack events into the passive statistics, retrying
+// after errors until the checker is stopped
+func (c *Checker) watchFlows() {
+	for {
+		err := conntrack.WatchFlows(c.stop, c.handleFlowEvent)
+		if err == nil {
+			return
+		}
+		c.logger.Errorf("Passive health detection failed: %v", err)
+
+		select {
+		case <-time.After(passiveRetryDelay):
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// setEndpoints replaces the addresses watched by passive detection
+func (c *Checker) setEndpoints(addresses []models.Address) {
+	endpoints := make(map[netip.AddrPort]uint, len(addresses))
+	for _, address := range addresses {
+		ip, err := netip.ParseAddr(address.IP)
+		if err != nil {
+			continue
+		}
+		endpoints[netip.AddrPortFrom(ip.Unmap(), uint16(address.Port))] = address.ID
+	}
+
+	c.passiveMu.Lock()
+	c.endpoints = endpoints
+	c.passiveMu.Unlock()
+}
+
+// handleFlowEvent records the outcome of a connection. Connections count
+// as failed if they are reset or do not complete the TCP handshake.
+func (c *Checker) handleFlowEvent(event conntrack.FlowEvent) {
+	c.passiveMu.Lock()
+	defer c.passiveMu.Unlock()
+
+	switch event.Type {
+	case conntrack.FlowNew:
+		addressID, ok := c.endpoints[event.Backend]
+		if !ok {
+			return
+		}
+		c.statsFor(addressID).flows++
+		if !event.Established {
+			c.pendingFlows[event.ID] = pendingFlow{addressID: addressID, started: time.Now()}
+		}
+
+	case conntrack.FlowUpdate:
+		if event.Reset {
+			// Established connections that are reset count as failed too
+			if addressID, ok := c.endpoints[event.Backend]; ok {
+				c.statsFor(addressID).failures++
+			}
+			delete(c.pendingFlows, event.ID)
+			return
+		}
+		if event.Established {
+			delete(c.pendingFlows, event.ID)
+		}
+
+	case conntrack.FlowDestroy:
+		if flow, ok := c.pendingFlows[event.ID]; ok {
+			c.statsFor(flow.addressID).failures++
+			delete(c.pendingFlows, event.ID)
+		}
+	}
+}
+
+// statsFor returns the statistics of an address in the current window.
+// The caller must hold c.passiveMu.
+func (c *Checker) statsFor(addressID uint) *flowStats {
+	stats, ok := c.flowStats[addressID]
+	if !ok {
+		stats = &flowStats{}
+		c.flowStats[addressID] = stats
+	}
+	return stats
+}
+
+// evaluatePassive marks addresses unavailable whose failure ratio in the
+// last window exceeded the threshold. Addresses are only marked available
+// again by active checks.
+func (c *Checker) evaluatePassive() {
+	now := time.Now()
+
+	c.passiveMu.Lock()
+	// Connections that did not complete the handshake within the check
+	// timeout count as failed
+	for id, flow := range c.pendingFlows {
+		if now.Sub(flow.started) > c.checkTimeout {
+			c.statsFor(flow.addressID).failures++
+			delete(c.pendingFlows, id)
+		}
+	}
+	stats := c.flowStats
+	c.flowStats = make(map[uint]*flowStats)
+	c.passiveMu.Unlock()
+
+	for addressID, s := range stats {
+		if s.flows < c.passiveMinFlows {
+			continue
+		}
+		ratio := float64(s.failures) / float64(s.flows)
+		if ratio <= c.passiveRatio {
+			continue
+		}
+		c.markUnavailable(addressID, fmt.Errorf("%d of %d connections failed", s.failures, s.flows))
+	}
+}
+
+// markUnavailable records an address as unavailable unless it is being
+// checked or already unavailable. With a node view, only the view of this
+// node is changed, as the failed connections are those of this node.
+func (c *Checker) markUnavailable(addressID uint, reason error) {
+	c.mu.Lock()
+	t, ok := c.targets[addressID]
+	if !ok || t.running || t.maintenance || !c.ownAvailability(t) {
+		c.mu.Unlock()
+		return
+	}
+	// Keep active checks from running until the change is recorded
+	t.running = true
+	address := t.address
+	c.mu.Unlock()
+
+	var err error
+	if c.nodeID != 0 {
+		err = c.db.LogNodeAvailabilityChange(c.nodeID, address.ID, false, reason.Error())
+	} else {
+		err = c.db.LogAvailabilityChange(address.ID, false, reason.Error())
+	}
+
+	recorded := true
+	if err != nil {
+		c.logger.Errorf("Failed to log availability change for address ID %d: %v", address.ID, err)
+		recorded = false
+	} else {
+		c.notify(address, false)
+		c.logger.Warnf("Backend %s:%d is now unavailable: %v", address.IP, address.Port, reason)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if recorded {
+		if c.nodeID != 0 {
+			t.nodeAvailable = false
+		} else {
+			t.address.Available = false
+		}
+		t.checked = time.Now()
+	}
+	t.running = false
+}
@@ -0,0 +1,117 @@
+package maintenance
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/database"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Scheduler starts and ends maintenance windows
+type Scheduler struct {
+	db       *database.Service
+	logger   *logrus.Logger
+	interval time.Duration
+	isLeader func() bool
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Config for the maintenance scheduler
+type Config struct {
+	// Interval between checks for due maintenance windows
+	Interval time.Duration
+	// IsLeader reports whether this node processes the windows of its
+	// cluster. Windows are always processed if it is nil.
+	IsLeader func() bool
+}
+
+// NewScheduler creates a new maintenance window scheduler
+func NewScheduler(db *database.Service, config Config, logger *logrus.Logger) *Scheduler {
+	return &Scheduler{
+		db:       db,
+		logger:   logger,
+		interval: config.Interval,
+		isLeader: config.IsLeader,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start begins processing maintenance windows
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			if s.isLeader == nil || s.isLeader() {
+				if err := s.processDueWindows(); err != nil {
+					s.logger.Errorf("Error processing maintenance windows: %v", err)
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				s.logger.Info("Maintenance scheduler stopped")
+				return
+			}
+		}
+	}()
+	s.logger.Info("Maintenance scheduler started")
+}
+
+// Stop ends processing maintenance windows
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// processDueWindows activates windows that have started and completes
+// windows that have ended. Windows that were missed entirely are completed
+// without being activated.
+func (s *Scheduler) processDueWindows() error {
+	now := time.Now()
+	windows, err := s.db.GetDueMaintenanceWindows(now)
+	if err != nil {
+		return fmt.Errorf("failed to get due maintenance windows: %v", err)
+	}
+
+	for _, window := range windows {
+		changedBy := fmt.Sprintf("maintenance-window:%d", window.ID)
+
+		if window.Status == models.MaintenanceScheduled && now.Before(window.EndsAt) {
+			err := s.db.ActivateMaintenanceWindow(window.ID, changedBy)
+			if errors.Is(err, database.ErrWindowEntityDeleted) {
+				s.logger.Warnf("Maintenance window %d completed: %s ID %d no longer exists", window.ID, window.EntityType, window.EntityID)
+				continue
+			}
+			if err != nil {
+				s.logger.Errorf("Failed to start maintenance window %d: %v", window.ID, err)
+				continue
+			}
+			s.logger.Infof("Maintenance window %d started: %s ID %d is now %s", window.ID, window.EntityType, window.EntityID, window.AdminState)
+			continue
+		}
+
+		err := s.db.CompleteMaintenanceWindow(window.ID, changedBy)
+		if errors.Is(err, database.ErrWindowEntityDeleted) {
+			s.logger.Warnf("Maintenance window %d completed: %s ID %d no longer exists", window.ID, window.EntityType, window.EntityID)
+			continue
+		}
+		if err != nil {
+			s.logger.Errorf("Failed to end maintenance window %d: %v", window.ID, err)
+			continue
+		}
+		s.logger.Infof("Maintenance window %d ended for %s ID %d", window.ID, window.EntityType, window.EntityID)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/nftables"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// StateSource provides the stored state exported on each scrape
+type StateSource interface {
+	GetAllAddresses() ([]models.Address, error)
+	CountConfigChanges() ([]models.ConfigChangeCount, error)
+}
+
+// RuleCounterSource provides the nftables rule hit counters
+type RuleCounterSource interface {
+	RuleCounters() (map[uint]nftables.RuleCounter, error)
+}
+
+// Collector exports the stored state and the rule hit counters. The values
+// are read on each scrape, so they are never stale.
+type Collector struct {
+	state  StateSource
+	rules  RuleCounterSource
+	logger *logrus.Logger
+
+	addressAvailable *prometheus.Desc
+	configChanges    *prometheus.Desc
+	rulePackets      *prometheus.Desc
+	ruleBytes        *prometheus.Desc
+}
+
+// NewCollector creates a new collector
+func NewCollector(state StateSource, rules RuleCounterSource, logger *logrus.Logger) *Collector {
+	return &Collector{
+		state:  state,
+		rules:  rules,
+		logger: logger,
+		addressAvailable: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "address_available"),
+			"Whether a backend address is available (1) or not (0).",
+			[]string{"address_id", "backend_id", "address"}, nil,
+		),
+		configChanges: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "config_changes_total"),
+			"Number of configuration changes by entity type and change type.",
+			[]string{"entity_type", "change_type"}, nil,
+		),
+		rulePackets: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "rule_hits_packets_total"),
+			"Number of packets matched by a rule.",
+			[]string{"rule_id"}, nil,
+		),
+		ruleBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "rule_hits_bytes_total"),
+			"Number of bytes matched by a rule.",
+			[]string{"rule_id"}, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.addressAvailable
+	ch <- c.configChanges
+	ch <- c.rulePackets
+	ch <- c.ruleBytes
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	addresses, err := c.state.GetAllAddresses()
+	if err != nil {
+		c.logger.Errorf("Failed to collect address metrics: %v", err)
+		ch <- prometheus.NewInvalidMetric(c.addressAvailable, err)
+	}
+	for _, address := range addresses {
+		value := 0.0
+		if address.Available {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.addressAvailable, prometheus.GaugeValue, value,
+			strconv.FormatUint(uint64(address.ID), 10),
+			strconv.FormatUint(uint64(address.BackendID), 10),
+			net.JoinHostPort(address.IP, strconv.Itoa(address.Port)))
+	}
+
+	counts, err := c.state.CountConfigChanges()
+	if err != nil {
+		c.logger.Errorf("Failed to collect config change metrics: %v", err)
+		ch <- prometheus.NewInvalidMetric(c.configChanges, err)
+	}
+	for _, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.configChanges, prometheus.CounterValue, float64(count.Count),
+			count.EntityType, count.ChangeType)
+	}
+
+	counters, err := c.rules.RuleCounters()
+	if err != nil {
+		c.logger.Errorf("Failed to collect rule hit metrics: %v", err)
+		ch <- prometheus.NewInvalidMetric(c.rulePackets, err)
+	}
+	for id, counter := range counters {
+		ruleID := strconv.FormatUint(uint64(id), 10)
+		ch <- prometheus.MustNewConstMetric(c.rulePackets, prometheus.CounterValue, float64(counter.Packets), ruleID)
+		ch <- prometheus.MustNewConstMetric(c.ruleBytes, prometheus.CounterValue, float64(counter.Bytes), ruleID)
+	}
+}
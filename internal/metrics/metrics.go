@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// namespace is the prefix of all metric names
+const namespace = "b2b"
+
+// Results used as the result label
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var (
+	// HealthCheckDuration is the duration of health checks per address
+	HealthCheckDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "health_check_duration_seconds",
+		Help:      "Duration of health checks.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"address_id"})
+
+	// HealthChecks counts health checks per address and result
+	HealthChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "health_checks_total",
+		Help:      "Number of health checks by result.",
+	}, []string{"address_id", "result"})
+
+	// NFTablesApplyDuration is the duration of applying the ruleset
+	NFTablesApplyDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "nftables_apply_duration_seconds",
+		Help:      "Duration of applying the nftables ruleset.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// NFTablesApplies counts ruleset applies by result
+	NFTablesApplies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nftables_applies_total",
+		Help:      "Number of nftables ruleset applies by result.",
+	}, []string{"result"})
+
+	// ActiveRules is the number of rules in the last applied ruleset
+	ActiveRules = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_rules",
+		Help:      "Number of active rules in the last applied ruleset.",
+	})
+
+	// NFTablesInSync reports whether the kernel ruleset matched the last
+	// applied ruleset at the last drift check
+	NFTablesInSync = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "nftables_in_sync",
+		Help:      "Whether the kernel ruleset matched the applied ruleset at the last drift check (1) or not (0).",
+	})
+
+	// NFTablesDrifts counts drift checks that found differences
+	NFTablesDrifts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nftables_drifts_total",
+		Help:      "Number of drift checks that found differences between the kernel and the applied ruleset.",
+	})
+
+	// Leader reports whether this node is the leader of its cluster
+	Leader = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "Whether this node is the leader that runs health checks (1) or not (0).",
+	})
+
+	// ConfigChangeUpdates counts updates triggered by notifications of
+	// configuration changes
+	ConfigChangeUpdates = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_change_updates_total",
+		Help:      "Number of ruleset updates triggered by configuration change notifications.",
+	})
+
+	// DBQueryDuration is the duration of database queries by operation
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Duration of database queries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+)
+
+// Result returns the result label of an outcome
+func Result(success bool) string {
+	if success {
+		return ResultSuccess
+	}
+	return ResultFailure
+}
+
+// ForgetAddress removes the series of an address that no longer exists
+func ForgetAddress(addressID uint) {
+	labels := prometheus.Labels{"address_id": strconv.FormatUint(uint64(addressID), 10)}
+	HealthCheckDuration.DeletePartialMatch(labels)
+	HealthChecks.DeletePartialMatch(labels)
+}
@@ -1,12 +1,41 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"net"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// Administrative states of backends and addresses. Draining stops new
+// connections but keeps established ones, maintenance also drops
+// established connections.
+const (
+	AdminStateEnabled     = "enabled"
+	AdminStateDraining    = "draining"
+	AdminStateMaintenance = "maintenance"
+)
+
+// ValidAdminState checks if state is a known administrative state
+func ValidAdminState(state string) bool {
+	switch state {
+	case AdminStateEnabled, AdminStateDraining, AdminStateMaintenance:
+		return true
+	default:
+		return false
+	}
+}
+
+// Health check types. TCP checks connect to the address, exec checks run
+// an external command.
+const (
+	HealthCheckTCP  = "tcp"
+	HealthCheckExec = "exec"
+)
+
 // Backend represents a destination server
 type Backend struct {
 	gorm.Model
@@ -14,6 +43,19 @@ type Backend struct {
 	Description string       `json:"description"`
 	Addresses   []Address    `json:"addresses" gorm:"foreignKey:BackendID"`
 	BackendSets []BackendSet `json:"backend_sets" gorm:"many2many:backend_set_backends"`
+	AdminState  string       `json:"admin_state" gorm:"type:varchar(12);default:enabled;check:admin_state IN ('enabled', 'draining', 'maintenance')"`
+	// Health check overrides in seconds, 0 uses the global setting
+	HealthCheckInterval int `json:"health_check_interval,omitempty"`
+	HealthCheckTimeout  int `json:"health_check_timeout,omitempty"`
+	// Local address and interface health checks connect from, empty uses
+	// the global setting
+	HealthCheckSourceAddress   string `json:"health_check_source_address,omitempty"`
+	HealthCheckSourceInterface string `json:"health_check_source_interface,omitempty"`
+	// Type of health check, exec checks run HealthCheckCommand with
+	// HealthCheckArgs where {ip} and {port} are replaced by the address
+	HealthCheckType    string   `json:"health_check_type" gorm:"type:varchar(8);default:tcp;check:health_check_type IN ('tcp', 'exec')"`
+	HealthCheckCommand string   `json:"health_check_command,omitempty"`
+	HealthCheckArgs    []string `json:"health_check_args,omitempty" gorm:"type:text;serializer:json"`
 }
 
 // Address represents a backend server address
@@ -24,6 +66,7 @@ type Address struct {
 	Port        int       `json:"port"`
 	Available   bool      `json:"available" gorm:"default:true"`
 	LastChecked time.Time `json:"last_checked"`
+	AdminState  string    `json:"admin_state" gorm:"type:varchar(12);default:enabled;check:admin_state IN ('enabled', 'draining', 'maintenance')"`
 }
 
 // BackendSet represents a group of backends for load balancing
@@ -44,6 +87,8 @@ type SourceDefinition struct {
 	Subnet      string `json:"subnet,omitempty"`
 	RangeStart  string `json:"range_start,omitempty"`
 	RangeEnd    string `json:"range_end,omitempty"`
+	// Partner the source belongs to, restricts partner-scoped operators
+	Partner string `json:"partner,omitempty" gorm:"index"`
 }
 
 // Rule represents a routing rule
@@ -57,21 +102,172 @@ type Rule struct {
 	BackendSet         BackendSet       `json:"backend_set" gorm:"foreignKey:BackendSetID"`
 	Priority           int              `json:"priority" gorm:"default:0"`
 	Enabled            bool             `json:"enabled" gorm:"default:true"`
+	// Nodes and NodeGroups restrict the rule to ingress nodes by name or
+	// group, rules without either apply on every node
+	Nodes      []string `json:"nodes,omitempty" gorm:"type:text;serializer:json"`
+	NodeGroups []string `json:"node_groups,omitempty" gorm:"type:text;serializer:json"`
+}
+
+// AppliesTo reports whether the rule applies on the ingress node with the
+// given name and group
+func (r *Rule) AppliesTo(node, group string) bool {
+	if len(r.Nodes) == 0 && len(r.NodeGroups) == 0 {
+		return true
+	}
+	for _, name := range r.Nodes {
+		if name == node {
+			return true
+		}
+	}
+	for _, name := range r.NodeGroups {
+		if group != "" && name == group {
+			return true
+		}
+	}
+	return false
 }
 
 // ConfigChange represents a log of configuration changes
 type ConfigChange struct {
 	gorm.Model
 	ChangeType  string `json:"change_type" gorm:"type:varchar(10);check:change_type IN ('create', 'update', 'delete')"`
-	EntityType  string `json:"entity_type" gorm:"type:varchar(20);check:entity_type IN ('backend', 'address', 'backend_set', 'source_definition', 'rule')"`
-	EntityID    uint   `json:"entity_id"`
+	EntityType  string `json:"entity_type" gorm:"type:varchar(20);check:entity_type IN ('backend', 'address', 'backend_set', 'source_definition', 'rule', 'maintenance_window', 'api_token', 'role_assignment')"`
+	EntityID    uint   `json:"entity_id" gorm:"index"`
 	Description string `json:"description"`
-	ChangedBy   string `json:"changed_by"`
+	ChangedBy   string `json:"changed_by" gorm:"index"`
+	// Revision the change belongs to, 0 for changes made before revisions
+	// were recorded
+	Revision uint `json:"revision" gorm:"index"`
+	// Before and After are the stored state of the entity, Before is empty
+	// for creations and After for deletions
+	Before Snapshot `json:"before,omitempty" gorm:"type:jsonb"`
+	After  Snapshot `json:"after,omitempty" gorm:"type:jsonb"`
+}
+
+// Revision is a committed change of the configuration. The configuration
+// changes made in one transaction share a revision.
+type Revision struct {
+	ID          uint      `json:"id" gorm:"primarykey"`
+	CreatedAt   time.Time `json:"created_at"`
+	ChangedBy   string    `json:"changed_by"`
+	Description string    `json:"description"`
+	// Changes are only loaded for a single revision
+	Changes []ConfigChange `json:"changes,omitempty" gorm:"-"`
+}
+
+// Snapshot is a JSON document of the columns of an entity
+type Snapshot json.RawMessage
+
+// MarshalJSON embeds the document as is
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	if len(s) == 0 {
+		return []byte("null"), nil
+	}
+	return s, nil
+}
+
+// UnmarshalJSON stores a copy of the document
+func (s *Snapshot) UnmarshalJSON(data []byte) error {
+	*s = append((*s)[:0], data...)
+	return nil
+}
+
+// Value implements driver.Valuer
+func (s Snapshot) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	return string(s), nil
+}
+
+// Scan implements sql.Scanner
+func (s *Snapshot) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*s = nil
+	case []byte:
+		*s = append(Snapshot(nil), v...)
+	case string:
+		*s = Snapshot(v)
+	default:
+		return fmt.Errorf("unsupported snapshot type %T", value)
+	}
+	return nil
+}
+
+// ConfigChangeCount is the number of configuration changes of an entity
+// type and change type
+type ConfigChangeCount struct {
+	EntityType string
+	ChangeType string
+	Count      int64
+}
+
+// Maintenance window statuses
+const (
+	MaintenanceScheduled = "scheduled"
+	MaintenanceActive    = "active"
+	MaintenanceCompleted = "completed"
+)
+
+// MaintenanceWindow puts a backend or address into an administrative state
+// for a scheduled period of time
+type MaintenanceWindow struct {
+	gorm.Model
+	EntityType    string    `json:"entity_type" gorm:"type:varchar(20);check:entity_type IN ('backend', 'address')"`
+	EntityID      uint      `json:"entity_id"`
+	AdminState    string    `json:"admin_state" gorm:"type:varchar(12);check:admin_state IN ('draining', 'maintenance')"`
+	StartsAt      time.Time `json:"starts_at"`
+	EndsAt        time.Time `json:"ends_at"`
+	Description   string    `json:"description"`
+	Status        string    `json:"status" gorm:"type:varchar(10);default:scheduled;check:status IN ('scheduled', 'active', 'completed')"`
+	PreviousState string    `json:"previous_state,omitempty"`
+}
+
+// APIToken is a static token for authenticating API requests. Only the
+// hash of the token is stored.
+type APIToken struct {
+	gorm.Model
+	Name       string     `json:"name" gorm:"unique"`
+	TokenHash  string     `json:"-" gorm:"type:char(64);uniqueIndex"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// RoleAssignment grants a role to an API principal. Partner-scoped
+// operators are restricted to the source definitions of their partner.
+type RoleAssignment struct {
+	gorm.Model
+	Principal string `json:"principal" gorm:"unique"`
+	Role      string `json:"role" gorm:"type:varchar(16);check:role IN ('viewer', 'operator', 'partner_operator', 'admin')"`
+	Partner   string `json:"partner,omitempty"`
+}
+
+// IngressNode is a node of the ingress node registry. Nodes register
+// themselves when they start.
+type IngressNode struct {
+	gorm.Model
+	Name string `json:"name" gorm:"unique"`
+	// Group of nodes, e.g. a site, rules can be scoped to
+	Group string `json:"group,omitempty" gorm:"column:node_group;index"`
+}
+
+// NodeAvailability is the availability of a backend address as seen by an
+// ingress node
+type NodeAvailability struct {
+	ID          uint      `json:"-" gorm:"primarykey"`
+	NodeID      uint      `json:"node_id" gorm:"uniqueIndex:idx_node_address"`
+	AddressID   uint      `json:"address_id" gorm:"uniqueIndex:idx_node_address"`
+	Available   bool      `json:"available"`
+	LastChecked time.Time `json:"last_checked"`
 }
 
 // AvailabilityLog logs backend availability status changes
 type AvailabilityLog struct {
 	gorm.Model
+	// NodeID is the ingress node whose view changed, 0 for the
+	// cluster-wide availability
+	NodeID     uint      `json:"node_id,omitempty" gorm:"index"`
 	AddressID  uint      `json:"address_id"`
 	Address    Address   `json:"address" gorm:"foreignKey:AddressID"`
 	Available  bool      `json:"available"`
@@ -79,6 +275,56 @@ type AvailabilityLog struct {
 	CheckError string    `json:"check_error,omitempty"`
 }
 
+// LatencyBucketBounds are the upper bounds in milliseconds of the health
+// check latency histogram buckets. A final bucket counts everything above.
+var LatencyBucketBounds = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// HealthCheckSample aggregates the health check results of an address over
+// one sampling period. Latencies are only recorded for successful checks.
+type HealthCheckSample struct {
+	gorm.Model
+	AddressID      uint      `json:"address_id" gorm:"index"`
+	PeriodStart    time.Time `json:"period_start" gorm:"index"`
+	PeriodEnd      time.Time `json:"period_end"`
+	Checks         int       `json:"checks"`
+	Failures       int       `json:"failures"`
+	LatencySumMs   float64   `json:"latency_sum_ms"`
+	LatencyMinMs   float64   `json:"latency_min_ms"`
+	LatencyMaxMs   float64   `json:"latency_max_ms"`
+	LatencyBuckets []int64   `json:"latency_buckets" gorm:"type:text;serializer:json"`
+}
+
+// AddCheck records the result of a single health check in the sample
+func (h *HealthCheckSample) AddCheck(success bool, latency time.Duration) {
+	h.Checks++
+	if !success {
+		h.Failures++
+		return
+	}
+
+	if len(h.LatencyBuckets) != len(LatencyBucketBounds)+1 {
+		h.LatencyBuckets = make([]int64, len(LatencyBucketBounds)+1)
+	}
+
+	ms := float64(latency) / float64(time.Millisecond)
+	if h.Checks-h.Failures == 1 || ms < h.LatencyMinMs {
+		h.LatencyMinMs = ms
+	}
+	if ms > h.LatencyMaxMs {
+		h.LatencyMaxMs = ms
+	}
+	h.LatencySumMs += ms
+
+	bucket := len(LatencyBucketBounds)
+	for i, bound := range LatencyBucketBounds {
+		if ms <= bound {
+			bucket = i
+			break
+		}
+	}
+	h.LatencyBuckets[bucket]++
+}
+
 // Validate checks if a source definition is valid
 func (s *SourceDefinition) Validate() bool {
 	switch s.Type {
@@ -0,0 +1,87 @@
+package nftables
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/nftables"
+)
+
+// Drift describes the differences between the last applied ruleset and the
+// rules found in the kernel
+type Drift struct {
+	CheckedAt time.Time `json:"checked_at"`
+	InSync    bool      `json:"in_sync"`
+	// Rules that were applied but are missing from the kernel
+	Missing []string `json:"missing,omitempty"`
+	// Rules found in the kernel that were not applied by the manager
+	Unexpected []string `json:"unexpected,omitempty"`
+	// Set if the rules are the same but in a different order
+	Reordered bool `json:"reordered,omitempty"`
+	// Set if the chain could not be read, e.g. because it was deleted
+	Error string `json:"error,omitempty"`
+}
+
+// CheckDrift compares the rules in the kernel with the last applied ruleset.
+// It returns nil if no rules have been applied yet.
+func (m *Manager) CheckDrift() *Drift {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.applied == nil {
+		return nil
+	}
+
+	drift := &Drift{CheckedAt: time.Now()}
+
+	var expected []string
+	for _, outcome := range m.applied.Rules {
+		if outcome.Status == RuleApplied {
+			expected = append(expected, outcome.Rendered)
+		}
+	}
+
+	conn := &nftables.Conn{}
+	rules, err := conn.GetRules(m.table, m.chainPrerouting)
+	if err != nil {
+		drift.Error = fmt.Sprintf("failed to read chain %s: %v", m.chainPrerouting.Name, err)
+		drift.Missing = expected
+		return drift
+	}
+
+	live := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		live = append(live, RenderRule(rule.Exprs, rule.UserData))
+	}
+
+	// Compare the rules as multisets first, then their order
+	remaining := make(map[string]int, len(expected))
+	for _, rule := range expected {
+		remaining[rule]++
+	}
+	for _, rule := range live {
+		if remaining[rule] > 0 {
+			remaining[rule]--
+			continue
+		}
+		drift.Unexpected = append(drift.Unexpected, rule)
+	}
+	for _, rule := range expected {
+		if remaining[rule] > 0 {
+			remaining[rule]--
+			drift.Missing = append(drift.Missing, rule)
+		}
+	}
+
+	if len(drift.Missing) == 0 && len(drift.Unexpected) == 0 {
+		for i := range live {
+			if live[i] != expected[i] {
+				drift.Reordered = true
+				break
+			}
+		}
+	}
+
+	drift.InSync = len(drift.Missing) == 0 && len(drift.Unexpected) == 0 && !drift.Reordered
+	return drift
+}
@@ -0,0 +1,314 @@
+package nftables
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+
+	"github.com/google/nftables/expr"
+)
+
+// Statuses of rules matching a lookup
+const (
+	// The rule DNATs the traffic
+	MatchEffective = "effective"
+	// The rule matches but an earlier rule DNATs the traffic first
+	MatchShadowed = "shadowed"
+	// The rule matches but was skipped by the last apply
+	MatchSkipped = "skipped"
+	// The rule matches but has not been applied yet
+	MatchPending = "pending"
+)
+
+// Flow describes the traffic of a lookup
+type Flow struct {
+	SourceIP        string `json:"source_ip"`
+	DestinationIP   string `json:"destination_ip,omitempty"`
+	DestinationPort int    `json:"destination_port"`
+	Protocol        string `json:"protocol"`
+}
+
+// LookupMatch is a rule matching the traffic of a lookup
+type LookupMatch struct {
+	Rule   models.Rule `json:"rule"`
+	Status string      `json:"status"`
+	Reason string      `json:"reason,omitempty"`
+	// Backend address the rule DNATs to
+	Address string `json:"address,omitempty"`
+}
+
+// Lookup describes how the traffic of a flow is handled
+type Lookup struct {
+	Flow Flow `json:"flow"`
+	// Rule DNATing the traffic
+	Match *LookupMatch `json:"match"`
+	// All matching rules in the order they are evaluated
+	Matches []LookupMatch `json:"matches"`
+	// Backend set of the effective rule, or of the first matching rule if
+	// none is effective
+	BackendSet *models.BackendSet `json:"backend_set,omitempty"`
+	// Currently available addresses of the backend set
+	AvailableAddresses []models.Address `json:"available_addresses"`
+	// Reasons why the traffic might be dropped or not reach a backend
+	Warnings []string `json:"warnings"`
+}
+
+// packet holds the header fields the generated expressions match on
+type packet struct {
+	protocol        uint8
+	sourceIP        net.IP
+	destinationIP   net.IP
+	destinationPort uint16
+}
+
+// LookupFlow finds the rules matching a flow, evaluating the expressions
+// generated for each rule against it. Rules must be ordered like in
+// ApplyRules and addresses holds the currently available addresses of
+// each backend set.
+func (m *Manager) LookupFlow(flow Flow, rules []models.Rule, addresses map[uint][]models.Address) (*Lookup, error) {
+	p, err := flowPacket(flow)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := &Lookup{
+		Flow:               flow,
+		Matches:            []LookupMatch{},
+		AvailableAddresses: []models.Address{},
+		Warnings:           []string{},
+	}
+
+	if p.sourceIP.To4() == nil {
+		lookup.Warnings = append(lookup.Warnings, fmt.Sprintf("%s is not an IPv4 address, only IPv4 traffic is forwarded", flow.SourceIP))
+		return lookup, nil
+	}
+	if p.destinationIP != nil && !isLocalAddress(p.destinationIP) {
+		lookup.Warnings = append(lookup.Warnings, fmt.Sprintf("%s is not an address of this host, traffic only reaches the rules if it is routed through it", flow.DestinationIP))
+	}
+
+	m.mu.Lock()
+	outcomes := make(map[uint]RuleOutcome)
+	if m.applied != nil {
+		for _, outcome := range m.applied.Rules {
+			outcomes[outcome.RuleID] = outcome
+		}
+	} else {
+		lookup.Warnings = append(lookup.Warnings, "no rules have been applied yet")
+	}
+	m.mu.Unlock()
+
+	var first *models.Rule
+	var effective *models.Rule
+	for i, rule := range rules {
+		exprs, err := matchExpressions(rule)
+		if err != nil {
+			// The rule is not in the kernel, so it only matters if it was
+			// meant for this port
+			if rule.DestinationPort == flow.DestinationPort {
+				lookup.Warnings = append(lookup.Warnings, fmt.Sprintf("rule %d cannot be applied: %v", rule.ID, err))
+			}
+			continue
+		}
+		if !evaluate(exprs, p) {
+			continue
+		}
+		if first == nil {
+			first = &rules[i]
+		}
+
+		match := LookupMatch{Rule: rule}
+		outcome, ok := outcomes[rule.ID]
+		switch {
+		case !ok:
+			match.Status = MatchPending
+			lookup.Warnings = append(lookup.Warnings, fmt.Sprintf("rule %d has not been applied yet", rule.ID))
+		case outcome.Status == RuleSkipped:
+			match.Status = MatchSkipped
+			match.Reason = outcome.Reason
+			if effective == nil {
+				lookup.Warnings = append(lookup.Warnings, fmt.Sprintf("rule %d was skipped: %s", rule.ID, outcome.Reason))
+			}
+		case effective != nil:
+			match.Status = MatchShadowed
+			match.Reason = fmt.Sprintf("shadowed by rule %d", effective.ID)
+			match.Address = outcome.Address
+			if rule.Priority == effective.Priority {
+				lookup.Warnings = append(lookup.Warnings, fmt.Sprintf("rules %d and %d have the same priority, their order is undefined", effective.ID, rule.ID))
+			}
+		default:
+			match.Status = MatchEffective
+			match.Address = outcome.Address
+			effective = &rules[i]
+		}
+
+		lookup.Matches = append(lookup.Matches, match)
+	}
+
+	for i := range lookup.Matches {
+		if lookup.Matches[i].Status == MatchEffective {
+			lookup.Match = &lookup.Matches[i]
+		}
+	}
+
+	target := first
+	if effective != nil {
+		target = effective
+	}
+	if target == nil {
+		lookup.Warnings = append(lookup.Warnings, "no rule matches, the traffic is not forwarded")
+		return lookup, nil
+	}
+
+	backendSet := target.BackendSet
+	lookup.BackendSet = &backendSet
+	if available := addresses[target.BackendSetID]; len(available) > 0 {
+		lookup.AvailableAddresses = available
+	} else {
+		lookup.Warnings = append(lookup.Warnings, fmt.Sprintf("backend set %d has no available addresses", target.BackendSetID))
+	}
+
+	if effective != nil {
+		current := false
+		for _, address := range lookup.AvailableAddresses {
+			if address.ID == outcomes[effective.ID].AddressID {
+				current = true
+				break
+			}
+		}
+		if !current {
+			lookup.Warnings = append(lookup.Warnings, fmt.Sprintf("address %s is no longer available, it is replaced on the next update", lookup.Match.Address))
+		}
+	}
+
+	return lookup, nil
+}
+
+// flowPacket validates a flow and returns its header fields
+func flowPacket(flow Flow) (packet, error) {
+	var p packet
+
+	switch flow.Protocol {
+	case "tcp":
+		p.protocol = 6
+	case "udp":
+		p.protocol = 17
+	default:
+		return p, fmt.Errorf("unsupported protocol: %s", flow.Protocol)
+	}
+
+	if flow.DestinationPort < 1 || flow.DestinationPort > 65535 {
+		return p, fmt.Errorf("invalid destination port: %d", flow.DestinationPort)
+	}
+	p.destinationPort = uint16(flow.DestinationPort)
+
+	p.sourceIP = net.ParseIP(flow.SourceIP)
+	if p.sourceIP == nil {
+		return p, fmt.Errorf("invalid source IP address: %s", flow.SourceIP)
+	}
+	if flow.DestinationIP != "" {
+		p.destinationIP = net.ParseIP(flow.DestinationIP)
+		if p.destinationIP == nil {
+			return p, fmt.Errorf("invalid destination IP address: %s", flow.DestinationIP)
+		}
+	}
+
+	return p, nil
+}
+
+// evaluate reports whether a packet matches the expressions of a rule up
+// to its first statement
+func evaluate(exprs []expr.Any, p packet) bool {
+	registers := make(map[uint32][]byte)
+
+	for _, e := range exprs {
+		switch e := e.(type) {
+		case *expr.Meta:
+			if e.Key != expr.MetaKeyL4PROTO {
+				return false
+			}
+			registers[e.Register] = []byte{p.protocol}
+
+		case *expr.Payload:
+			registers[e.DestRegister] = p.load(e)
+
+		case *expr.Bitwise:
+			src := registers[e.SourceRegister]
+			dst := make([]byte, len(src))
+			for i := range src {
+				if i < len(e.Mask) && i < len(e.Xor) {
+					dst[i] = src[i]&e.Mask[i] ^ e.Xor[i]
+				}
+			}
+			registers[e.DestRegister] = dst
+
+		case *expr.Cmp:
+			if !compare(e.Op, bytes.Compare(registers[e.Register], e.Data)) {
+				return false
+			}
+
+		case *expr.Range:
+			value := registers[e.Register]
+			in := bytes.Compare(value, e.FromData) >= 0 && bytes.Compare(value, e.ToData) <= 0
+			if in != (e.Op == expr.CmpOpEq) {
+				return false
+			}
+
+		default:
+			// Counters and the DNAT statement follow the matches
+			return true
+		}
+	}
+
+	return true
+}
+
+// load returns the header field read by a payload expression
+func (p packet) load(e *expr.Payload) []byte {
+	switch {
+	case e.Base == expr.PayloadBaseNetworkHeader && e.Offset == 12 && e.Len == 4:
+		return p.sourceIP.To4()
+	case e.Base == expr.PayloadBaseNetworkHeader && e.Offset == 16 && e.Len == 4:
+		return p.destinationIP.To4()
+	case e.Base == expr.PayloadBaseTransportHeader && e.Offset == 2 && e.Len == 2:
+		return byteOrder(p.destinationPort)
+	default:
+		return nil
+	}
+}
+
+// compare applies a comparison operator to the result of bytes.Compare
+func compare(op expr.CmpOp, result int) bool {
+	switch op {
+	case expr.CmpOpEq:
+		return result == 0
+	case expr.CmpOpNeq:
+		return result != 0
+	case expr.CmpOpLt:
+		return result < 0
+	case expr.CmpOpLte:
+		return result <= 0
+	case expr.CmpOpGt:
+		return result > 0
+	case expr.CmpOpGte:
+		return result >= 0
+	default:
+		return false
+	}
+}
+
+// isLocalAddress reports whether an address is assigned to an interface of
+// this host
+func isLocalAddress(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return true
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
@@ -22,6 +23,18 @@ type Manager struct {
 	table           *nftables.Table
 	chainPrerouting *nftables.Chain
 	rng             *rand.Rand
+
+	// Packets and bytes matched by rules that have since been replaced
+	counters map[uint]RuleCounter
+
+	// State of the last successful apply
+	applied *AppliedState
+}
+
+// RuleCounter holds the packets and bytes matched by a rule
+type RuleCounter struct {
+	Packets uint64
+	Bytes   uint64
 }
 
 // Config for the nftables manager
@@ -58,6 +71,7 @@ func NewManager(config Config, logger *logrus.Logger) (*Manager, error) {
 		table:           table,
 		chainPrerouting: chainPrerouting,
 		rng:             rng,
+		counters:        make(map[uint]RuleCounter),
 	}
 
 	return manager, nil
@@ -106,21 +120,36 @@ func (m *Manager) ApplyRules(rules []models.Rule, addresses map[uint][]models.Ad
 		Priority: nftables.ChainPriorityFilter,
 	}
 
-	// Flush existing rules in the chain
+	// Keep the counters of the rules that are about to be replaced
+	current, err := m.readCounters(conn, table, chainPrerouting)
+	if err != nil {
+		m.logger.Warnf("Failed to read nftables rule counters: %v", err)
+	}
+	for id, counter := range current {
+		total := m.counters[id]
+		total.Packets += counter.Packets
+		total.Bytes += counter.Bytes
+		m.counters[id] = total
+	}
+
+	// Recreate the table and chain in case they were deleted, then flush
+	// the existing rules in the chain
+	conn.AddTable(table)
+	conn.AddChain(chainPrerouting)
 	conn.FlushChain(chainPrerouting)
 
 	// Add the new rules
+	outcomes := make([]RuleOutcome, 0, len(rules))
+	var rendered []string
 	for _, rule := range rules {
-		backendAddresses := addresses[rule.BackendSetID]
-		if len(backendAddresses) == 0 {
-			m.logger.Warnf("No available backend addresses for rule ID %d (BackendSet ID %d)", rule.ID, rule.BackendSetID)
-			continue
-		}
-
-		// Generate expressions for this rule
-		expressions, err := m.generateExpressionsForRule(rule, backendAddresses)
-		if err != nil {
-			m.logger.Errorf("Failed to generate expressions for rule ID %d: %v", rule.ID, err)
+		outcome, expressions := m.buildRule(rule, addresses[rule.BackendSetID])
+		outcomes = append(outcomes, outcome)
+		if outcome.Status != RuleApplied {
+			if len(addresses[rule.BackendSetID]) == 0 {
+				m.logger.Warnf("No available backend addresses for rule ID %d (BackendSet ID %d)", rule.ID, rule.BackendSetID)
+			} else {
+				m.logger.Errorf("Failed to generate expressions for rule ID %d: %s", rule.ID, outcome.Reason)
+			}
 			continue
 		}
 
@@ -129,8 +158,9 @@ func (m *Manager) ApplyRules(rules []models.Rule, addresses map[uint][]models.Ad
 			Table:    table,
 			Chain:    chainPrerouting,
 			Exprs:    expressions,
-			UserData: []byte(fmt.Sprintf("rule_id:%d", rule.ID)),
+			UserData: ruleUserData(rule),
 		})
+		rendered = append(rendered, outcome.Rendered)
 	}
 
 	// Apply the changes
@@ -138,12 +168,94 @@ func (m *Manager) ApplyRules(rules []models.Rule, addresses map[uint][]models.Ad
 		return fmt.Errorf("failed to apply nftables rules: %v", err)
 	}
 
+	m.applied = &AppliedState{
+		AppliedAt:        time.Now(),
+		BackendAddresses: addresses,
+		Rules:            outcomes,
+		Ruleset:          RenderChain(table, chainPrerouting, rendered),
+	}
+
 	m.logger.Info("nftables rules applied successfully")
 	return nil
 }
 
-// generateExpressionsForRule creates nftables expressions for a rule
-func (m *Manager) generateExpressionsForRule(rule models.Rule, addresses []models.Address) ([]expr.Any, error) {
+// buildRule generates the expressions of a rule DNATing to one of the
+// given addresses and describes the outcome
+func (m *Manager) buildRule(rule models.Rule, addresses []models.Address) (RuleOutcome, []expr.Any) {
+	outcome := RuleOutcome{
+		RuleID:          rule.ID,
+		Priority:        rule.Priority,
+		Protocol:        rule.Protocol,
+		DestinationPort: rule.DestinationPort,
+		SourceID:        rule.SourceDefinitionID,
+		BackendSetID:    rule.BackendSetID,
+		Status:          RuleSkipped,
+	}
+
+	if len(addresses) == 0 {
+		outcome.Reason = "no available backend addresses"
+		return outcome, nil
+	}
+
+	// Generate expressions for this rule
+	expressions, selected, err := m.generateExpressionsForRule(rule, addresses)
+	if err != nil {
+		outcome.Reason = err.Error()
+		return outcome, nil
+	}
+
+	outcome.Status = RuleApplied
+	outcome.AddressID = selected.ID
+	outcome.Address = net.JoinHostPort(selected.IP, strconv.Itoa(selected.Port))
+	outcome.Rendered = RenderRule(expressions, ruleUserData(rule))
+	return outcome, expressions
+}
+
+// ruleUserData returns the user data identifying a rule in nftables
+func ruleUserData(rule models.Rule) []byte {
+	return []byte(fmt.Sprintf("rule_id:%d", rule.ID))
+}
+
+// generateExpressionsForRule creates nftables expressions for a rule and
+// returns the backend address it was DNATed to
+func (m *Manager) generateExpressionsForRule(rule models.Rule, addresses []models.Address) ([]expr.Any, models.Address, error) {
+	expressions, err := matchExpressions(rule)
+	if err != nil {
+		return nil, models.Address{}, err
+	}
+
+	// Select a random backend address
+	selectedAddress := addresses[m.rng.Intn(len(addresses))]
+	destIP := net.ParseIP(selectedAddress.IP).To4()
+	if destIP == nil {
+		return nil, models.Address{}, fmt.Errorf("invalid backend IP address: %s", selectedAddress.IP)
+	}
+
+	// Count matched packets and add DNAT target
+	expressions = append(expressions,
+		&expr.Counter{},
+		&expr.Immediate{
+			Register: 1,
+			Data:     destIP,
+		},
+		&expr.Immediate{
+			Register: 2,
+			Data:     byteOrder(uint16(selectedAddress.Port)),
+		},
+		&expr.NAT{
+			Type:        expr.NATTypeDestNAT,
+			Family:      2, // AF_INET
+			RegAddrMin:  1,
+			RegProtoMin: 2,
+		},
+	)
+
+	return expressions, selectedAddress, nil
+}
+
+// matchExpressions creates the nftables expressions matching the traffic
+// of a rule
+func matchExpressions(rule models.Rule) ([]expr.Any, error) {
 	var expressions []expr.Any
 
 	// Match protocol (TCP/UDP)
@@ -262,32 +374,59 @@ func (m *Manager) generateExpressionsForRule(rule models.Rule, addresses []model
 		)
 	}
 
-	// Select a random backend address
-	selectedAddress := addresses[m.rng.Intn(len(addresses))]
-	destIP := net.ParseIP(selectedAddress.IP).To4()
-	if destIP == nil {
-		return nil, fmt.Errorf("invalid backend IP address: %s", selectedAddress.IP)
+	return expressions, nil
+}
+
+// RuleCounters returns the packets and bytes matched by each rule since
+// the manager was started
+func (m *Manager) RuleCounters() (map[uint]RuleCounter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, err := m.readCounters(&nftables.Conn{}, m.table, m.chainPrerouting)
+	if err != nil {
+		return nil, err
 	}
 
-	// Add DNAT target
-	expressions = append(expressions,
-		&expr.Immediate{
-			Register: 1,
-			Data:     destIP,
-		},
-		&expr.Immediate{
-			Register: 2,
-			Data:     byteOrder(uint16(selectedAddress.Port)),
-		},
-		&expr.NAT{
-			Type:        expr.NATTypeDestNAT,
-			Family:      2, // AF_INET
-			RegAddrMin:  1,
-			RegProtoMin: 2,
-		},
-	)
+	counters := make(map[uint]RuleCounter, len(m.counters))
+	for id, counter := range m.counters {
+		counters[id] = counter
+	}
+	for id, counter := range current {
+		total := counters[id]
+		total.Packets += counter.Packets
+		total.Bytes += counter.Bytes
+		counters[id] = total
+	}
 
-	return expressions, nil
+	return counters, nil
+}
+
+// readCounters returns the counters of the rules currently in the chain
+func (m *Manager) readCounters(conn *nftables.Conn, table *nftables.Table, chain *nftables.Chain) (map[uint]RuleCounter, error) {
+	rules, err := conn.GetRules(table, chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nftables rules: %v", err)
+	}
+
+	counters := make(map[uint]RuleCounter)
+	for _, rule := range rules {
+		var id uint
+		if _, err := fmt.Sscanf(string(rule.UserData), "rule_id:%d", &id); err != nil {
+			continue
+		}
+
+		for _, e := range rule.Exprs {
+			if counter, ok := e.(*expr.Counter); ok {
+				total := counters[id]
+				total.Packets += counter.Packets
+				total.Bytes += counter.Bytes
+				counters[id] = total
+			}
+		}
+	}
+
+	return counters, nil
 }
 
 // byteOrder converts a uint16 to network byte order
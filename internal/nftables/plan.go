@@ -0,0 +1,120 @@
+package nftables
+
+import (
+	"strings"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+)
+
+// Plan describes the ruleset that would result from a set of rules
+type Plan struct {
+	Rules []RuleOutcome `json:"rules"`
+	// Chain in nft syntax
+	Ruleset string `json:"ruleset"`
+	// Line diff against the last applied ruleset, lines are prefixed with
+	// "+" if added, "-" if removed and " " if unchanged
+	Diff []string `json:"diff"`
+}
+
+// Plan generates the ruleset for the given rules without applying it and
+// compares it with the last applied ruleset. Rules keep the backend address
+// they were last applied with while it is available, so the diff only
+// shows changes caused by the rules themselves.
+func (m *Manager) Plan(rules []models.Rule, addresses map[uint][]models.Address) *Plan {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	applied := make(map[uint]uint)
+	current := ""
+	if m.applied != nil {
+		for _, outcome := range m.applied.Rules {
+			if outcome.Status == RuleApplied {
+				applied[outcome.RuleID] = outcome.AddressID
+			}
+		}
+		current = m.applied.Ruleset
+	}
+
+	plan := &Plan{Rules: make([]RuleOutcome, 0, len(rules))}
+	var rendered []string
+	for _, rule := range rules {
+		candidates := addresses[rule.BackendSetID]
+		if addressID, ok := applied[rule.ID]; ok {
+			for _, address := range candidates {
+				if address.ID == addressID {
+					candidates = []models.Address{address}
+					break
+				}
+			}
+		}
+
+		outcome, _ := m.buildRule(rule, candidates)
+		plan.Rules = append(plan.Rules, outcome)
+		if outcome.Status == RuleApplied {
+			rendered = append(rendered, outcome.Rendered)
+		}
+	}
+
+	plan.Ruleset = RenderChain(m.table, m.chainPrerouting, rendered)
+	plan.Diff = DiffLines(current, plan.Ruleset)
+	return plan
+}
+
+// DiffLines returns a line diff turning a into b, based on the longest
+// common subsequence of their lines
+func DiffLines(a, b string) []string {
+	from := splitLines(a)
+	to := splitLines(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// from[i:] and to[j:]
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		switch {
+		case from[i] == to[j]:
+			diff = append(diff, " "+from[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+from[i])
+			i++
+		default:
+			diff = append(diff, "+"+to[j])
+			j++
+		}
+	}
+	for ; i < len(from); i++ {
+		diff = append(diff, "-"+from[i])
+	}
+	for ; j < len(to); j++ {
+		diff = append(diff, "+"+to[j])
+	}
+
+	return diff
+}
+
+// splitLines splits text into lines without the trailing empty line
+func splitLines(text string) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
@@ -0,0 +1,78 @@
+package nftables
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []string
+	}{
+		{
+			name: "both empty",
+			a:    "",
+			b:    "",
+			want: nil,
+		},
+		{
+			name: "equal",
+			a:    "a\nb\n",
+			b:    "a\nb",
+			want: []string{" a", " b"},
+		},
+		{
+			name: "from empty",
+			a:    "",
+			b:    "a\nb\n",
+			want: []string{"+a", "+b"},
+		},
+		{
+			name: "to empty",
+			a:    "a\nb\n",
+			b:    "\n",
+			want: []string{"-a", "-b"},
+		},
+		{
+			name: "line inserted",
+			a:    "a\nc\n",
+			b:    "a\nb\nc\n",
+			want: []string{" a", "+b", " c"},
+		},
+		{
+			name: "line removed",
+			a:    "a\nb\nc\n",
+			b:    "a\nc\n",
+			want: []string{" a", "-b", " c"},
+		},
+		{
+			name: "line changed",
+			a:    "a\nb\nc\n",
+			b:    "a\nB\nc\n",
+			want: []string{" a", "-b", "+B", " c"},
+		},
+		{
+			name: "lines reordered",
+			a:    "a\nb\nc\n",
+			b:    "c\na\nb\n",
+			want: []string{"+c", " a", " b", "-c"},
+		},
+		{
+			name: "repeated lines",
+			a:    "x\na\nx\n",
+			b:    "x\nx\n",
+			want: []string{" x", "-a", " x"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := DiffLines(test.a, test.b)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("DiffLines() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package nftables
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// register is the content of an nftables register while rendering
+type register struct {
+	// Expression loaded into the register, e.g. "ip saddr"
+	source string
+	// Mask applied to the loaded value
+	mask []byte
+	// Data stored by an immediate expression
+	data []byte
+}
+
+// RenderRule renders the expressions of a rule in nft syntax. Counter
+// values are omitted, so rules with the same matches render the same.
+func RenderRule(exprs []expr.Any, userData []byte) string {
+	var parts []string
+	registers := make(map[uint32]register)
+
+	for _, e := range exprs {
+		switch e := e.(type) {
+		case *expr.Meta:
+			if e.Key == expr.MetaKeyL4PROTO {
+				registers[e.Register] = register{source: "meta l4proto"}
+			} else {
+				registers[e.Register] = register{source: fmt.Sprintf("meta key %d", e.Key)}
+			}
+
+		case *expr.Payload:
+			registers[e.DestRegister] = register{source: payloadSource(e)}
+
+		case *expr.Bitwise:
+			reg := registers[e.SourceRegister]
+			reg.mask = e.Mask
+			registers[e.DestRegister] = reg
+
+		case *expr.Cmp:
+			reg := registers[e.Register]
+			parts = append(parts, match(reg.source, e.Op, formatValue(reg, e.Data)))
+
+		case *expr.Range:
+			reg := registers[e.Register]
+			parts = append(parts, match(reg.source, e.Op, formatValue(reg, e.FromData)+"-"+formatValue(reg, e.ToData)))
+
+		case *expr.Counter:
+			parts = append(parts, "counter")
+
+		case *expr.Immediate:
+			registers[e.Register] = register{data: e.Data}
+
+		case *expr.NAT:
+			target := net.IP(registers[e.RegAddrMin].data).String()
+			if port := registers[e.RegProtoMin].data; len(port) == 2 {
+				target = fmt.Sprintf("%s:%d", target, binary.BigEndian.Uint16(port))
+			}
+			if e.Type == expr.NATTypeDestNAT {
+				parts = append(parts, "dnat to "+target)
+			} else {
+				parts = append(parts, "snat to "+target)
+			}
+
+		default:
+			parts = append(parts, fmt.Sprintf("# unsupported %T", e))
+		}
+	}
+
+	if len(userData) > 0 {
+		parts = append(parts, fmt.Sprintf("comment %q", string(userData)))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// RenderChain renders a chain and its rules in nft syntax
+func RenderChain(table *nftables.Table, chain *nftables.Chain, rules []string) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "table %s %s {\n", familyName(table.Family), table.Name)
+	fmt.Fprintf(&b, "\tchain %s {\n", chain.Name)
+	if chain.Type != "" && chain.Hooknum != nil {
+		priority := 0
+		if chain.Priority != nil {
+			priority = int(*chain.Priority)
+		}
+		fmt.Fprintf(&b, "\t\ttype %s hook %s priority %d; policy accept;\n", chain.Type, hookName(*chain.Hooknum), priority)
+	}
+	for _, rule := range rules {
+		fmt.Fprintf(&b, "\t\t%s\n", rule)
+	}
+	b.WriteString("\t}\n}\n")
+
+	return b.String()
+}
+
+// payloadSource returns the nft name of a payload expression
+func payloadSource(p *expr.Payload) string {
+	switch {
+	case p.Base == expr.PayloadBaseNetworkHeader && p.Offset == 12 && p.Len == 4:
+		return "ip saddr"
+	case p.Base == expr.PayloadBaseNetworkHeader && p.Offset == 16 && p.Len == 4:
+		return "ip daddr"
+	case p.Base == expr.PayloadBaseTransportHeader && p.Offset == 0 && p.Len == 2:
+		return "th sport"
+	case p.Base == expr.PayloadBaseTransportHeader && p.Offset == 2 && p.Len == 2:
+		return "th dport"
+	case p.Base == expr.PayloadBaseNetworkHeader:
+		return fmt.Sprintf("@nh,%d,%d", p.Offset*8, p.Len*8)
+	case p.Base == expr.PayloadBaseTransportHeader:
+		return fmt.Sprintf("@th,%d,%d", p.Offset*8, p.Len*8)
+	default:
+		return fmt.Sprintf("@ll,%d,%d", p.Offset*8, p.Len*8)
+	}
+}
+
+// formatValue formats compared data according to the register content
+func formatValue(reg register, data []byte) string {
+	switch reg.source {
+	case "meta l4proto":
+		if len(data) == 1 {
+			switch data[0] {
+			case 6:
+				return "tcp"
+			case 17:
+				return "udp"
+			}
+			return fmt.Sprintf("%d", data[0])
+		}
+	case "ip saddr", "ip daddr":
+		if len(data) == 4 {
+			if reg.mask != nil {
+				ones, bits := net.IPMask(reg.mask).Size()
+				if bits != 0 && ones != bits {
+					return fmt.Sprintf("%s/%d", net.IP(data), ones)
+				}
+			}
+			return net.IP(data).String()
+		}
+	case "th sport", "th dport":
+		if len(data) == 2 {
+			return fmt.Sprintf("%d", binary.BigEndian.Uint16(data))
+		}
+	}
+	return fmt.Sprintf("0x%x", data)
+}
+
+// match renders a comparison of a loaded value
+func match(source string, op expr.CmpOp, value string) string {
+	if operator := cmpOp(op); operator != "" {
+		return fmt.Sprintf("%s %s %s", source, operator, value)
+	}
+	return fmt.Sprintf("%s %s", source, value)
+}
+
+// cmpOp returns the nft operator of a comparison; equality is implicit
+func cmpOp(op expr.CmpOp) string {
+	switch op {
+	case expr.CmpOpNeq:
+		return "!="
+	case expr.CmpOpLt:
+		return "<"
+	case expr.CmpOpLte:
+		return "<="
+	case expr.CmpOpGt:
+		return ">"
+	case expr.CmpOpGte:
+		return ">="
+	default:
+		return ""
+	}
+}
+
+// familyName returns the nft name of a table family
+func familyName(family nftables.TableFamily) string {
+	switch family {
+	case nftables.TableFamilyIPv4:
+		return "ip"
+	case nftables.TableFamilyIPv6:
+		return "ip6"
+	case nftables.TableFamilyINet:
+		return "inet"
+	default:
+		return fmt.Sprintf("family%d", family)
+	}
+}
+
+// hookName returns the nft name of a chain hook
+func hookName(hook nftables.ChainHook) string {
+	switch hook {
+	case *nftables.ChainHookPrerouting:
+		return "prerouting"
+	case *nftables.ChainHookInput:
+		return "input"
+	case *nftables.ChainHookForward:
+		return "forward"
+	case *nftables.ChainHookOutput:
+		return "output"
+	case *nftables.ChainHookPostrouting:
+		return "postrouting"
+	default:
+		return fmt.Sprintf("%d", hook)
+	}
+}
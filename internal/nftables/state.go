@@ -0,0 +1,48 @@
+package nftables
+
+import (
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+)
+
+// Rule outcomes of an apply
+const (
+	RuleApplied = "applied"
+	RuleSkipped = "skipped"
+)
+
+// RuleOutcome describes what an apply did with a rule
+type RuleOutcome struct {
+	RuleID          uint   `json:"rule_id"`
+	Priority        int    `json:"priority"`
+	Protocol        string `json:"protocol"`
+	DestinationPort int    `json:"destination_port"`
+	SourceID        uint   `json:"source_definition_id"`
+	BackendSetID    uint   `json:"backend_set_id"`
+	Status          string `json:"status"`
+	Reason          string `json:"reason,omitempty"`
+	// Backend address the rule DNATs to
+	AddressID uint   `json:"address_id,omitempty"`
+	Address   string `json:"address,omitempty"`
+	// Rule in nft syntax
+	Rendered string `json:"rendered,omitempty"`
+}
+
+// AppliedState describes the ruleset pushed by the last successful apply
+type AppliedState struct {
+	AppliedAt time.Time `json:"applied_at"`
+	// Available addresses of each backend set the rules were built from
+	BackendAddresses map[uint][]models.Address `json:"backend_addresses"`
+	Rules            []RuleOutcome             `json:"rules"`
+	// Chain in nft syntax
+	Ruleset string `json:"ruleset"`
+}
+
+// AppliedState returns the state of the last successful apply, or nil if
+// no rules have been applied yet
+func (m *Manager) AppliedState() *AppliedState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.applied
+}
@@ -0,0 +1,292 @@
+package report
+
+import (
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+)
+
+// AvailabilityReport summarizes the availability and health check latency
+// of an address, backend or backend set over a time range
+type AvailabilityReport struct {
+	Scope               string               `json:"scope"`
+	ID                  uint                 `json:"id"`
+	Name                string               `json:"name,omitempty"`
+	From                time.Time            `json:"from"`
+	To                  time.Time            `json:"to"`
+	AvailabilityPercent float64              `json:"availability_percent"`
+	DowntimeSeconds     float64              `json:"downtime_seconds"`
+	Outages             int                  `json:"outages"`
+	Checks              int                  `json:"checks"`
+	Failures            int                  `json:"failures"`
+	Latency             LatencyStats         `json:"latency"`
+	Addresses           []AvailabilityReport `json:"addresses,omitempty"`
+}
+
+// LatencyStats describes the latency of successful health checks
+type LatencyStats struct {
+	Samples int64   `json:"samples"`
+	MeanMs  float64 `json:"mean_ms"`
+	MinMs   float64 `json:"min_ms"`
+	MaxMs   float64 `json:"max_ms"`
+	P50Ms   float64 `json:"p50_ms"`
+	P90Ms   float64 `json:"p90_ms"`
+	P99Ms   float64 `json:"p99_ms"`
+}
+
+// interval is a period of time in which an address was unavailable
+type interval struct {
+	start time.Time
+	end   time.Time
+}
+
+// ForAddress builds the report of a single address. Logs must be ordered by
+// check time and may include the last log before the range; samples may
+// contain entries of other addresses which are ignored. Time before the
+// address was created is not part of its report.
+func ForAddress(address models.Address, logs []models.AvailabilityLog, samples []models.HealthCheckSample, from, to time.Time) AvailabilityReport {
+	start := from
+	if address.CreatedAt.After(start) {
+		start = address.CreatedAt
+	}
+	down := downIntervals(address, logs, start, to)
+
+	report := AvailabilityReport{
+		Scope:   "address",
+		ID:      address.ID,
+		Name:    address.IP,
+		From:    from,
+		To:      to,
+		Outages: len(down),
+	}
+	report.DowntimeSeconds, report.AvailabilityPercent = availability(down, start, to)
+	report.Checks, report.Failures, report.Latency = latency(samples, map[uint]bool{address.ID: true})
+
+	return report
+}
+
+// ForGroup builds the report of a backend or backend set. The group counts
+// as available while at least one of its addresses is available; the
+// per-address reports are included in the result.
+func ForGroup(scope string, id uint, name string, addresses []models.Address, logs []models.AvailabilityLog, samples []models.HealthCheckSample, from, to time.Time) AvailabilityReport {
+	report := AvailabilityReport{
+		Scope: scope,
+		ID:    id,
+		Name:  name,
+		From:  from,
+		To:    to,
+	}
+
+	// A group without addresses can never serve traffic
+	down := []interval{{start: from, end: to}}
+
+	ids := make(map[uint]bool, len(addresses))
+	for _, address := range addresses {
+		ids[address.ID] = true
+		report.Addresses = append(report.Addresses, ForAddress(address, logs, samples, from, to))
+
+		// An address cannot serve traffic before it was created
+		var addressDown []interval
+		start := from
+		if address.CreatedAt.After(start) {
+			start = address.CreatedAt
+			if start.After(to) {
+				start = to
+			}
+			addressDown = appendInterval(addressDown, interval{start: from, end: start})
+		}
+		for _, i := range downIntervals(address, logs, start, to) {
+			addressDown = appendInterval(addressDown, i)
+		}
+
+		down = intersect(down, addressDown)
+	}
+
+	report.Outages = len(down)
+	report.DowntimeSeconds, report.AvailabilityPercent = availability(down, from, to)
+	report.Checks, report.Failures, report.Latency = latency(samples, ids)
+
+	return report
+}
+
+// downIntervals reconstructs the periods between start and to in which the
+// address was unavailable. Addresses without a log before start are assumed
+// to have been available.
+func downIntervals(address models.Address, logs []models.AvailabilityLog, start, to time.Time) []interval {
+	var down []interval
+	if !to.After(start) {
+		return down
+	}
+
+	available := true
+	var downSince time.Time
+	for _, log := range logs {
+		if log.AddressID != address.ID {
+			continue
+		}
+
+		at := log.CheckTime
+		if at.Before(start) {
+			at = start
+		}
+		if !at.Before(to) {
+			break
+		}
+
+		if available && !log.Available {
+			downSince = at
+		} else if !available && log.Available {
+			down = appendInterval(down, interval{start: downSince, end: at})
+		}
+		available = log.Available
+	}
+
+	if !available {
+		down = appendInterval(down, interval{start: downSince, end: to})
+	}
+
+	return down
+}
+
+// appendInterval appends i to intervals, merging it with the last interval
+// if they touch
+func appendInterval(intervals []interval, i interval) []interval {
+	if !i.end.After(i.start) {
+		return intervals
+	}
+	if n := len(intervals); n > 0 && !intervals[n-1].end.Before(i.start) {
+		if i.end.After(intervals[n-1].end) {
+			intervals[n-1].end = i.end
+		}
+		return intervals
+	}
+	return append(intervals, i)
+}
+
+// intersect returns the periods contained in both sorted interval lists
+func intersect(a, b []interval) []interval {
+	var result []interval
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		start := a[i].start
+		if b[j].start.After(start) {
+			start = b[j].start
+		}
+		end := a[i].end
+		if b[j].end.Before(end) {
+			end = b[j].end
+		}
+		if end.After(start) {
+			result = appendInterval(result, interval{start: start, end: end})
+		}
+
+		if a[i].end.Before(b[j].end) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+// availability returns the total downtime in seconds and the availability
+// percentage of the range
+func availability(down []interval, from, to time.Time) (float64, float64) {
+	total := to.Sub(from)
+	if total <= 0 {
+		return 0, 100
+	}
+
+	var downtime time.Duration
+	for _, i := range down {
+		downtime += i.end.Sub(i.start)
+	}
+
+	return downtime.Seconds(), 100 * float64(total-downtime) / float64(total)
+}
+
+// latency merges the samples of the given addresses into check counts and
+// latency statistics
+func latency(samples []models.HealthCheckSample, addressIDs map[uint]bool) (int, int, LatencyStats) {
+	var checks, failures int
+	var stats LatencyStats
+	var sum float64
+	buckets := make([]int64, len(models.LatencyBucketBounds)+1)
+
+	for _, sample := range samples {
+		if !addressIDs[sample.AddressID] {
+			continue
+		}
+
+		checks += sample.Checks
+		failures += sample.Failures
+
+		successes := int64(sample.Checks - sample.Failures)
+		if successes <= 0 {
+			continue
+		}
+		if stats.Samples == 0 || sample.LatencyMinMs < stats.MinMs {
+			stats.MinMs = sample.LatencyMinMs
+		}
+		if sample.LatencyMaxMs > stats.MaxMs {
+			stats.MaxMs = sample.LatencyMaxMs
+		}
+		stats.Samples += successes
+		sum += sample.LatencySumMs
+		for i := 0; i < len(buckets) && i < len(sample.LatencyBuckets); i++ {
+			buckets[i] += sample.LatencyBuckets[i]
+		}
+	}
+
+	if stats.Samples > 0 {
+		stats.MeanMs = sum / float64(stats.Samples)
+		stats.P50Ms = percentile(buckets, 0.50, stats.MinMs, stats.MaxMs)
+		stats.P90Ms = percentile(buckets, 0.90, stats.MinMs, stats.MaxMs)
+		stats.P99Ms = percentile(buckets, 0.99, stats.MinMs, stats.MaxMs)
+	}
+
+	return checks, failures, stats
+}
+
+// percentile estimates the q-th percentile from the latency histogram by
+// interpolating linearly within the bucket that contains it. The result is
+// clamped to the observed minimum and maximum.
+func percentile(buckets []int64, q, min, max float64) float64 {
+	var total int64
+	for _, count := range buckets {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	bounds := models.LatencyBucketBounds
+
+	// Find the bucket containing the rank
+	i := 0
+	var below int64
+	for ; i < len(buckets)-1; i++ {
+		if float64(below+buckets[i]) >= rank {
+			break
+		}
+		below += buckets[i]
+	}
+
+	lower := min
+	if i > 0 && bounds[i-1] > lower {
+		lower = bounds[i-1]
+	}
+	upper := max
+	if i < len(bounds) && bounds[i] < upper {
+		upper = bounds[i]
+	}
+	if upper < lower {
+		return lower
+	}
+
+	fraction := 1.0
+	if buckets[i] > 0 {
+		fraction = (rank - float64(below)) / float64(buckets[i])
+	}
+	return lower + (upper-lower)*fraction
+}
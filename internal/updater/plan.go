@@ -0,0 +1,139 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/nftables"
+)
+
+// ErrInvalidChanges is returned by Plan if the changes reference unknown
+// rules, source definitions or backend sets
+var ErrInvalidChanges = errors.New("invalid changes")
+
+// Changes is a proposed set of configuration changes. New source
+// definitions and backend sets only affect the ruleset once a rule uses
+// them, so they are created beforehand and referenced by ID.
+type Changes struct {
+	// New rules without an ID and updated rules with their ID
+	Rules []models.Rule `json:"rules"`
+	// IDs of deleted rules
+	DeletedRules []uint `json:"deleted_rules"`
+	// Updated source definitions
+	SourceDefinitions []models.SourceDefinition `json:"source_definitions"`
+	// Updated backend sets with their backends
+	BackendSets []models.BackendSet `json:"backend_sets"`
+}
+
+// Plan builds the ruleset that would result from the proposed changes the
+// same way Update does, without applying it
+func (u *Updater) Plan(changes Changes) (*nftables.Plan, error) {
+	rules, err := u.ActiveRules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active rules: %v", err)
+	}
+
+	sources := make(map[uint]models.SourceDefinition, len(changes.SourceDefinitions))
+	for _, source := range changes.SourceDefinitions {
+		if _, err := u.db.GetSourceDefinition(source.ID); err != nil {
+			return nil, fmt.Errorf("%w: source definition %d not found", ErrInvalidChanges, source.ID)
+		}
+		sources[source.ID] = source
+	}
+
+	backendSets := make(map[uint]models.BackendSet, len(changes.BackendSets))
+	for _, backendSet := range changes.BackendSets {
+		if _, err := u.db.GetBackendSet(backendSet.ID); err != nil {
+			return nil, fmt.Errorf("%w: backend set %d not found", ErrInvalidChanges, backendSet.ID)
+		}
+		backendSets[backendSet.ID] = backendSet
+	}
+
+	// Replace updated and deleted rules, then add the proposed ones
+	replaced := make(map[uint]bool)
+	for _, id := range changes.DeletedRules {
+		replaced[id] = true
+	}
+	for _, rule := range changes.Rules {
+		if rule.ID == 0 {
+			continue
+		}
+		if _, err := u.db.GetRule(rule.ID); err != nil {
+			return nil, fmt.Errorf("%w: rule %d not found", ErrInvalidChanges, rule.ID)
+		}
+		replaced[rule.ID] = true
+	}
+
+	var planned []models.Rule
+	for _, rule := range rules {
+		if !replaced[rule.ID] {
+			planned = append(planned, rule)
+		}
+	}
+	for _, rule := range changes.Rules {
+		if !rule.Enabled {
+			continue
+		}
+
+		source, ok := sources[rule.SourceDefinitionID]
+		if !ok {
+			existing, err := u.db.GetSourceDefinition(rule.SourceDefinitionID)
+			if err != nil {
+				return nil, fmt.Errorf("%w: source definition %d not found", ErrInvalidChanges, rule.SourceDefinitionID)
+			}
+			source = *existing
+		}
+		rule.SourceDefinition = source
+
+		if _, ok := backendSets[rule.BackendSetID]; !ok {
+			if _, err := u.db.GetBackendSet(rule.BackendSetID); err != nil {
+				return nil, fmt.Errorf("%w: backend set %d not found", ErrInvalidChanges, rule.BackendSetID)
+			}
+		}
+		// Rules scoped to other nodes are checked but not planned
+		if !rule.AppliesTo(u.nodeName, u.nodeGroup) {
+			continue
+		}
+		planned = append(planned, rule)
+	}
+
+	// Apply updated source definitions to the existing rules
+	for i := range planned {
+		if source, ok := sources[planned[i].SourceDefinitionID]; ok {
+			planned[i].SourceDefinition = source
+		}
+	}
+
+	// Rules are applied in order of descending priority
+	sort.SliceStable(planned, func(i, j int) bool {
+		return planned[i].Priority > planned[j].Priority
+	})
+
+	// Get available backend addresses for each backend set
+	backendAddresses := make(map[uint][]models.Address)
+	for _, rule := range planned {
+		if _, ok := backendAddresses[rule.BackendSetID]; ok {
+			continue
+		}
+
+		var addresses []models.Address
+		if backendSet, ok := backendSets[rule.BackendSetID]; ok {
+			backendIDs := make([]uint, 0, len(backendSet.Backends))
+			for _, backend := range backendSet.Backends {
+				backendIDs = append(backendIDs, backend.ID)
+			}
+			addresses, err = u.db.GetAvailableAddressesOfBackends(backendIDs, u.nodeID)
+		} else {
+			addresses, err = u.AvailableAddresses(rule.BackendSetID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get addresses for backend set %d: %v", rule.BackendSetID, err)
+		}
+
+		backendAddresses[rule.BackendSetID] = addresses
+	}
+
+	return u.nft.Plan(planned, backendAddresses), nil
+}
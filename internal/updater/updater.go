@@ -0,0 +1,427 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sven-borkert/b2b-ingress-manager/internal/conntrack"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/health"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/metrics"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/models"
+	"github.com/sven-borkert/b2b-ingress-manager/internal/nftables"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Store is the storage the ruleset is built from
+type Store interface {
+	GetActiveRules() ([]models.Rule, error)
+	GetRule(id uint) (*models.Rule, error)
+	GetSourceDefinition(id uint) (*models.SourceDefinition, error)
+	GetBackendSet(id uint) (*models.BackendSet, error)
+	GetAvailableBackendAddresses(backendSetID, nodeID uint) ([]models.Address, error)
+	GetAvailableAddressesOfBackends(backendIDs []uint, nodeID uint) ([]models.Address, error)
+	GetMaintenanceAddresses() ([]models.Address, error)
+}
+
+// Updater keeps the nftables ruleset in sync with the database
+type Updater struct {
+	db       Store
+	nft      *nftables.Manager
+	events   <-chan health.Event
+	changes  <-chan string
+	logger   *logrus.Logger
+	interval time.Duration
+	debounce time.Duration
+	mu       sync.Mutex
+
+	// Drift detection settings
+	driftInterval time.Duration
+	driftReapply  bool
+
+	// Node the ruleset is built for
+	nodeName  string
+	nodeGroup string
+	nodeID    uint
+
+	// State of the last full update, reused by targeted reconciles
+	rules            []models.Rule
+	backendAddresses map[uint][]models.Address
+
+	// Addresses in maintenance whose connections have been dropped
+	maintenance map[uint]bool
+
+	statusMu sync.Mutex
+	status   Status
+	drift    *nftables.Drift
+}
+
+// Status describes the outcome of the recent nftables updates
+type Status struct {
+	LastAttempt time.Time `json:"last_attempt"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at"`
+}
+
+// Config for the updater
+type Config struct {
+	// Interval between full reconciles
+	Interval time.Duration
+	// Debounce is the window in which health events are collected
+	// before a targeted reconcile is triggered
+	Debounce time.Duration
+	// DriftInterval is the interval between comparisons of the kernel
+	// ruleset with the applied one, 0 disables drift detection
+	DriftInterval time.Duration
+	// DriftReapply re-applies the ruleset as soon as drift is detected
+	DriftReapply bool
+	// NodeName and NodeGroup select the rules scoped to this node
+	NodeName  string
+	NodeGroup string
+	// NodeID uses the availability seen by this node of the node registry
+	// instead of the cluster-wide availability, 0 for none
+	NodeID uint
+	// Changes receives notifications of committed configuration changes,
+	// which trigger a full update after the debounce window. The interval
+	// remains as a safety net for missed notifications. Nil disables them.
+	Changes <-chan string
+}
+
+// NewUpdater creates a new nftables updater. Availability changes received
+// on events trigger a targeted reconcile of the affected backend sets.
+func NewUpdater(db Store, nft *nftables.Manager, events <-chan health.Event, config Config, logger *logrus.Logger) *Updater {
+	return &Updater{
+		db:       db,
+		nft:      nft,
+		events:   events,
+		changes:  config.Changes,
+		logger:   logger,
+		interval: config.Interval,
+		debounce: config.Debounce,
+
+		driftInterval: config.DriftInterval,
+		driftReapply:  config.DriftReapply,
+
+		nodeName:  config.NodeName,
+		nodeGroup: config.NodeGroup,
+		nodeID:    config.NodeID,
+	}
+}
+
+// ActiveRules returns the enabled rules that apply on this node
+func (u *Updater) ActiveRules() ([]models.Rule, error) {
+	rules, err := u.db.GetActiveRules()
+	if err != nil {
+		return nil, err
+	}
+
+	active := rules[:0]
+	for _, rule := range rules {
+		if rule.AppliesTo(u.nodeName, u.nodeGroup) {
+			active = append(active, rule)
+		}
+	}
+	return active, nil
+}
+
+// AvailableAddresses returns the addresses of a backend set that are
+// available as seen by this node
+func (u *Updater) AvailableAddresses(backendSetID uint) ([]models.Address, error) {
+	return u.db.GetAvailableBackendAddresses(backendSetID, u.nodeID)
+}
+
+// Run periodically updates the nftables configuration until ctx is cancelled
+func (u *Updater) Run(ctx context.Context) {
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+
+	// Run the updater immediately on startup
+	if err := u.Update(); err != nil {
+		u.logger.Errorf("Failed to update nftables: %v", err)
+	}
+
+	// Backends whose availability changed since the last reconcile
+	pending := make(map[uint]struct{})
+	var debounceC <-chan time.Time
+
+	// Debounce of configuration changes, which are often made in bursts
+	var changeC <-chan time.Time
+
+	var driftC <-chan time.Time
+	if u.driftInterval > 0 {
+		driftTicker := time.NewTicker(u.driftInterval)
+		defer driftTicker.Stop()
+		driftC = driftTicker.C
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := u.Update(); err != nil {
+				u.logger.Errorf("Failed to update nftables: %v", err)
+			}
+		case event, ok := <-u.events:
+			if !ok {
+				u.events = nil
+				continue
+			}
+			pending[event.BackendID] = struct{}{}
+			// Start the debounce window on the first event only, so a
+			// steady stream of events cannot postpone the reconcile forever
+			if debounceC == nil {
+				debounceC = time.After(u.debounce)
+			}
+		case <-debounceC:
+			debounceC = nil
+			backendIDs := make([]uint, 0, len(pending))
+			for id := range pending {
+				backendIDs = append(backendIDs, id)
+			}
+			pending = make(map[uint]struct{})
+
+			if err := u.Reconcile(backendIDs); err != nil {
+				u.logger.Errorf("Failed to reconcile nftables: %v", err)
+			}
+		case entityType, ok := <-u.changes:
+			if !ok {
+				u.changes = nil
+				continue
+			}
+			u.logger.Debugf("Configuration change notified: %q", entityType)
+			if changeC == nil {
+				changeC = time.After(u.debounce)
+			}
+		case <-changeC:
+			changeC = nil
+			metrics.ConfigChangeUpdates.Inc()
+			if err := u.Update(); err != nil {
+				u.logger.Errorf("Failed to update nftables: %v", err)
+			}
+		case <-driftC:
+			u.checkDrift()
+		case <-ctx.Done():
+			u.logger.Info("Stopping config updater...")
+			return
+		}
+	}
+}
+
+// Status returns the outcome of the recent nftables updates
+func (u *Updater) Status() Status {
+	u.statusMu.Lock()
+	defer u.statusMu.Unlock()
+	return u.status
+}
+
+// recordResult updates the status after an update or reconcile
+func (u *Updater) recordResult(err error) {
+	u.statusMu.Lock()
+	defer u.statusMu.Unlock()
+
+	now := time.Now()
+	u.status.LastAttempt = now
+	if err != nil {
+		u.status.LastError = err.Error()
+		u.status.LastErrorAt = now
+		return
+	}
+	u.status.LastSuccess = now
+}
+
+// Drift returns the result of the last drift check, or nil if none was made
+func (u *Updater) Drift() *nftables.Drift {
+	u.statusMu.Lock()
+	defer u.statusMu.Unlock()
+	return u.drift
+}
+
+// checkDrift compares the kernel ruleset with the applied one and
+// re-applies it if configured
+func (u *Updater) checkDrift() {
+	drift := u.nft.CheckDrift()
+	if drift == nil {
+		return
+	}
+
+	u.statusMu.Lock()
+	u.drift = drift
+	u.statusMu.Unlock()
+
+	if drift.InSync {
+		metrics.NFTablesInSync.Set(1)
+		return
+	}
+	metrics.NFTablesInSync.Set(0)
+	metrics.NFTablesDrifts.Inc()
+
+	if drift.Error != "" {
+		u.logger.Warnf("nftables ruleset drifted: %s", drift.Error)
+	} else {
+		u.logger.Warnf("nftables ruleset drifted: %d rule(s) missing, %d unexpected rule(s), reordered: %v",
+			len(drift.Missing), len(drift.Unexpected), drift.Reordered)
+	}
+	for _, rule := range drift.Missing {
+		u.logger.Debugf("Missing nftables rule: %s", rule)
+	}
+	for _, rule := range drift.Unexpected {
+		u.logger.Debugf("Unexpected nftables rule: %s", rule)
+	}
+
+	if !u.driftReapply {
+		return
+	}
+	u.logger.Info("Re-applying nftables ruleset after drift")
+	if err := u.reapply(); err != nil {
+		u.logger.Errorf("Failed to re-apply nftables ruleset: %v", err)
+	}
+}
+
+// reapply pushes the last applied rules and addresses again
+func (u *Updater) reapply() (err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	defer func() { u.recordResult(err) }()
+
+	return u.apply(u.rules, u.backendAddresses)
+}
+
+// Update applies the current configuration to nftables
+func (u *Updater) Update() (err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	defer func() { u.recordResult(err) }()
+
+	// Get the active rules of this node from the database
+	rules, err := u.ActiveRules()
+	if err != nil {
+		return fmt.Errorf("failed to get active rules: %v", err)
+	}
+
+	u.logger.Debugf("Got %d active rules from database", len(rules))
+
+	// Get available backend addresses for each backend set
+	backendAddresses := make(map[uint][]models.Address)
+	for _, rule := range rules {
+		if _, ok := backendAddresses[rule.BackendSetID]; ok {
+			continue
+		}
+
+		addresses, err := u.AvailableAddresses(rule.BackendSetID)
+		if err != nil {
+			u.logger.Errorf("Failed to get addresses for backend set %d: %v", rule.BackendSetID, err)
+			continue
+		}
+
+		backendAddresses[rule.BackendSetID] = addresses
+	}
+
+	// Apply the rules to nftables
+	if err := u.apply(rules, backendAddresses); err != nil {
+		return err
+	}
+	metrics.ActiveRules.Set(float64(len(rules)))
+
+	u.rules = rules
+	u.backendAddresses = backendAddresses
+
+	u.dropMaintenanceFlows()
+	return nil
+}
+
+// dropMaintenanceFlows removes the established connections of addresses
+// that entered maintenance since the last update. Draining addresses keep
+// their connections, as DNAT only applies to new connections. The caller
+// must hold u.mu.
+func (u *Updater) dropMaintenanceFlows() {
+	addresses, err := u.db.GetMaintenanceAddresses()
+	if err != nil {
+		u.logger.Errorf("Failed to get addresses in maintenance: %v", err)
+		return
+	}
+
+	maintenance := make(map[uint]bool, len(addresses))
+	for _, address := range addresses {
+		maintenance[address.ID] = true
+		if u.maintenance[address.ID] {
+			continue
+		}
+
+		deleted, err := conntrack.DeleteFlowsTo(address.IP, address.Port)
+		if err != nil {
+			u.logger.Errorf("Failed to drop connections to %s:%d: %v", address.IP, address.Port, err)
+			// Retry on the next update
+			delete(maintenance, address.ID)
+			continue
+		}
+		u.logger.Infof("Dropped %d connections to %s:%d for maintenance", deleted, address.IP, address.Port)
+	}
+
+	u.maintenance = maintenance
+}
+
+// Reconcile refreshes the available addresses of the backend sets that
+// contain any of the given backends and re-applies the last known rules.
+// It falls back to a full update if no rules have been loaded yet.
+func (u *Updater) Reconcile(backendIDs []uint) (err error) {
+	u.mu.Lock()
+	if u.rules == nil {
+		u.mu.Unlock()
+		return u.Update()
+	}
+	defer u.mu.Unlock()
+	defer func() { u.recordResult(err) }()
+
+	changed := make(map[uint]bool, len(backendIDs))
+	for _, id := range backendIDs {
+		changed[id] = true
+	}
+
+	// Collect the backend sets affected by the changed backends
+	affected := make(map[uint]bool)
+	for _, rule := range u.rules {
+		for _, backend := range rule.BackendSet.Backends {
+			if changed[backend.ID] {
+				affected[rule.BackendSetID] = true
+				break
+			}
+		}
+	}
+
+	if len(affected) == 0 {
+		u.logger.Debugf("Availability change of backends %v does not affect any active rule", backendIDs)
+		return nil
+	}
+
+	backendAddresses := make(map[uint][]models.Address, len(u.backendAddresses))
+	for id, addresses := range u.backendAddresses {
+		backendAddresses[id] = addresses
+	}
+	for id := range affected {
+		addresses, err := u.AvailableAddresses(id)
+		if err != nil {
+			return fmt.Errorf("failed to get addresses for backend set %d: %v", id, err)
+		}
+		backendAddresses[id] = addresses
+	}
+
+	u.logger.Infof("Reconciling nftables for %d backend set(s) after availability change", len(affected))
+
+	if err := u.apply(u.rules, backendAddresses); err != nil {
+		return err
+	}
+
+	u.backendAddresses = backendAddresses
+	return nil
+}
+
+// apply applies the rules to nftables and records the outcome
+func (u *Updater) apply(rules []models.Rule, backendAddresses map[uint][]models.Address) error {
+	started := time.Now()
+	err := u.nft.ApplyRules(rules, backendAddresses)
+	metrics.NFTablesApplyDuration.Observe(time.Since(started).Seconds())
+	metrics.NFTablesApplies.WithLabelValues(metrics.Result(err == nil)).Inc()
+	return err
+}